@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/oidc/oidctest"
+)
+
+// fake-idp runs a local OpenID Connect provider for developing single
+// sign-on. It signs in the configured user straight away, so never expose
+// it. Point the app at it with:
+//
+//	OIDC_ISSUER_URL=http://localhost:9000 OIDC_CLIENT_ID=diary OIDC_CLIENT_SECRET=secret
+func main() {
+	addr := flag.String("addr", "localhost:9000", "address to listen on")
+	clientID := flag.String("client-id", "diary", "OAuth client ID")
+	clientSecret := flag.String("client-secret", "secret", "OAuth client secret")
+	subject := flag.String("subject", "fake-user-1", "subject of the signed-in user")
+	email := flag.String("email", "sso-user@example.com", "email of the signed-in user")
+	verified := flag.Bool("email-verified", true, "claim the email address is verified")
+	flag.Parse()
+
+	provider, err := oidctest.New("http://"+*addr, *clientID, *clientSecret, oidctest.User{
+		Subject:       *subject,
+		Email:         *email,
+		EmailVerified: *verified,
+	})
+	if err != nil {
+		log.Fatalf("Failed to start provider: %v", err)
+	}
+
+	log.Printf("Fake identity provider at http://%s signing in %s", *addr, *email)
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
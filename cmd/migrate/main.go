@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/config"
+	"github.com/ahsanfayaz52/diaryservice/internal/db"
+	"github.com/joho/godotenv"
+)
+
+const usage = `usage: migrate <command>
+
+commands:
+  up            apply all pending migrations
+  down [N]      roll back the last N migrations (default 1)
+  status        list migrations and whether they are applied
+  force V       mark the schema as being at version V without running SQL
+`
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, continuing...")
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg := config.LoadConfig()
+
+	dbConn, err := db.Open(cfg.DBConfig())
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer dbConn.Close()
+
+	migrator, err := db.NewMigrator(dbConn, cfg.DBDriver)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	args := os.Args[2:]
+
+	switch os.Args[1] {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		n := 1
+		if len(args) > 0 {
+			if n, err = strconv.Atoi(args[0]); err != nil {
+				log.Fatalf("Invalid number of migrations %q", args[0])
+			}
+		}
+		err = migrator.Down(ctx, n)
+	case "status":
+		err = printStatus(ctx, migrator)
+	case "force":
+		if len(args) != 1 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		version, convErr := strconv.Atoi(args[0])
+		if convErr != nil {
+			log.Fatalf("Invalid version %q", args[0])
+		}
+		err = migrator.Force(ctx, version)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("migrate %s: %v", os.Args[1], err)
+	}
+	if os.Args[1] != "status" {
+		if err := printStatus(ctx, migrator); err != nil {
+			log.Fatalf("migrate status: %v", err)
+		}
+	}
+}
+
+func printStatus(ctx context.Context, migrator *db.Migrator) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, st := range statuses {
+		state := "pending"
+		if st.Applied {
+			state = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if st.Dirty {
+			state = "DIRTY"
+		}
+		fmt.Printf("%04d  %-40s %s\n", st.Version, st.Name, state)
+	}
+	return nil
+}
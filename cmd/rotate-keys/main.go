@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/config"
+	"github.com/ahsanfayaz52/diaryservice/internal/db"
+	"github.com/ahsanfayaz52/diaryservice/internal/encryption"
+	"github.com/ahsanfayaz52/diaryservice/internal/jobs"
+	"github.com/ahsanfayaz52/diaryservice/internal/keystore"
+	"github.com/joho/godotenv"
+)
+
+// rotate-keys re-encrypts every stored ciphertext under the active key.
+// Notes are encrypted with per-user data keys, so rotating the master key
+// only rewrites the wrapped data keys in user_keys, plus any values not yet
+// moved onto a data key.
+//
+// To rotate: add the new key to ENCRYPTION_KEYS, point
+// ENCRYPTION_ACTIVE_KEY_ID at it, restart the server so new writes use it,
+// then run this command. Once it reports no failures the old key can be
+// removed from the keyring.
+func main() {
+	batch := flag.Int("batch", 500, "rows to re-encrypt per batch")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, continuing...")
+	}
+
+	cfg := config.LoadConfig()
+
+	keyring, err := cfg.Keyring()
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+	encryptionSvc, err := encryption.NewService(keyring)
+	if err != nil {
+		log.Fatalf("Failed to initialize encryption service: %v", err)
+	}
+
+	dbConn, err := db.Open(cfg.DBConfig())
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer dbConn.Close()
+
+	fmt.Printf("keys: %s, active: %s\n", strings.Join(keyring.IDs(), ", "), encryptionSvc.ActiveKeyID())
+
+	r := jobs.NewReencryptor(dbConn, encryptionSvc, keystore.NewStore(dbConn, encryptionSvc))
+	r.BatchSize = *batch
+	r.Pause = 0
+	r.Progress = func(p jobs.ReencryptProgress) {
+		fmt.Printf("%s.%s: scanned %d/%d, re-encrypted %d, failed %d\n", p.Table, p.Column, p.Scanned, p.Total, p.Upgraded, p.Failed)
+	}
+
+	results, err := r.Run(context.Background())
+	if err != nil {
+		log.Fatalf("rotate-keys: %v", err)
+	}
+
+	failed := 0
+	for _, p := range results {
+		fmt.Printf("%-30s %d re-encrypted, %d already current, %d failed\n",
+			p.Table+"."+p.Column, p.Upgraded, p.Scanned-p.Upgraded-p.Failed, p.Failed)
+		failed += p.Failed
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d values could not be decrypted; keep the old keys until they are resolved\n", failed)
+		os.Exit(1)
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
 	"github.com/ahsanfayaz52/diaryservice/internal/encryption"
+	"github.com/ahsanfayaz52/diaryservice/internal/jobs"
+	"github.com/ahsanfayaz52/diaryservice/internal/keystore"
+	"github.com/ahsanfayaz52/diaryservice/internal/mail"
 	"github.com/ahsanfayaz52/diaryservice/internal/middleware"
+	"github.com/ahsanfayaz52/diaryservice/internal/oidc"
 	"github.com/ahsanfayaz52/diaryservice/internal/stripe"
+	"github.com/ahsanfayaz52/diaryservice/internal/transcribe"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	"html/template"
@@ -11,10 +17,12 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/ahsanfayaz52/diaryservice/internal/ai"
 	"github.com/ahsanfayaz52/diaryservice/internal/auth"
 	"github.com/ahsanfayaz52/diaryservice/internal/config"
 	"github.com/ahsanfayaz52/diaryservice/internal/db"
 	"github.com/ahsanfayaz52/diaryservice/internal/handlers"
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
 )
 
 func main() {
@@ -26,37 +34,100 @@ func main() {
 
 	cfg := config.LoadConfig()
 
-	dbConn := db.InitDB(cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBName)
+	dbConn := db.InitDB(cfg.DBConfig())
 	defer dbConn.Close()
 
 	stripeSvc := stripe.NewService(cfg.StripeConfig())
 
-	encryptionSvc, err := encryption.NewService(cfg.EncryptionKey)
+	keyring, err := cfg.Keyring()
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+
+	encryptionSvc, err := encryption.NewService(keyring)
 	if err != nil {
 		log.Fatalf("Failed to initialize encryption service: %v", err)
 	}
 
-	jwtService := auth.NewJWTService(cfg.JWTSecret)
+	jwtService := auth.NewJWTService(cfg.JWTSecret, time.Duration(cfg.AccessTokenTTLMinutes)*time.Minute)
+	sessions := auth.NewSessions(dbConn, jwtService, time.Duration(cfg.SessionTTLDays)*24*time.Hour, cfg.TrustProxy)
+	tokens := auth.NewOneTimeTokens(dbConn)
+	apiTokens := auth.NewAPITokens(dbConn)
+	twoFactor := auth.NewTwoFactor(dbConn, encryptionSvc, jwtService)
+	passkeys, err := auth.NewPasskeys(dbConn, jwtService, cfg.AppBaseURL)
+	if err != nil {
+		log.Fatalf("Failed to initialize passkeys: %v", err)
+	}
+
+	var sso *auth.SSO
+	if oidcCfg := cfg.OIDCConfig(); oidcCfg.Enabled() {
+		sso = auth.NewSSO(dbConn, jwtService, oidc.New(oidcCfg))
+	}
+
+	mailer, err := mail.New(cfg.MailConfig())
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+	verifier := auth.NewEmailVerifier(dbConn, tokens, mailer, cfg.AppBaseURL)
+	throttle := auth.NewLoginThrottle(dbConn, tokens, mailer, cfg.AppBaseURL)
+	aiFree, aiSubscriber := cfg.AIQuota()
+	aiQuota := ai.NewQuota(dbConn, aiFree, aiSubscriber)
+	aiProvider, err := ai.New(cfg.AIConfig())
+	if err != nil {
+		log.Fatalf("Failed to initialize AI provider: %v", err)
+	}
+	transcriber, err := transcribe.New(cfg.TranscribeConfig())
+	if err != nil {
+		log.Fatalf("Failed to initialize transcription: %v", err)
+	}
+
+	keys := keystore.NewStore(dbConn, encryptionSvc)
+	noteRepo := repository.NewNoteRepository(dbConn, keys)
+	meetingRepo := repository.NewMeetingRepository(dbConn, keys)
+	recordingRepo := repository.NewRecordingRepository(dbConn, keys)
+
+	// Encrypt legacy plaintext titles and tags, move notes onto per-user
+	// data keys and upgrade older encryption formats without downtime
+	go jobs.RunReencryption(context.Background(), jobs.NewReencryptor(dbConn, encryptionSvc, keys))
+	go jobs.RunIndexBackfill(context.Background(), noteRepo, 100, 200*time.Millisecond)
+	go jobs.RunTrashPurger(context.Background(), noteRepo, time.Duration(cfg.TrashRetentionDays)*24*time.Hour, time.Hour)
+	go jobs.RunSessionCleanup(context.Background(), sessions, 7*24*time.Hour, time.Hour)
+	go jobs.RunMeetingCleanup(context.Background(), meetingRepo, 24*time.Hour, time.Hour)
+	go jobs.RunTranscription(context.Background(), recordingRepo, transcriber, aiQuota, time.Duration(cfg.TranscribeTimeoutSeconds)*time.Second, 2*time.Second)
+	go jobs.RunRecordingCleanup(context.Background(), recordingRepo, 24*time.Hour, time.Hour)
+	go jobs.RunThrottleCleanup(context.Background(), throttle, 24*time.Hour, time.Hour)
+	go jobs.RunPasskeyCleanup(context.Background(), passkeys, time.Hour)
 
 	r := mux.NewRouter()
+	// Stripe signs its webhook calls instead
+	r.Use(middleware.CSRF(cfg.JWTSecret, "/api/subscription/webhook"))
 
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusFound)
 	})
 
 	// Handlers
-	subscriptionHandler := handlers.NewSubscriptionHandler(dbConn, stripeSvc, cfg)
-
-	r.HandleFunc("/register", handlers.RegisterHandler(dbConn)).Methods("GET", "POST")
-	r.HandleFunc("/login", handlers.LoginHandler(dbConn, jwtService)).Methods("GET", "POST")
-	r.HandleFunc("/logout", handlers.LogoutHandler()).Methods("GET")
-	r.HandleFunc("/ai/process", handlers.AIProcessHandler).Methods("POST")
-	r.HandleFunc("/ai/summarize-meeting", handlers.SummarizeMeetingHandler).Methods("POST")
+	subscriptionHandler := handlers.NewSubscriptionHandler(dbConn, stripeSvc, cfg, verifier)
+
+	r.HandleFunc("/register", handlers.RegisterHandler(dbConn, verifier)).Methods("GET", "POST")
+	r.HandleFunc("/login", handlers.LoginHandler(dbConn, sessions, twoFactor, throttle, sso)).Methods("GET", "POST")
+	r.HandleFunc("/login/2fa", handlers.LoginTwoFactorHandler(twoFactor, sessions, throttle)).Methods("GET", "POST")
+	r.HandleFunc("/login/passkey/begin", handlers.BeginPasskeyLoginHandler(passkeys)).Methods("POST")
+	r.HandleFunc("/login/passkey/finish", handlers.FinishPasskeyLoginHandler(passkeys, sessions)).Methods("POST")
+	if sso != nil {
+		r.HandleFunc("/login/sso", handlers.BeginSSOHandler(sso)).Methods("GET")
+		r.HandleFunc("/login/sso/callback", handlers.SSOCallbackHandler(sso, sessions, twoFactor)).Methods("GET")
+	}
+	r.HandleFunc("/unlock-account", handlers.UnlockAccountHandler(throttle)).Methods("GET")
+	r.HandleFunc("/logout", handlers.LogoutHandler(sessions)).Methods("GET")
+	r.HandleFunc("/forgot-password", handlers.ForgotPasswordHandler(dbConn, tokens, mailer, cfg.AppBaseURL)).Methods("GET", "POST")
+	r.HandleFunc("/reset-password", handlers.ResetPasswordHandler(dbConn, tokens, sessions, verifier)).Methods("GET", "POST")
+	r.HandleFunc("/verify-email", handlers.VerifyEmailHandler(verifier)).Methods("GET")
 	r.HandleFunc("/api/subscription/webhook", subscriptionHandler.WebhookHandler).Methods("POST")
 
 	// In your main router setup (main.go or routes.go)
 	r.HandleFunc("/privacy", func(w http.ResponseWriter, r *http.Request) {
-		tmpl := template.Must(template.ParseFiles(
+		tmpl := template.Must(template.New("base.html").Funcs(middleware.TemplateFuncs(r)).ParseFiles(
 			"templates/base.html",
 			"templates/privacy.html",
 		))
@@ -72,7 +143,7 @@ func main() {
 	})
 
 	r.HandleFunc("/terms", func(w http.ResponseWriter, r *http.Request) {
-		tmpl := template.Must(template.ParseFiles(
+		tmpl := template.Must(template.New("base.html").Funcs(middleware.TemplateFuncs(r)).ParseFiles(
 			"templates/base.html",
 			"templates/terms.html",
 		))
@@ -88,7 +159,7 @@ func main() {
 	})
 
 	r.HandleFunc("/about", func(w http.ResponseWriter, r *http.Request) {
-		tmpl := template.Must(template.ParseFiles(
+		tmpl := template.Must(template.New("base.html").Funcs(middleware.TemplateFuncs(r)).ParseFiles(
 			"templates/base.html",
 			"templates/about.html",
 		))
@@ -100,24 +171,70 @@ func main() {
 		}
 	})
 
+	// API routes, for the app's pages and for scripts with a personal
+	// access token limited to the scope each route requires
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(auth.APIMiddleware(sessions, apiTokens))
+	api.Use(middleware.SubscriptionCheck(dbConn, stripeSvc))
+
+	api.HandleFunc("/meeting/start", auth.RequireScope(auth.ScopeMeetings, subscriptionHandler.MeetingStart)).Methods("POST")
+	api.HandleFunc("/meeting/end", auth.RequireScope(auth.ScopeMeetings, subscriptionHandler.MeetingEnd)).Methods("POST")
+	api.HandleFunc("/meeting/limits", auth.RequireScope(auth.ScopeMeetings, handlers.MeetingLimitsHandler(dbConn, stripeSvc))).Methods("GET")
+	api.HandleFunc("/meetings/action-items", auth.RequireScope(auth.ScopeMeetings, handlers.ActionItemsHandler(meetingRepo))).Methods("GET")
+	api.HandleFunc("/meetings/{id:[0-9]+}", auth.RequireScope(auth.ScopeMeetings, handlers.MeetingHandler(meetingRepo))).Methods("GET")
+	api.HandleFunc("/subscription/checkout", auth.RequireScope(auth.ScopeSubscriptionWrite, subscriptionHandler.CreateCheckoutSession)).Methods("POST")
+	api.HandleFunc("/subscription/status", auth.RequireScope(auth.ScopeSubscriptionRead, subscriptionHandler.GetSubscriptionStatus)).Methods("GET")
+	api.HandleFunc("/subscription/cancel", auth.RequireScope(auth.ScopeSubscriptionWrite, subscriptionHandler.CancelSubscription)).Methods("POST")
+
 	// Authenticated routes
 	s := r.PathPrefix("/").Subrouter()
-	s.Use(auth.JWTMiddleware(jwtService))
+	s.Use(auth.JWTMiddleware(sessions))
 	s.Use(middleware.SubscriptionCheck(dbConn, stripeSvc))
 
 	s.HandleFunc("/subscription", subscriptionHandler.SubscriptionPageHandler).Methods("GET")
-	s.HandleFunc("/api/meeting/start", subscriptionHandler.MeetingStart).Methods("POST")
-	s.HandleFunc("/api/meeting/end", subscriptionHandler.MeetingEnd).Methods("POST")
-	s.HandleFunc("/api/subscription/checkout", subscriptionHandler.CreateCheckoutSession).Methods("POST")
-	s.HandleFunc("/api/subscription/status", subscriptionHandler.GetSubscriptionStatus).Methods("GET")
-	s.HandleFunc("/api/subscription/cancel", subscriptionHandler.CancelSubscription).Methods("POST")
-	s.HandleFunc("/api/meeting/limits", handlers.MeetingLimitsHandler(dbConn, stripeSvc)).Methods("GET")
-
-	s.HandleFunc("/dashboard", handlers.DashboardHandler(dbConn, encryptionSvc)).Methods("GET")
-	s.HandleFunc("/notes/new", handlers.NewNoteHandler(dbConn, stripeSvc, encryptionSvc)).Methods("GET", "POST")
-	s.HandleFunc("/notes/edit/{id}", handlers.EditNoteHandler(dbConn, stripeSvc, encryptionSvc)).Methods("GET", "POST")
-	s.HandleFunc("/notes/delete/{id}", handlers.DeleteNoteHandler(dbConn)).Methods("POST")
-	s.HandleFunc("/notes/view/{id}", handlers.ViewNoteHandler(dbConn, encryptionSvc)).Methods("GET")
+
+	s.HandleFunc("/ai/process", handlers.AIProcessHandler(dbConn, stripeSvc, aiQuota, aiProvider)).Methods("POST")
+	s.HandleFunc("/ai/process/stream", handlers.AIProcessStreamHandler(dbConn, stripeSvc, aiQuota, aiProvider)).Methods("POST")
+	s.HandleFunc("/ai/summarize-meeting", handlers.SummarizeMeetingHandler(dbConn, stripeSvc, aiQuota, aiProvider, meetingRepo)).Methods("POST")
+
+	s.HandleFunc("/recordings", handlers.CreateRecordingHandler(dbConn, stripeSvc, aiQuota, recordingRepo)).Methods("POST")
+	s.HandleFunc("/recordings/{id:[0-9]+}", handlers.RecordingHandler(recordingRepo)).Methods("GET")
+	s.HandleFunc("/recordings/{id:[0-9]+}/chunks/{seq:[0-9]+}", handlers.UploadRecordingChunkHandler(recordingRepo, int64(cfg.MaxRecordingMB)<<20)).Methods("PUT")
+	s.HandleFunc("/recordings/{id:[0-9]+}/finish", handlers.FinishRecordingHandler(recordingRepo)).Methods("POST")
+	s.HandleFunc("/recordings/{id:[0-9]+}/audio", handlers.RecordingAudioHandler(recordingRepo)).Methods("GET")
+
+	s.HandleFunc("/dashboard", handlers.DashboardHandler(noteRepo, meetingRepo, verifier)).Methods("GET")
+	s.HandleFunc("/notes/new", handlers.NewNoteHandler(dbConn, stripeSvc, noteRepo, meetingRepo, recordingRepo, verifier, cfg.UnverifiedNoteLimit)).Methods("GET", "POST")
+	s.HandleFunc("/notes/edit/{id}", handlers.EditNoteHandler(dbConn, stripeSvc, noteRepo, meetingRepo, recordingRepo)).Methods("GET", "POST")
+	s.HandleFunc("/notes/delete/{id}", handlers.DeleteNoteHandler(noteRepo)).Methods("POST")
+	s.HandleFunc("/notes/view/{id}", handlers.ViewNoteHandler(noteRepo, recordingRepo)).Methods("GET")
+	s.HandleFunc("/notes/{id}/history", handlers.NoteHistoryHandler(noteRepo)).Methods("GET")
+	s.HandleFunc("/notes/{id}/history/diff", handlers.NoteDiffHandler(noteRepo)).Methods("GET")
+	s.HandleFunc("/notes/{id}/history/{rev}/restore", handlers.RestoreRevisionHandler(noteRepo)).Methods("POST")
+	s.HandleFunc("/trash", handlers.TrashHandler(noteRepo, cfg.TrashRetentionDays)).Methods("GET")
+	s.HandleFunc("/trash/{id}/restore", handlers.RestoreFromTrashHandler(dbConn, stripeSvc, noteRepo)).Methods("POST")
+	s.HandleFunc("/trash/{id}/purge", handlers.PurgeNoteHandler(noteRepo)).Methods("POST")
+	s.HandleFunc("/tasks", handlers.TasksHandler(meetingRepo, noteRepo)).Methods("GET")
+	s.HandleFunc("/tasks/{id:[0-9]+}", handlers.UpdateTaskHandler(meetingRepo)).Methods("POST")
+	s.HandleFunc("/tasks/{id:[0-9]+}/status", handlers.UpdateTaskStatusHandler(meetingRepo)).Methods("POST")
+	s.HandleFunc("/settings/verify-email", handlers.VerificationPageHandler(verifier)).Methods("GET")
+	s.HandleFunc("/settings/verify-email/resend", handlers.ResendVerificationHandler(verifier)).Methods("POST")
+	s.HandleFunc("/settings/security", handlers.SecurityHandler(twoFactor)).Methods("GET")
+	s.HandleFunc("/settings/2fa/setup", handlers.SetupTwoFactorHandler(dbConn, twoFactor)).Methods("GET", "POST")
+	s.HandleFunc("/settings/2fa/enable", handlers.EnableTwoFactorHandler(dbConn, twoFactor)).Methods("POST")
+	s.HandleFunc("/settings/2fa/disable", handlers.DisableTwoFactorHandler(dbConn, twoFactor, sessions, throttle)).Methods("POST")
+	s.HandleFunc("/settings/2fa/recovery-codes", handlers.RegenerateRecoveryCodesHandler(dbConn, twoFactor, sessions, throttle)).Methods("POST")
+	s.HandleFunc("/settings/account/delete", handlers.DeleteAccountHandler(dbConn, stripeSvc, twoFactor, keys, sessions, throttle)).Methods("POST")
+	s.HandleFunc("/settings/passkeys", handlers.PasskeysHandler(passkeys)).Methods("GET")
+	s.HandleFunc("/settings/passkeys/register/begin", handlers.BeginPasskeyRegistrationHandler(passkeys)).Methods("POST")
+	s.HandleFunc("/settings/passkeys/register/finish", handlers.FinishPasskeyRegistrationHandler(passkeys)).Methods("POST")
+	s.HandleFunc("/settings/passkeys/{id}/delete", handlers.DeletePasskeyHandler(passkeys)).Methods("POST")
+	s.HandleFunc("/settings/tokens", handlers.APITokensHandler(apiTokens)).Methods("GET")
+	s.HandleFunc("/settings/tokens", handlers.CreateAPITokenHandler(apiTokens)).Methods("POST")
+	s.HandleFunc("/settings/tokens/{id}/revoke", handlers.RevokeAPITokenHandler(apiTokens)).Methods("POST")
+	s.HandleFunc("/settings/sessions", handlers.SessionsHandler(sessions)).Methods("GET")
+	s.HandleFunc("/settings/sessions/revoke-others", handlers.RevokeOtherSessionsHandler(sessions)).Methods("POST")
+	s.HandleFunc("/settings/sessions/{id}/revoke", handlers.RevokeSessionHandler(sessions)).Methods("POST")
 
 	// Serve static files
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
@@ -0,0 +1,118 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fake is a Provider that answers from a script instead of calling a model,
+// for tests and for running the app locally without an API key. Each call
+// takes the next scripted reply or error; once the script runs out, Chat
+// and Stream echo the last user message back and ChatJSON returns "{}".
+// Token counts are estimated from the length of the text.
+type Fake struct {
+	// Delay is how long Stream waits before sending each word.
+	Delay time.Duration
+
+	mu      sync.Mutex
+	script  []fakeReply
+	history [][]Message
+}
+
+type fakeReply struct {
+	content string
+	err     error
+}
+
+// NewFake returns a Fake that gives replies in order.
+func NewFake(replies ...string) *Fake {
+	f := &Fake{}
+	for _, r := range replies {
+		f.Reply(r)
+	}
+	return f
+}
+
+// Reply adds a reply to the end of the script.
+func (f *Fake) Reply(content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = append(f.script, fakeReply{content: content})
+}
+
+// Fail adds a call that returns err to the end of the script.
+func (f *Fake) Fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = append(f.script, fakeReply{err: err})
+}
+
+// Calls returns the messages of every call made so far, oldest first.
+func (f *Fake) Calls() [][]Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]Message(nil), f.history...)
+}
+
+func (f *Fake) Chat(ctx context.Context, messages []Message) (*Response, error) {
+	return f.next(ctx, messages, false)
+}
+
+func (f *Fake) ChatJSON(ctx context.Context, messages []Message) (*Response, error) {
+	return f.next(ctx, messages, true)
+}
+
+// Stream sends the reply a word at a time.
+func (f *Fake) Stream(ctx context.Context, messages []Message, onDelta func(string) error) (*Response, error) {
+	resp, err := f.next(ctx, messages, false)
+	if err != nil {
+		return nil, err
+	}
+	sent := ""
+	for _, word := range strings.SplitAfter(resp.Content, " ") {
+		var err error
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(f.Delay):
+			err = onDelta(word)
+		}
+		if err != nil {
+			if sent == "" {
+				return nil, err
+			}
+			return &Response{Content: sent, Tokens: estimateUsage(messages, sent)}, err
+		}
+		sent += word
+	}
+	return resp, nil
+}
+
+func (f *Fake) next(ctx context.Context, messages []Message, json bool) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.history = append(f.history, append([]Message(nil), messages...))
+	var reply fakeReply
+	if len(f.script) > 0 {
+		reply, f.script = f.script[0], f.script[1:]
+	} else if json {
+		reply.content = "{}"
+	} else {
+		for _, m := range messages {
+			if m.Role == RoleUser {
+				reply.content = m.Content
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	if reply.err != nil {
+		return nil, reply.err
+	}
+	return &Response{Content: reply.content, Tokens: estimateUsage(messages, reply.content)}, nil
+}
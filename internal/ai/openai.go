@@ -0,0 +1,130 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// openAIProvider calls the OpenAI chat completions API, or a server that
+// implements the same API such as Ollama.
+type openAIProvider struct {
+	client      *openai.Client
+	model       string
+	temperature float32
+	timeout     time.Duration
+}
+
+func newOpenAI(cfg Config) *openAIProvider {
+	clientCfg := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientCfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	}
+	return &openAIProvider{
+		client:      openai.NewClientWithConfig(clientCfg),
+		model:       cfg.Model,
+		temperature: cfg.Temperature,
+		timeout:     cfg.Timeout,
+	}
+}
+
+func (p *openAIProvider) Chat(ctx context.Context, messages []Message) (*Response, error) {
+	return p.complete(ctx, p.request(messages))
+}
+
+func (p *openAIProvider) ChatJSON(ctx context.Context, messages []Message) (*Response, error) {
+	req := p.request(messages)
+	req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONObject,
+	}
+	return p.complete(ctx, req)
+}
+
+func (p *openAIProvider) Stream(ctx context.Context, messages []Message, onDelta func(string) error) (*Response, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	req := p.request(messages)
+	req.Stream = true
+	req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	stream, err := p.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("start chat stream: %w", err)
+	}
+	defer stream.Close()
+
+	var content strings.Builder
+	tokens := 0
+	// partial is the reply so far, for when the stream stops early
+	partial := func() *Response {
+		if content.Len() == 0 {
+			return nil
+		}
+		return &Response{Content: content.String(), Tokens: estimateUsage(messages, content.String())}
+	}
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return partial(), fmt.Errorf("read chat stream: %w", err)
+		}
+		// The last chunk carries the usage and no choices
+		if chunk.Usage != nil {
+			tokens = chunk.Usage.TotalTokens
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return partial(), err
+			}
+		}
+	}
+
+	// Not every compatible server reports usage for streams
+	if tokens == 0 {
+		tokens = estimateUsage(messages, content.String())
+	}
+	return &Response{Content: content.String(), Tokens: tokens}, nil
+}
+
+func (p *openAIProvider) complete(ctx context.Context, req openai.ChatCompletionRequest) (*Response, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	resp, err := p.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("create chat completion: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("create chat completion: no choices returned")
+	}
+	return &Response{Content: resp.Choices[0].Message.Content, Tokens: resp.Usage.TotalTokens}, nil
+}
+
+func (p *openAIProvider) request(messages []Message) openai.ChatCompletionRequest {
+	req := openai.ChatCompletionRequest{
+		Model:       p.model,
+		Temperature: p.temperature,
+	}
+	for _, m := range messages {
+		req.Messages = append(req.Messages, openai.ChatCompletionMessage{Role: m.Role, Content: m.Content})
+	}
+	return req
+}
+
+func (p *openAIProvider) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.timeout)
+}
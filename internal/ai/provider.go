@@ -0,0 +1,108 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Message roles
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is one turn of a chat with the model.
+type Message struct {
+	Role    string
+	Content string
+}
+
+// Response is the model's reply and the tokens the request used, which
+// count against the user's quota.
+type Response struct {
+	Content string
+	Tokens  int
+}
+
+// Provider is a chat model the AI features can use.
+type Provider interface {
+	// Chat returns the model's reply to messages.
+	Chat(ctx context.Context, messages []Message) (*Response, error)
+	// ChatJSON is Chat with the reply constrained to a JSON object.
+	ChatJSON(ctx context.Context, messages []Message) (*Response, error)
+	// Stream is Chat that also passes each piece of the reply to onDelta as
+	// it is generated. An error from onDelta stops the stream and is
+	// returned. When the stream fails part way, the response holds what was
+	// generated before it, with an estimate of the tokens used.
+	Stream(ctx context.Context, messages []Message, onDelta func(string) error) (*Response, error)
+}
+
+const (
+	ProviderOpenAI = "openai"
+	// ProviderOllama talks to Ollama or any other server with an
+	// OpenAI-compatible chat completions endpoint.
+	ProviderOllama = "ollama"
+	// ProviderFake answers without calling a model; see Fake.
+	ProviderFake = "fake"
+
+	defaultOpenAIModel   = "gpt-3.5-turbo"
+	defaultOllamaModel   = "llama3.2"
+	defaultOllamaBaseURL = "http://localhost:11434/v1"
+)
+
+// Config selects and configures a Provider.
+type Config struct {
+	Provider string // "openai", "ollama" or "fake"
+	APIKey   string
+	// BaseURL overrides the provider's API address, such as a remote Ollama
+	// server; empty uses the default.
+	BaseURL string
+	// Model defaults to gpt-3.5-turbo for OpenAI and llama3.2 for Ollama.
+	Model       string
+	Temperature float32
+	// Timeout bounds each request, including the whole of a stream.
+	Timeout time.Duration
+}
+
+// New returns the Provider for cfg.Provider.
+func New(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case ProviderOpenAI, "":
+		if cfg.Model == "" {
+			cfg.Model = defaultOpenAIModel
+		}
+		return newOpenAI(cfg), nil
+	case ProviderOllama:
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = defaultOllamaBaseURL
+		}
+		if cfg.Model == "" {
+			cfg.Model = defaultOllamaModel
+		}
+		// Ollama ignores the key, but the client always sends one
+		if cfg.APIKey == "" {
+			cfg.APIKey = "ollama"
+		}
+		return newOpenAI(cfg), nil
+	case ProviderFake:
+		// Slow enough to watch a reply stream in
+		f := NewFake()
+		f.Delay = 30 * time.Millisecond
+		return f, nil
+	default:
+		return nil, fmt.Errorf("unknown AI provider %q", cfg.Provider)
+	}
+}
+
+// estimateUsage approximates the tokens used by a request and its reply
+// for providers that don't report usage, at the usual rate of about four
+// characters a token.
+func estimateUsage(messages []Message, reply string) int {
+	chars := len(reply)
+	for _, m := range messages {
+		chars += len(m.Content)
+	}
+	return (chars + 3) / 4
+}
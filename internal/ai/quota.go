@@ -0,0 +1,135 @@
+// Package ai talks to language models for the note and meeting AI
+// features and keeps each user's use of them within a daily quota.
+package ai
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Limits caps how much a user can use the AI features per UTC day.
+type Limits struct {
+	Requests int
+	Tokens   int
+}
+
+// Quota resources named in a QuotaExceededError.
+const (
+	ResourceRequests = "requests"
+	ResourceTokens   = "tokens"
+)
+
+// QuotaExceededError is returned when a user has used up a daily limit.
+type QuotaExceededError struct {
+	Resource string // ResourceRequests or ResourceTokens
+	Used     int
+	Limit    int
+	ResetAt  time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("daily AI %s quota of %d used up", e.Resource, e.Limit)
+}
+
+// Quota tracks AI requests and tokens per user and day in the ai_usage
+// table. Subscribers get higher limits than free users.
+type Quota struct {
+	db         *sql.DB
+	free       Limits
+	subscriber Limits
+}
+
+func NewQuota(db *sql.DB, free, subscriber Limits) *Quota {
+	return &Quota{db: db, free: free, subscriber: subscriber}
+}
+
+// Reserve counts a request against the user's quota for today, or returns
+// a *QuotaExceededError if either daily limit has been reached. Tokens are
+// only known once the model has answered, so a request is allowed while
+// any tokens are left and may go over by the size of one response.
+//
+// It returns the day the request was counted against, formatted as
+// YYYY-MM-DD, for the Record or Release call that follows: a request
+// running past midnight is still charged to the day it started.
+func (q *Quota) Reserve(ctx context.Context, userID int, subscribed bool) (string, error) {
+	limits := q.limits(subscribed)
+	now := time.Now().UTC()
+	day := now.Format(time.DateOnly)
+
+	// Create today's row if needed, with SQL every backend accepts; a
+	// concurrent request creating it first is fine
+	_, err := q.db.ExecContext(ctx, `INSERT INTO ai_usage (user_id, day)
+		SELECT ?, ? FROM (SELECT 1 AS one) AS seed
+		WHERE NOT EXISTS (SELECT 1 FROM ai_usage WHERE user_id = ? AND day = ?)`,
+		userID, day, userID, day)
+	if err != nil && !q.exists(ctx, userID, day) {
+		return "", fmt.Errorf("create AI usage: %w", err)
+	}
+
+	res, err := q.db.ExecContext(ctx, `
+		UPDATE ai_usage SET requests = requests + 1
+		WHERE user_id = ? AND day = ? AND requests < ? AND tokens < ?`,
+		userID, day, limits.Requests, limits.Tokens)
+	if err != nil {
+		return "", fmt.Errorf("reserve AI request: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return "", fmt.Errorf("reserve AI request: %w", err)
+	} else if n > 0 {
+		return day, nil
+	}
+
+	var requests, tokens int
+	err = q.db.QueryRowContext(ctx, "SELECT requests, tokens FROM ai_usage WHERE user_id = ? AND day = ?", userID, day).
+		Scan(&requests, &tokens)
+	if err != nil {
+		return "", fmt.Errorf("load AI usage: %w", err)
+	}
+	exceeded := &QuotaExceededError{Resource: ResourceRequests, Used: requests, Limit: limits.Requests, ResetAt: nextDay(now)}
+	if tokens >= limits.Tokens {
+		exceeded.Resource, exceeded.Used, exceeded.Limit = ResourceTokens, tokens, limits.Tokens
+	}
+	return "", exceeded
+}
+
+// exists reports whether the user's row for day is there, as when a
+// concurrent request's insert beat ours to the unique key.
+func (q *Quota) exists(ctx context.Context, userID int, day string) bool {
+	var one int
+	return q.db.QueryRowContext(ctx, "SELECT 1 FROM ai_usage WHERE user_id = ? AND day = ?", userID, day).Scan(&one) == nil
+}
+
+// Record adds the tokens a request reserved on day used.
+func (q *Quota) Record(ctx context.Context, userID int, day string, tokens int) error {
+	_, err := q.db.ExecContext(ctx, "UPDATE ai_usage SET tokens = tokens + ? WHERE user_id = ? AND day = ?",
+		tokens, userID, day)
+	if err != nil {
+		return fmt.Errorf("record AI tokens: %w", err)
+	}
+	return nil
+}
+
+// Release gives back a request reserved on day that failed before the
+// model answered, so errors on our side don't use up the quota.
+func (q *Quota) Release(ctx context.Context, userID int, day string) error {
+	_, err := q.db.ExecContext(ctx, "UPDATE ai_usage SET requests = requests - 1 WHERE user_id = ? AND day = ? AND requests > 0",
+		userID, day)
+	if err != nil {
+		return fmt.Errorf("release AI request: %w", err)
+	}
+	return nil
+}
+
+func (q *Quota) limits(subscribed bool) Limits {
+	if subscribed {
+		return q.subscriber
+	}
+	return q.free
+}
+
+func nextDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
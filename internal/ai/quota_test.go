@@ -0,0 +1,128 @@
+package ai
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+)
+
+func usage(t *testing.T, conn *sql.DB, userID int, day string) (requests, tokens int) {
+	t.Helper()
+	err := conn.QueryRow("SELECT requests, tokens FROM ai_usage WHERE user_id = ? AND day = ?", userID, day).Scan(&requests, &tokens)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal(err)
+	}
+	return requests, tokens
+}
+
+func TestQuotaRequestLimit(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+	quota := NewQuota(conn, Limits{Requests: 2, Tokens: 1000}, Limits{Requests: 3, Tokens: 1000})
+
+	today := time.Now().UTC().Format(time.DateOnly)
+	for i := 0; i < 2; i++ {
+		day, err := quota.Reserve(ctx, userID, false)
+		if err != nil {
+			t.Fatalf("Reserve %d: %v", i, err)
+		}
+		if day != today {
+			t.Errorf("Reserve counted against %q, want %q", day, today)
+		}
+	}
+
+	_, err := quota.Reserve(ctx, userID, false)
+	var exceeded *QuotaExceededError
+	if !errors.As(err, &exceeded) {
+		t.Fatalf("third Reserve: got %v, want QuotaExceededError", err)
+	}
+	if exceeded.Resource != ResourceRequests || exceeded.Used != 2 || exceeded.Limit != 2 {
+		t.Errorf("got %+v", exceeded)
+	}
+	if !exceeded.ResetAt.After(time.Now()) || exceeded.ResetAt.Sub(time.Now()) > 24*time.Hour {
+		t.Errorf("ResetAt %v is not the next midnight", exceeded.ResetAt)
+	}
+
+	// Subscribers get their own, higher limit
+	if _, err := quota.Reserve(ctx, userID, true); err != nil {
+		t.Errorf("subscriber Reserve: %v", err)
+	}
+}
+
+func TestQuotaTokenLimit(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+	quota := NewQuota(conn, Limits{Requests: 10, Tokens: 100}, Limits{Requests: 10, Tokens: 100})
+
+	day, err := quota.Reserve(ctx, userID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := quota.Record(ctx, userID, day, 150); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	_, err = quota.Reserve(ctx, userID, false)
+	var exceeded *QuotaExceededError
+	if !errors.As(err, &exceeded) || exceeded.Resource != ResourceTokens || exceeded.Used != 150 {
+		t.Fatalf("got %v, want tokens exceeded at 150", err)
+	}
+}
+
+func TestQuotaChargesReservedDay(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+	quota := NewQuota(conn, Limits{Requests: 10, Tokens: 1000}, Limits{Requests: 10, Tokens: 1000})
+
+	today, err := quota.Reserve(ctx, userID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Requests reserved yesterday that finish today
+	const yesterday = "2000-01-01"
+	if _, err := conn.Exec("INSERT INTO ai_usage (user_id, day, requests) VALUES (?, ?, 2)", userID, yesterday); err != nil {
+		t.Fatal(err)
+	}
+	if err := quota.Record(ctx, userID, yesterday, 40); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if err := quota.Release(ctx, userID, yesterday); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+
+	if requests, tokens := usage(t, conn, userID, yesterday); requests != 1 || tokens != 40 {
+		t.Errorf("yesterday: %d requests, %d tokens; want 1, 40", requests, tokens)
+	}
+	if requests, tokens := usage(t, conn, userID, today); requests != 1 || tokens != 0 {
+		t.Errorf("today: %d requests, %d tokens; want 1, 0", requests, tokens)
+	}
+
+	// Releasing more than was reserved doesn't go negative
+	quota.Release(ctx, userID, yesterday)
+	quota.Release(ctx, userID, yesterday)
+	if requests, _ := usage(t, conn, userID, yesterday); requests != 0 {
+		t.Errorf("yesterday: %d requests after releasing all, want 0", requests)
+	}
+}
+
+func TestQuotaReserveError(t *testing.T) {
+	conn := dbtest.New(t)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+	quota := NewQuota(conn, Limits{Requests: 10, Tokens: 1000}, Limits{Requests: 10, Tokens: 1000})
+	if _, err := conn.Exec("DROP TABLE ai_usage"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := quota.Reserve(context.Background(), userID, false)
+	var exceeded *QuotaExceededError
+	if err == nil || errors.As(err, &exceeded) {
+		t.Fatalf("got %v, want a database error", err)
+	}
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+)
+
+// Scopes a personal access token can be granted.
+const (
+	ScopeMeetings          = "meetings"
+	ScopeSubscriptionRead  = "subscription:read"
+	ScopeSubscriptionWrite = "subscription:write"
+)
+
+// Scope describes what a token scope allows, for the token settings page.
+type Scope struct {
+	Name        string
+	Description string
+}
+
+// Scopes lists every scope in the order they are offered to users.
+var Scopes = []Scope{
+	{ScopeMeetings, "Start and end meetings, check remaining meeting time, and read meeting summaries and action items"},
+	{ScopeSubscriptionRead, "View subscription status"},
+	{ScopeSubscriptionWrite, "Start a checkout or cancel the subscription"},
+}
+
+const (
+	// apiTokenPrefix marks personal access tokens, so they are easy to
+	// recognise in code and to find with secret scanners.
+	apiTokenPrefix = "dpat_"
+	// Characters of the token kept in clear to tell tokens apart
+	apiTokenDisplayLen = 12
+	// last_used_at is only written when it is older than this.
+	apiTokenTouchInterval = time.Minute
+
+	maxAPITokenName = 100
+)
+
+var (
+	// ErrInvalidAPIToken means a bearer token is unknown, revoked or expired.
+	ErrInvalidAPIToken = errors.New("invalid API token")
+	// ErrAPITokenNotFound is returned when revoking a token the user does not have.
+	ErrAPITokenNotFound = errors.New("API token not found")
+	// ErrUnknownScope means a token was requested with a scope that does not exist.
+	ErrUnknownScope = errors.New("unknown scope")
+)
+
+// APITokens manages personal access tokens, which let scripts call the API
+// as a user with a limited set of scopes. Tokens are stored hashed and can
+// be revoked at any time.
+type APITokens struct {
+	db *sql.DB
+}
+
+func NewAPITokens(db *sql.DB) *APITokens {
+	return &APITokens{db: db}
+}
+
+// Create issues a token for the user and returns it. It can't be retrieved
+// again later. A ttl of 0 means the token does not expire.
+func (t *APITokens) Create(ctx context.Context, userID int, name string, scopes []string, ttl time.Duration) (string, error) {
+	if len(scopes) == 0 {
+		return "", ErrUnknownScope
+	}
+	for _, s := range scopes {
+		if !knownScope(s) {
+			return "", fmt.Errorf("%w: %q", ErrUnknownScope, s)
+		}
+	}
+
+	random, _, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	token := apiTokenPrefix + random
+
+	now := time.Now().UTC()
+	var expires sql.NullTime
+	if ttl > 0 {
+		expires = sql.NullTime{Time: now.Add(ttl), Valid: true}
+	}
+	_, err = t.db.ExecContext(ctx, `INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, truncate(strings.TrimSpace(name), maxAPITokenName), hashToken(token), token[:apiTokenDisplayLen],
+		strings.Join(scopes, " "), now, expires)
+	if err != nil {
+		return "", fmt.Errorf("create API token: %w", err)
+	}
+	return token, nil
+}
+
+// Authenticate returns the user and scopes of a bearer token, and records
+// that it was used.
+func (t *APITokens) Authenticate(ctx context.Context, token string) (int, []string, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return 0, nil, ErrInvalidAPIToken
+	}
+
+	var (
+		id, userID int
+		scopes     string
+		lastUsed   sql.NullTime
+		expires    sql.NullTime
+	)
+	err := t.db.QueryRowContext(ctx, `
+		SELECT id, user_id, scopes, last_used_at, expires_at
+		FROM api_tokens
+		WHERE token_hash = ? AND revoked_at IS NULL`, hashToken(token),
+	).Scan(&id, &userID, &scopes, &lastUsed, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("load API token: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if expires.Valid && now.After(expires.Time) {
+		return 0, nil, ErrInvalidAPIToken
+	}
+	if !lastUsed.Valid || now.Sub(lastUsed.Time) > apiTokenTouchInterval {
+		if _, err := t.db.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, id); err != nil {
+			return 0, nil, fmt.Errorf("touch API token: %w", err)
+		}
+	}
+	return userID, strings.Fields(scopes), nil
+}
+
+// List returns the user's tokens that have not been revoked, newest first.
+// Expired tokens are included so the user can see why a script stopped
+// working.
+func (t *APITokens) List(ctx context.Context, userID int) ([]models.APIToken, error) {
+	rows, err := t.db.QueryContext(ctx, `
+		SELECT id, user_id, name, prefix, scopes, created_at, last_used_at, expires_at
+		FROM api_tokens
+		WHERE user_id = ? AND revoked_at IS NULL
+		ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		var (
+			tok               models.APIToken
+			scopes            string
+			lastUsed, expires sql.NullTime
+		)
+		if err := rows.Scan(&tok.ID, &tok.UserID, &tok.Name, &tok.Prefix, &scopes, &tok.CreatedAt, &lastUsed, &expires); err != nil {
+			return nil, fmt.Errorf("scan API token: %w", err)
+		}
+		tok.Scopes = strings.Fields(scopes)
+		if lastUsed.Valid {
+			tok.LastUsedAt = &lastUsed.Time
+		}
+		if expires.Valid {
+			tok.ExpiresAt = &expires.Time
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, rows.Err()
+}
+
+// Revoke disables one of the user's tokens immediately.
+func (t *APITokens) Revoke(ctx context.Context, userID, tokenID int) error {
+	res, err := t.db.ExecContext(ctx, "UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("revoke API token: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+func knownScope(name string) bool {
+	for _, s := range Scopes {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -9,36 +9,89 @@ import (
 
 type JWTService struct {
 	secretKey string
+	ttl       time.Duration
 }
 
-func NewJWTService(secret string) *JWTService {
-	return &JWTService{secretKey: secret}
+// NewJWTService returns a service issuing access tokens valid for ttl.
+func NewJWTService(secret string, ttl time.Duration) *JWTService {
+	return &JWTService{secretKey: secret, ttl: ttl}
 }
 
-func (j *JWTService) GenerateToken(userID int) (string, error) {
+// GenerateToken issues an access token for a user's session. It returns the
+// token and when it expires.
+func (j *JWTService) GenerateToken(userID, sessionID int) (string, time.Time, error) {
+	expires := time.Now().Add(j.ttl)
 	claims := jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Second * 24).Unix(),
+		"sid":     sessionID,
+		"exp":     expires.Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(j.secretKey))
+	signed, err := token.SignedString([]byte(j.secretKey))
+	return signed, expires, err
 }
 
-func (j *JWTService) ValidateToken(tokenStr string) (int, error) {
+// ValidateToken returns the user and session an access token was issued for.
+func (j *JWTService) ValidateToken(tokenStr string) (userID, sessionID int, err error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		return []byte(j.secretKey), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil || !token.Valid {
-		return 0, errors.New("invalid token")
+		return 0, 0, errors.New("invalid token")
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return 0, errors.New("invalid claims")
+		return 0, 0, errors.New("invalid claims")
+	}
+	if _, ok := claims["purpose"]; ok {
+		return 0, 0, errors.New("not an access token")
+	}
+	userIDFloat, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, 0, errors.New("invalid user_id")
+	}
+	sidFloat, ok := claims["sid"].(float64)
+	if !ok {
+		return 0, 0, errors.New("invalid sid")
+	}
+	return int(userIDFloat), int(sidFloat), nil
+}
+
+// GeneratePurposeToken issues a short-lived token that identifies a user
+// for one step of a flow, such as the second step of a login, optionally
+// carrying state for that step. It is not accepted as an access token.
+func (j *JWTService) GeneratePurposeToken(userID int, purpose, data string, ttl time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": userID,
+		"purpose": purpose,
+		"exp":     time.Now().Add(ttl).Unix(),
+	}
+	if data != "" {
+		claims["data"] = data
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(j.secretKey))
+}
+
+// ValidatePurposeToken returns the user and state a token from
+// GeneratePurposeToken was issued with, if it is valid and was issued for
+// purpose.
+func (j *JWTService) ValidatePurposeToken(tokenStr, purpose string) (userID int, data string, err error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		return []byte(j.secretKey), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return 0, "", errors.New("invalid token")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != purpose {
+		return 0, "", errors.New("invalid claims")
 	}
 	userIDFloat, ok := claims["user_id"].(float64)
 	if !ok {
-		return 0, errors.New("invalid user_id")
+		return 0, "", errors.New("invalid user_id")
 	}
-	return int(userIDFloat), nil
+	data, _ = claims["data"].(string)
+	return int(userIDFloat), data, nil
 }
@@ -2,35 +2,133 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
 )
 
 type key int
 
-const UserIDKey key = 0
+const (
+	UserIDKey key = iota
+	SessionIDKey
+	// ScopesKey holds the scopes of the API token a request was made with.
+	// It is unset for requests made with a session, which may do anything.
+	ScopesKey
+)
 
-func JWTMiddleware(jwtService *JWTService) func(http.Handler) http.Handler {
+// JWTMiddleware requires a signed-in session, renewing an expired access
+// token from the refresh token without interrupting the request.
+func JWTMiddleware(sessions *Sessions) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("token")
+			userID, sessionID, err := sessions.Authenticate(w, r)
 			if err != nil {
+				if !errors.Is(err, ErrNoSession) {
+					log.Println("Session error:", err)
+				}
+				ClearCookies(w)
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
 			}
 
-			tokenStr := cookie.Value
-			userID, err := jwtService.ValidateToken(tokenStr)
+			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			ctx = context.WithValue(ctx, SessionIDKey, sessionID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// APIMiddleware authenticates API requests by a personal access token in
+// the Authorization header or, for calls from the app's own pages, by the
+// session cookies. Unlike JWTMiddleware it answers failures with a JSON 401
+// instead of redirecting to the login page.
+func APIMiddleware(sessions *Sessions, tokens *APITokens) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if header := r.Header.Get("Authorization"); header != "" {
+				scheme, token, _ := strings.Cut(header, " ")
+				if !strings.EqualFold(scheme, "Bearer") || token == "" {
+					writeAuthError(w, http.StatusUnauthorized, `Bearer error="invalid_request"`, "invalid_request", "Use an Authorization: Bearer header")
+					return
+				}
+
+				userID, scopes, err := tokens.Authenticate(r.Context(), strings.TrimSpace(token))
+				if err != nil {
+					if !errors.Is(err, ErrInvalidAPIToken) {
+						log.Println("API token error:", err)
+					}
+					writeAuthError(w, http.StatusUnauthorized, `Bearer error="invalid_token"`, "invalid_token", "The API token is invalid, expired or revoked")
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), UserIDKey, userID)
+				ctx = context.WithValue(ctx, ScopesKey, scopes)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			userID, sessionID, err := sessions.Authenticate(w, r)
 			if err != nil {
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				if !errors.Is(err, ErrNoSession) {
+					log.Println("Session error:", err)
+				}
+				writeAuthError(w, http.StatusUnauthorized, "Bearer", "unauthorized", "Authentication required")
 				return
 			}
 
 			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			ctx = context.WithValue(ctx, SessionIDKey, sessionID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// HasAPIToken reports whether the request's Authorization header carries a
+// personal access token. It only looks at the form of the token;
+// APIMiddleware checks that it is valid.
+func HasAPIToken(r *http.Request) bool {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	return strings.EqualFold(scheme, "Bearer") && strings.HasPrefix(strings.TrimSpace(token), apiTokenPrefix)
+}
+
+// RequireScope only lets requests made with an API token through to next if
+// the token has the scope. Requests made with a session are not limited.
+func RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if scopes, ok := r.Context().Value(ScopesKey).([]string); ok {
+			granted := false
+			for _, s := range scopes {
+				if s == scope {
+					granted = true
+					break
+				}
+			}
+			if !granted {
+				writeAuthError(w, http.StatusForbidden, fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope),
+					"insufficient_scope", fmt.Sprintf("This token does not have the %q scope", scope))
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// writeAuthError writes a JSON error body along with the WWW-Authenticate
+// challenge API clients expect.
+func writeAuthError(w http.ResponseWriter, status int, challenge, code, message string) {
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   code,
+		"message": message,
+	})
+}
+
 func GetUserIDFromContext(ctx context.Context) int {
 	userID, ok := ctx.Value(UserIDKey).(int)
 	if !ok {
@@ -38,3 +136,12 @@ func GetUserIDFromContext(ctx context.Context) int {
 	}
 	return userID
 }
+
+// GetSessionIDFromContext returns the ID of the request's session, or 0.
+func GetSessionIDFromContext(ctx context.Context) int {
+	sessionID, ok := ctx.Value(SessionIDKey).(int)
+	if !ok {
+		return 0
+	}
+	return sessionID
+}
@@ -0,0 +1,343 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	// Cookies carrying the signed state of a WebAuthn ceremony between its
+	// begin and finish requests.
+	passkeyRegisterCookie = "passkey_register"
+	passkeyLoginCookie    = "passkey_login"
+	ceremonyTTL           = 5 * time.Minute
+
+	maxPasskeyName = 100
+)
+
+var (
+	// ErrPasskeyNotFound is returned when deleting a passkey the user does not have.
+	ErrPasskeyNotFound = errors.New("passkey not found")
+	// ErrPasskeyCloned means a passkey's signature counter went backwards,
+	// which suggests the key was copied.
+	ErrPasskeyCloned = errors.New("passkey signature counter did not increase")
+	// ErrCeremony means a WebAuthn response did not match the ceremony it
+	// claims to finish or failed verification.
+	ErrCeremony = errors.New("passkey verification failed")
+)
+
+// Passkeys registers WebAuthn credentials and signs users in with them.
+type Passkeys struct {
+	db       *sql.DB
+	jwt      *JWTService
+	webAuthn *webauthn.WebAuthn
+}
+
+// NewPasskeys returns a relying party for the app served at baseURL. The
+// credentials it registers are bound to that URL's host.
+func NewPasskeys(db *sql.DB, jwtService *JWTService, baseURL string) (*Passkeys, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse base URL: %w", err)
+	}
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          u.Hostname(),
+		RPDisplayName: TOTPIssuer,
+		RPOrigins:     []string{u.Scheme + "://" + u.Host},
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("configure webauthn: %w", err)
+	}
+	return &Passkeys{db: db, jwt: jwtService, webAuthn: wa}, nil
+}
+
+// passkeyUser adapts an account to webauthn.User. The user handle stored on
+// the authenticator is the decimal user ID.
+type passkeyUser struct {
+	id          int
+	email       string
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return []byte(strconv.Itoa(u.id)) }
+func (u *passkeyUser) WebAuthnName() string                       { return u.email }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.email }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// List returns the user's passkeys, most recently added first.
+func (p *Passkeys) List(ctx context.Context, userID int) ([]models.Passkey, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT id, user_id, name, flags, created_at, last_used_at
+		FROM webauthn_credentials
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query passkeys: %w", err)
+	}
+	defer rows.Close()
+
+	var passkeys []models.Passkey
+	for rows.Next() {
+		var (
+			pk       models.Passkey
+			flags    int
+			lastUsed sql.NullTime
+		)
+		if err := rows.Scan(&pk.ID, &pk.UserID, &pk.Name, &flags, &pk.CreatedAt, &lastUsed); err != nil {
+			return nil, fmt.Errorf("scan passkey: %w", err)
+		}
+		pk.BackedUp = protocol.AuthenticatorFlags(flags).HasBackupState()
+		if lastUsed.Valid {
+			pk.LastUsedAt = &lastUsed.Time
+		}
+		passkeys = append(passkeys, pk)
+	}
+	return passkeys, rows.Err()
+}
+
+// Delete removes one of the user's passkeys.
+func (p *Passkeys) Delete(ctx context.Context, userID, id int) error {
+	res, err := p.db.ExecContext(ctx, "DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return fmt.Errorf("delete passkey: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// BeginRegistration returns the options for navigator.credentials.create
+// and remembers the ceremony in a cookie.
+func (p *Passkeys) BeginRegistration(w http.ResponseWriter, r *http.Request, userID int) (*protocol.CredentialCreation, error) {
+	user, err := p.loadUser(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude := make([]protocol.CredentialDescriptor, len(user.credentials))
+	for i, c := range user.credentials {
+		exclude[i] = c.Descriptor()
+	}
+	options, session, err := p.webAuthn.BeginRegistration(user, webauthn.WithExclusions(exclude))
+	if err != nil {
+		return nil, fmt.Errorf("begin registration: %w", err)
+	}
+	if err := p.saveCeremony(w, passkeyRegisterCookie, userID, session); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// FinishRegistration verifies the authenticator's response and stores the
+// new credential under name.
+func (p *Passkeys) FinishRegistration(w http.ResponseWriter, r *http.Request, userID int, name string) error {
+	ceremonyUser, session, err := p.loadCeremony(w, r, passkeyRegisterCookie)
+	if err != nil || ceremonyUser != userID {
+		return ErrCeremony
+	}
+	user, err := p.loadUser(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	credential, err := p.webAuthn.FinishRegistration(user, *session, r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCeremony, err)
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > maxPasskeyName {
+		name = name[:maxPasskeyName]
+	}
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+
+	_, err = p.db.ExecContext(r.Context(), `INSERT INTO webauthn_credentials
+		(user_id, credential_id, public_key, attestation_type, aaguid, transports, flags, sign_count, name, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, base64.RawURLEncoding.EncodeToString(credential.ID), credential.PublicKey, credential.AttestationType,
+		credential.Authenticator.AAGUID, strings.Join(transports, ","), int(credential.Flags.ProtocolValue()),
+		credential.Authenticator.SignCount, name, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("save passkey: %w", err)
+	}
+	return nil
+}
+
+// BeginLogin returns the options for navigator.credentials.get. Any of the
+// site's passkeys may answer, so the user doesn't enter an email first.
+func (p *Passkeys) BeginLogin(w http.ResponseWriter) (*protocol.CredentialAssertion, error) {
+	options, session, err := p.webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		return nil, fmt.Errorf("begin login: %w", err)
+	}
+	if err := p.saveCeremony(w, passkeyLoginCookie, 0, session); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// FinishLogin verifies a passkey assertion and returns the user it belongs
+// to. The credential's signature counter must have increased since its last
+// use, unless the authenticator doesn't keep one.
+func (p *Passkeys) FinishLogin(w http.ResponseWriter, r *http.Request) (int, error) {
+	_, session, err := p.loadCeremony(w, r, passkeyLoginCookie)
+	if err != nil {
+		return 0, ErrCeremony
+	}
+
+	var user *passkeyUser
+	credential, err := p.webAuthn.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := strconv.Atoi(string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		user, err = p.loadUser(r.Context(), userID)
+		return user, err
+	}, *session, r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrCeremony, err)
+	}
+	if credential.Authenticator.CloneWarning {
+		return 0, ErrPasskeyCloned
+	}
+
+	// Only accept the new counter if no concurrent login already moved it
+	// on, so a cloned assertion can't succeed twice. Authenticators without
+	// a counter always report 0; for those only the recorded challenge stops
+	// an assertion being replayed.
+	res, err := p.db.ExecContext(r.Context(), `
+		UPDATE webauthn_credentials SET sign_count = ?, flags = ?, last_used_at = ?
+		WHERE user_id = ? AND credential_id = ? AND (sign_count < ? OR sign_count = 0)`,
+		credential.Authenticator.SignCount, int(credential.Flags.ProtocolValue()), time.Now().UTC(),
+		user.id, base64.RawURLEncoding.EncodeToString(credential.ID), credential.Authenticator.SignCount)
+	if err != nil {
+		return 0, fmt.Errorf("update passkey: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, fmt.Errorf("update passkey: %w", err)
+	} else if n == 0 {
+		return 0, ErrPasskeyCloned
+	}
+	return user.id, nil
+}
+
+func (p *Passkeys) loadUser(ctx context.Context, userID int) (*passkeyUser, error) {
+	user := &passkeyUser{id: userID}
+	if err := p.db.QueryRowContext(ctx, "SELECT email FROM users WHERE id = ?", userID).Scan(&user.email); err != nil {
+		return nil, fmt.Errorf("load user: %w", err)
+	}
+
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT credential_id, public_key, attestation_type, aaguid, transports, flags, sign_count
+		FROM webauthn_credentials WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query passkeys: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			c          webauthn.Credential
+			id         string
+			transports string
+			flags      int
+		)
+		if err := rows.Scan(&id, &c.PublicKey, &c.AttestationType, &c.Authenticator.AAGUID, &transports, &flags, &c.Authenticator.SignCount); err != nil {
+			return nil, fmt.Errorf("scan passkey: %w", err)
+		}
+		if c.ID, err = base64.RawURLEncoding.DecodeString(id); err != nil {
+			return nil, fmt.Errorf("decode credential id: %w", err)
+		}
+		for _, t := range strings.Split(transports, ",") {
+			if t != "" {
+				c.Transport = append(c.Transport, protocol.AuthenticatorTransport(t))
+			}
+		}
+		c.Flags = webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(flags))
+		user.credentials = append(user.credentials, c)
+	}
+	return user, rows.Err()
+}
+
+func (p *Passkeys) saveCeremony(w http.ResponseWriter, cookie string, userID int, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("encode ceremony: %w", err)
+	}
+	token, err := p.jwt.GeneratePurposeToken(userID, cookie, string(data), ceremonyTTL)
+	if err != nil {
+		return fmt.Errorf("sign ceremony: %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookie,
+		Value:    token,
+		HttpOnly: true,
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(ceremonyTTL / time.Second),
+	})
+	return nil
+}
+
+// loadCeremony reads and clears a ceremony cookie. Clearing it is not enough
+// to stop a copy of the cookie being replayed while it is still valid, and
+// an authenticator that keeps no signature counter would then be accepted
+// twice, so the challenge is also recorded as answered.
+func (p *Passkeys) loadCeremony(w http.ResponseWriter, r *http.Request, cookie string) (int, *webauthn.SessionData, error) {
+	c, err := r.Cookie(cookie)
+	if err != nil {
+		return 0, nil, err
+	}
+	http.SetCookie(w, &http.Cookie{Name: cookie, Value: "", HttpOnly: true, Path: "/", MaxAge: -1})
+
+	userID, data, err := p.jwt.ValidatePurposeToken(c.Value, cookie)
+	if err != nil {
+		return 0, nil, err
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return 0, nil, err
+	}
+
+	// The cookie is valid for at most ceremonyTTL from now, so the record
+	// only has to outlive that. The primary key lets only one request in.
+	_, err = p.db.ExecContext(r.Context(), "INSERT INTO webauthn_challenges (challenge, expires_at) VALUES (?, ?)",
+		session.Challenge, time.Now().UTC().Add(ceremonyTTL))
+	if err != nil {
+		return 0, nil, fmt.Errorf("record challenge: %w", err)
+	}
+	return userID, &session, nil
+}
+
+// DeleteStale removes answered challenges whose ceremony cookies have expired.
+func (p *Passkeys) DeleteStale(ctx context.Context) (int64, error) {
+	res, err := p.db.ExecContext(ctx, "DELETE FROM webauthn_challenges WHERE expires_at < ?", time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("delete stale passkey challenges: %w", err)
+	}
+	return res.RowsAffected()
+}
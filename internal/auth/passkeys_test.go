@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+func TestPasskeyCeremonyAnsweredOnce(t *testing.T) {
+	conn := dbtest.New(t)
+	passkeys, err := NewPasskeys(conn, NewJWTService("test-secret", time.Minute), "http://localhost:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := passkeys.saveCeremony(rec, passkeyLoginCookie, 0, &webauthn.SessionData{Challenge: "abc123"}); err != nil {
+		t.Fatal(err)
+	}
+	saved := rec.Result().Cookies()[0]
+
+	load := func() error {
+		req := httptest.NewRequest(http.MethodPost, "/login/passkey/finish", nil)
+		req.AddCookie(saved)
+		_, _, err := passkeys.loadCeremony(httptest.NewRecorder(), req, passkeyLoginCookie)
+		return err
+	}
+	if err := load(); err != nil {
+		t.Fatalf("first answer: %v", err)
+	}
+	// The browser drops the cookie, but a copy of it is no good either
+	if err := load(); err == nil {
+		t.Fatal("replayed ceremony cookie was accepted")
+	}
+
+	// Forgotten once the cookie could no longer be used anyway
+	if n, err := passkeys.DeleteStale(context.Background()); err != nil || n != 0 {
+		t.Errorf("DeleteStale = %d, %v; want 0 before expiry", n, err)
+	}
+	if _, err := conn.Exec("UPDATE webauthn_challenges SET expires_at = ?", time.Now().UTC().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := passkeys.DeleteStale(context.Background()); err != nil || n != 1 {
+		t.Errorf("DeleteStale = %d, %v; want 1", n, err)
+	}
+}
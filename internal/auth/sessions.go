@@ -0,0 +1,352 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+)
+
+const (
+	AccessCookie  = "token"
+	RefreshCookie = "refresh_token"
+	// CSRFCookie holds the secret CSRF tokens are derived from. It is
+	// dropped whenever a session starts, so a token learned before login
+	// is useless after it.
+	CSRFCookie = "csrf_secret"
+
+	// A refresh token replaced less than this long ago is still accepted,
+	// without rotating again, so that parallel requests made while the
+	// access token expired do not look like token theft.
+	rotationGrace = 30 * time.Second
+	// last_seen_at is only written when it is older than this.
+	touchInterval = 5 * time.Minute
+)
+
+var (
+	// ErrNoSession means the request carries no usable session.
+	ErrNoSession = errors.New("no valid session")
+	// ErrSessionNotFound is returned when revoking a session the user does not have.
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// Sessions manages server-side login sessions. A session is represented in
+// the browser by a short-lived access JWT and a long-lived refresh token that
+// is replaced every time it is used. Revoking the session row ends both.
+type Sessions struct {
+	db         *sql.DB
+	jwt        *JWTService
+	ttl        time.Duration
+	trustProxy bool
+}
+
+// NewSessions returns a session manager whose sessions expire ttl after
+// they were last refreshed.
+func NewSessions(db *sql.DB, jwtService *JWTService, ttl time.Duration, trustProxy bool) *Sessions {
+	return &Sessions{db: db, jwt: jwtService, ttl: ttl, trustProxy: trustProxy}
+}
+
+// Start creates a session for a user who has just signed in and sets its
+// cookies on the response.
+func (s *Sessions) Start(w http.ResponseWriter, r *http.Request, userID int) error {
+	refresh, hash, err := newRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	expires := now.Add(s.ttl)
+	res, err := s.db.ExecContext(r.Context(), `INSERT INTO sessions (user_id, refresh_hash, user_agent, ip, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, hash, truncate(r.UserAgent(), 512), s.ClientIP(r), now, now, expires)
+	if err != nil {
+		return fmt.Errorf("create session: %w", err)
+	}
+	sessionID, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("read session id: %w", err)
+	}
+
+	// The CSRF middleware issues a new secret on the next request
+	http.SetCookie(w, &http.Cookie{Name: CSRFCookie, Value: "", HttpOnly: true, Path: "/", MaxAge: -1})
+	return s.setCookies(w, userID, int(sessionID), refresh, expires)
+}
+
+// Authenticate returns the user and session behind a request. If the access
+// token has expired it is renewed from the refresh token and the new cookies
+// are set on w.
+func (s *Sessions) Authenticate(w http.ResponseWriter, r *http.Request) (userID, sessionID int, err error) {
+	if c, err := r.Cookie(AccessCookie); err == nil {
+		if userID, sessionID, err := s.jwt.ValidateToken(c.Value); err == nil {
+			// A valid access token settles it either way: a revoked session
+			// must not be revived by its refresh token.
+			if err := s.check(r.Context(), userID, sessionID); err != nil {
+				return 0, 0, err
+			}
+			return userID, sessionID, nil
+		}
+	}
+
+	c, err := r.Cookie(RefreshCookie)
+	if err != nil || c.Value == "" {
+		return 0, 0, ErrNoSession
+	}
+	return s.refresh(w, r, c.Value)
+}
+
+// check confirms the session behind a valid access token has not been
+// revoked or expired, and records activity on it.
+func (s *Sessions) check(ctx context.Context, userID, sessionID int) error {
+	var owner int
+	var lastSeen, expires time.Time
+	var revoked sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT user_id, last_seen_at, expires_at, revoked_at FROM sessions WHERE id = ?", sessionID).
+		Scan(&owner, &lastSeen, &expires, &revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoSession
+	}
+	if err != nil {
+		return fmt.Errorf("load session: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if owner != userID || revoked.Valid || now.After(expires) {
+		return ErrNoSession
+	}
+	if now.Sub(lastSeen) > touchInterval {
+		if _, err := s.db.ExecContext(ctx, "UPDATE sessions SET last_seen_at = ? WHERE id = ?", now, sessionID); err != nil {
+			log.Println("Session touch error:", err)
+		}
+	}
+	return nil
+}
+
+// refresh exchanges a refresh token for a new access token and, unless the
+// token was only just rotated by a parallel request, a new refresh token.
+func (s *Sessions) refresh(w http.ResponseWriter, r *http.Request, token string) (int, int, error) {
+	ctx := r.Context()
+	hash := hashToken(token)
+
+	var sessionID, userID int
+	var current string
+	var previous sql.NullString
+	var rotated, revoked sql.NullTime
+	var expires time.Time
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, refresh_hash, previous_hash, rotated_at, expires_at, revoked_at
+		FROM sessions
+		WHERE refresh_hash = ? OR previous_hash = ?`, hash, hash,
+	).Scan(&sessionID, &userID, &current, &previous, &rotated, &expires, &revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, ErrNoSession
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("load session: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if revoked.Valid || now.After(expires) {
+		return 0, 0, ErrNoSession
+	}
+
+	if current != hash {
+		if rotated.Valid && now.Sub(rotated.Time) < rotationGrace {
+			return userID, sessionID, s.setAccessCookie(w, userID, sessionID)
+		}
+		// An old refresh token came back after its replacement was issued:
+		// someone else holds a copy, so end the session for both parties.
+		log.Printf("Refresh token reuse on session %d for user %d; revoking session", sessionID, userID)
+		if _, err := s.db.ExecContext(ctx, "UPDATE sessions SET revoked_at = ? WHERE id = ?", now, sessionID); err != nil {
+			return 0, 0, fmt.Errorf("revoke session: %w", err)
+		}
+		return 0, 0, ErrNoSession
+	}
+
+	next, nextHash, err := newRefreshToken()
+	if err != nil {
+		return 0, 0, err
+	}
+	expires = now.Add(s.ttl)
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE sessions
+		SET previous_hash = refresh_hash, refresh_hash = ?, rotated_at = ?, last_seen_at = ?,
+		    expires_at = ?, ip = ?, user_agent = ?
+		WHERE id = ? AND refresh_hash = ?`,
+		nextHash, now, now, expires, s.ClientIP(r), truncate(r.UserAgent(), 512), sessionID, hash)
+	if err != nil {
+		return 0, 0, fmt.Errorf("rotate session: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// A parallel request rotated it first; its cookies win.
+		return userID, sessionID, s.setAccessCookie(w, userID, sessionID)
+	}
+
+	return userID, sessionID, s.setCookies(w, userID, sessionID, next, expires)
+}
+
+// End revokes the request's session, if any, and clears its cookies.
+func (s *Sessions) End(w http.ResponseWriter, r *http.Request) error {
+	defer ClearCookies(w)
+
+	if c, err := r.Cookie(RefreshCookie); err == nil && c.Value != "" {
+		hash := hashToken(c.Value)
+		_, err := s.db.ExecContext(r.Context(), "UPDATE sessions SET revoked_at = ? WHERE (refresh_hash = ? OR previous_hash = ?) AND revoked_at IS NULL",
+			time.Now().UTC(), hash, hash)
+		if err != nil {
+			return fmt.Errorf("revoke session: %w", err)
+		}
+	}
+	return nil
+}
+
+// List returns the user's active sessions, most recently used first.
+func (s *Sessions) List(ctx context.Context, userID, currentID int) ([]models.Session, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_seen_at DESC`, userID, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var sess models.Session
+		if err := rows.Scan(&sess.ID, &sess.UserID, &sess.UserAgent, &sess.IP, &sess.CreatedAt, &sess.LastSeenAt, &sess.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan session: %w", err)
+		}
+		sess.Current = sess.ID == currentID
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke ends one of the user's sessions.
+func (s *Sessions) Revoke(ctx context.Context, userID, sessionID int) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAll ends every session of the user except keepID, which may be 0 to
+// end them all. It returns how many sessions were ended.
+func (s *Sessions) RevokeAll(ctx context.Context, userID, keepID int) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL",
+		time.Now().UTC(), userID, keepID)
+	if err != nil {
+		return 0, fmt.Errorf("revoke sessions: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// DeleteStale removes sessions that expired or were revoked before cutoff.
+func (s *Sessions) DeleteStale(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at < ? OR revoked_at < ?", cutoff.UTC(), cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("delete stale sessions: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// ClientIP returns the address of the client making the request. The
+// X-Forwarded-For header is only believed when the server is configured to
+// sit behind a proxy.
+func (s *Sessions) ClientIP(r *http.Request) string {
+	if s.trustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return truncate(strings.TrimSpace(first), 64)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return truncate(r.RemoteAddr, 64)
+	}
+	return host
+}
+
+func (s *Sessions) setCookies(w http.ResponseWriter, userID, sessionID int, refresh string, expires time.Time) error {
+	if err := s.setAccessCookie(w, userID, sessionID); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshCookie,
+		Value:    refresh,
+		HttpOnly: true,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		Expires:  expires,
+	})
+	return nil
+}
+
+func (s *Sessions) setAccessCookie(w http.ResponseWriter, userID, sessionID int) error {
+	token, expires, err := s.jwt.GenerateToken(userID, sessionID)
+	if err != nil {
+		return fmt.Errorf("generate token: %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     AccessCookie,
+		Value:    token,
+		HttpOnly: true,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		Expires:  expires,
+	})
+	return nil
+}
+
+// ClearCookies removes the session cookies from the browser.
+func ClearCookies(w http.ResponseWriter) {
+	for _, name := range []string{AccessCookie, RefreshCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			HttpOnly: true,
+			Path:     "/",
+			MaxAge:   -1,
+		})
+	}
+}
+
+// newRefreshToken returns a random URL-safe token and its digest. It is also
+// used for one-time tokens.
+func newRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("generate refresh token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+)
+
+type sessionTest struct {
+	t        *testing.T
+	sessions *Sessions
+	userID   int
+}
+
+func newSessionTest(t *testing.T) *sessionTest {
+	conn := dbtest.New(t)
+	return &sessionTest{
+		t:        t,
+		sessions: NewSessions(conn, NewJWTService("test-secret", time.Minute), time.Hour, false),
+		userID:   dbtest.CreateUser(t, conn, "a@example.com", true),
+	}
+}
+
+// start signs the user in and returns the access and refresh tokens.
+func (st *sessionTest) start() (access, refresh string) {
+	st.t.Helper()
+	rec := httptest.NewRecorder()
+	if err := st.sessions.Start(rec, httptest.NewRequest(http.MethodGet, "/login", nil), st.userID); err != nil {
+		st.t.Fatalf("Start: %v", err)
+	}
+	return cookie(rec, AccessCookie), cookie(rec, RefreshCookie)
+}
+
+// authenticate makes a request with the given cookies, either of which may
+// be empty, and returns the session and any cookies set in response.
+func (st *sessionTest) authenticate(access, refresh string) (sessionID int, rec *httptest.ResponseRecorder, err error) {
+	st.t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/notes", nil)
+	if access != "" {
+		req.AddCookie(&http.Cookie{Name: AccessCookie, Value: access})
+	}
+	if refresh != "" {
+		req.AddCookie(&http.Cookie{Name: RefreshCookie, Value: refresh})
+	}
+	rec = httptest.NewRecorder()
+	userID, sessionID, err := st.sessions.Authenticate(rec, req)
+	if err == nil && userID != st.userID {
+		st.t.Fatalf("Authenticate: user %d, want %d", userID, st.userID)
+	}
+	return sessionID, rec, err
+}
+
+func cookie(rec *httptest.ResponseRecorder, name string) string {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func TestSessionRefreshRotates(t *testing.T) {
+	st := newSessionTest(t)
+	_, first := st.start()
+
+	sessionID, rec, err := st.authenticate("", first)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	second := cookie(rec, RefreshCookie)
+	if second == "" || second == first || cookie(rec, AccessCookie) == "" {
+		t.Fatalf("refresh set access %q, refresh %q", cookie(rec, AccessCookie), second)
+	}
+
+	// A parallel request with the old token, within the grace period, gets
+	// an access token but no new refresh token
+	if id, rec, err := st.authenticate("", first); err != nil || id != sessionID {
+		t.Fatalf("old token in grace period: session %d, %v", id, err)
+	} else if cookie(rec, RefreshCookie) != "" || cookie(rec, AccessCookie) == "" {
+		t.Errorf("old token in grace period set refresh %q", cookie(rec, RefreshCookie))
+	}
+
+	if id, _, err := st.authenticate("", second); err != nil || id != sessionID {
+		t.Errorf("new token: session %d, %v", id, err)
+	}
+}
+
+func TestSessionRefreshReuseRevokes(t *testing.T) {
+	st := newSessionTest(t)
+	_, first := st.start()
+	sessionID, rec, err := st.authenticate("", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := cookie(rec, RefreshCookie)
+
+	if _, err := st.sessions.db.Exec("UPDATE sessions SET rotated_at = ? WHERE id = ?", time.Now().UTC().Add(-rotationGrace-time.Second), sessionID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := st.authenticate("", first); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("replayed token: got %v, want ErrNoSession", err)
+	}
+
+	// Whoever held the current token is signed out too
+	if _, _, err := st.authenticate("", second); !errors.Is(err, ErrNoSession) {
+		t.Errorf("current token after reuse: got %v, want ErrNoSession", err)
+	}
+	if list, err := st.sessions.List(context.Background(), st.userID, 0); err != nil || len(list) != 0 {
+		t.Errorf("List = %+v, %v; want no sessions", list, err)
+	}
+}
+
+func TestSessionRevokedRejectsAccessToken(t *testing.T) {
+	st := newSessionTest(t)
+	access, refresh := st.start()
+	sessionID, _, err := st.authenticate(access, "")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	if err := st.sessions.Revoke(context.Background(), st.userID, sessionID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, _, err := st.authenticate(access, ""); !errors.Is(err, ErrNoSession) {
+		t.Errorf("access token: got %v, want ErrNoSession", err)
+	}
+	// Nor can the refresh token revive it
+	if _, _, err := st.authenticate(access, refresh); !errors.Is(err, ErrNoSession) {
+		t.Errorf("access and refresh tokens: got %v, want ErrNoSession", err)
+	}
+	if _, _, err := st.authenticate("", refresh); !errors.Is(err, ErrNoSession) {
+		t.Errorf("refresh token: got %v, want ErrNoSession", err)
+	}
+}
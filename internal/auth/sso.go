@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/oidc"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// ssoCookie carries the signed state, nonce and PKCE verifier of a login
+	// between the redirect to the provider and its callback.
+	ssoCookie = "sso_login"
+	ssoTTL    = 10 * time.Minute
+)
+
+var (
+	// ErrSSOState means the callback does not belong to a login started
+	// from this browser, or it took too long.
+	ErrSSOState = errors.New("single sign-on state mismatch")
+	// ErrSSOEmailUnverified means the provider did not vouch for the
+	// user's email address, so it can't be matched to an account.
+	ErrSSOEmailUnverified = errors.New("identity provider did not verify the email address")
+	// ErrSSOAccountUnverified means an account with the provider's email
+	// address exists but its owner never confirmed the address. Anyone can
+	// register with any address, so linking it could hand the provider's
+	// user an account someone else set up, and still holds the password of.
+	ErrSSOAccountUnverified = errors.New("existing account's email address is not verified")
+)
+
+// ssoFlow is the per-login state kept in ssoCookie.
+type ssoFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// SSO signs users in through an OpenID Connect provider. Provider accounts
+// are linked to local users by their verified email address the first time
+// they sign in, and an account is created if there is none.
+type SSO struct {
+	db       *sql.DB
+	jwt      *JWTService
+	provider *oidc.Provider
+}
+
+// NewSSO returns single sign-on through provider.
+func NewSSO(db *sql.DB, jwtService *JWTService, provider *oidc.Provider) *SSO {
+	return &SSO{db: db, jwt: jwtService, provider: provider}
+}
+
+// Name returns the provider's display name.
+func (s *SSO) Name() string {
+	return s.provider.Name()
+}
+
+// Begin starts a login and returns the provider URL to redirect to.
+func (s *SSO) Begin(w http.ResponseWriter, r *http.Request) (string, error) {
+	state, _, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, _, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	flow := ssoFlow{State: state, Nonce: nonce, Verifier: oauth2.GenerateVerifier()}
+
+	authURL, err := s.provider.AuthCodeURL(r.Context(), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(flow)
+	if err != nil {
+		return "", fmt.Errorf("encode sso state: %w", err)
+	}
+	token, err := s.jwt.GeneratePurposeToken(0, ssoCookie, string(data), ssoTTL)
+	if err != nil {
+		return "", fmt.Errorf("sign sso state: %w", err)
+	}
+	// Lax, not Strict: the callback is a top-level navigation coming from
+	// the provider's site
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoCookie,
+		Value:    token,
+		HttpOnly: true,
+		Path:     "/login/sso",
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(ssoTTL / time.Second),
+	})
+	return authURL, nil
+}
+
+// Finish completes a login from the provider's callback request and returns
+// the local user it signed in, creating or linking the account if needed.
+func (s *SSO) Finish(w http.ResponseWriter, r *http.Request) (int, error) {
+	c, err := r.Cookie(ssoCookie)
+	if err != nil {
+		return 0, ErrSSOState
+	}
+	http.SetCookie(w, &http.Cookie{Name: ssoCookie, Value: "", HttpOnly: true, Path: "/login/sso", MaxAge: -1})
+
+	_, data, err := s.jwt.ValidatePurposeToken(c.Value, ssoCookie)
+	if err != nil {
+		return 0, ErrSSOState
+	}
+	var flow ssoFlow
+	if err := json.Unmarshal([]byte(data), &flow); err != nil || flow.State == "" || r.URL.Query().Get("state") != flow.State {
+		return 0, ErrSSOState
+	}
+
+	identity, err := s.provider.Exchange(r.Context(), r.URL.Query().Get("code"), flow.Nonce, flow.Verifier)
+	if err != nil {
+		return 0, err
+	}
+	return s.resolve(r.Context(), identity)
+}
+
+// resolve returns the local user for a provider identity. A known identity
+// signs in to the user it was linked to, even if its email has changed
+// since. Otherwise the verified email address picks the account to link,
+// as long as that account's owner has confirmed the address too, or a new
+// account is created for it.
+func (s *SSO) resolve(ctx context.Context, id *oidc.Identity) (int, error) {
+	now := time.Now().UTC()
+
+	var userID int
+	err := s.db.QueryRowContext(ctx, "SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?", id.Issuer, id.Subject).Scan(&userID)
+	if err == nil {
+		if _, err := s.db.ExecContext(ctx, "UPDATE user_identities SET last_login_at = ?, email = ? WHERE issuer = ? AND subject = ?",
+			now, truncate(id.Email, 255), id.Issuer, id.Subject); err != nil {
+			return 0, fmt.Errorf("update identity: %w", err)
+		}
+		return userID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("load identity: %w", err)
+	}
+
+	email := strings.TrimSpace(id.Email)
+	if email == "" || !id.EmailVerified {
+		return 0, ErrSSOEmailUnverified
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var verifiedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT id, email_verified_at FROM users WHERE LOWER(email) = LOWER(?)", email).Scan(&userID, &verifiedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		userID, err = createSSOUser(ctx, tx, email, now)
+		if err != nil {
+			return 0, err
+		}
+	case err != nil:
+		return 0, fmt.Errorf("find user: %w", err)
+	case !verifiedAt.Valid:
+		// The owner of the address can claim the account with a password
+		// reset, which verifies it and signs everyone else out
+		return 0, ErrSSOAccountUnverified
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO user_identities (user_id, issuer, subject, email, created_at, last_login_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, id.Issuer, id.Subject, truncate(email, 255), now, now); err != nil {
+		return 0, fmt.Errorf("link identity: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit identity: %w", err)
+	}
+	return userID, nil
+}
+
+// createSSOUser provisions an account for a first-time single sign-on user.
+// It gets a random password nobody knows; the user can set one with the
+// password reset flow if they ever want to log in without the provider.
+func createSSOUser(ctx context.Context, tx *sql.Tx, email string, now time.Time) (int, error) {
+	password, _, err := newRefreshToken()
+	if err != nil {
+		return 0, err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, fmt.Errorf("hash password: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, "INSERT INTO users (email, password, email_verified_at) VALUES (?, ?, ?)", email, string(hashed), now)
+	if err != nil {
+		return 0, fmt.Errorf("create user: %w", err)
+	}
+	userID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("read user id: %w", err)
+	}
+	return int(userID), nil
+}
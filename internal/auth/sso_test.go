@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+	"github.com/ahsanfayaz52/diaryservice/internal/oidc"
+	"github.com/ahsanfayaz52/diaryservice/internal/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const ssoCallback = "http://app.test/login/sso/callback"
+
+type ssoTest struct {
+	sso *SSO
+	idp *oidctest.Provider
+	db  *sql.DB
+
+	mu        sync.Mutex
+	verifiers []string // code_verifier of each token request
+}
+
+func newSSOTest(t *testing.T, user oidctest.User) *ssoTest {
+	t.Helper()
+
+	st := &ssoTest{db: dbtest.New(t)}
+	srv := httptest.NewUnstartedServer(nil)
+	idp, err := oidctest.New("http://"+srv.Listener.Addr().String(), "diary", "secret", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" && r.ParseForm() == nil {
+			st.mu.Lock()
+			st.verifiers = append(st.verifiers, r.PostForm.Get("code_verifier"))
+			st.mu.Unlock()
+		}
+		idp.ServeHTTP(w, r)
+	})
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	provider := oidc.New(oidc.Config{IssuerURL: idp.Issuer(), ClientID: "diary", ClientSecret: "secret", RedirectURL: ssoCallback})
+	st.idp = idp
+	st.sso = NewSSO(st.db, NewJWTService("test-secret", time.Hour), provider)
+	return st
+}
+
+// callback is the provider's redirect back to the app after a login.
+type callback struct {
+	authURL *url.URL
+	cookies []*http.Cookie
+	code    string
+	state   string
+}
+
+// begin starts a login and has the provider authorize it.
+func (st *ssoTest) begin(t *testing.T) callback {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	authURL, err := st.sso.Begin(rec, httptest.NewRequest(http.MethodGet, "/login/sso", nil))
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.Query().Get("code") == "" {
+		t.Fatalf("authorize redirected to %q", resp.Header.Get("Location"))
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback{
+		authURL: parsed,
+		cookies: rec.Result().Cookies(),
+		code:    location.Query().Get("code"),
+		state:   location.Query().Get("state"),
+	}
+}
+
+func (st *ssoTest) finish(cb callback) (int, error) {
+	q := url.Values{"code": {cb.code}, "state": {cb.state}}
+	req := httptest.NewRequest(http.MethodGet, "/login/sso/callback?"+q.Encode(), nil)
+	for _, c := range cb.cookies {
+		req.AddCookie(c)
+	}
+	return st.sso.Finish(httptest.NewRecorder(), req)
+}
+
+func (st *ssoTest) login(t *testing.T) (int, error) {
+	t.Helper()
+	return st.finish(st.begin(t))
+}
+
+func (st *ssoTest) identities(t *testing.T, userID int) int {
+	t.Helper()
+	var n int
+	if err := st.db.QueryRow("SELECT COUNT(*) FROM user_identities WHERE user_id = ?", userID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+var alice = oidctest.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+
+func TestSSOStateMismatch(t *testing.T) {
+	st := newSSOTest(t, alice)
+
+	cb := st.begin(t)
+	cb.state = "forged"
+	if _, err := st.finish(cb); !errors.Is(err, ErrSSOState) {
+		t.Errorf("wrong state: got %v, want ErrSSOState", err)
+	}
+
+	cb = st.begin(t)
+	cb.cookies = nil
+	if _, err := st.finish(cb); !errors.Is(err, ErrSSOState) {
+		t.Errorf("no cookie: got %v, want ErrSSOState", err)
+	}
+}
+
+func TestSSOSendsPKCEVerifier(t *testing.T) {
+	st := newSSOTest(t, alice)
+
+	cb := st.begin(t)
+	if _, err := st.finish(cb); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	if got := cb.authURL.Query().Get("code_challenge_method"); got != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", got)
+	}
+	if len(st.verifiers) != 1 || st.verifiers[0] == "" {
+		t.Fatalf("token requests sent verifiers %q", st.verifiers)
+	}
+	sum := sha256.Sum256([]byte(st.verifiers[0]))
+	if want := base64.RawURLEncoding.EncodeToString(sum[:]); cb.authURL.Query().Get("code_challenge") != want {
+		t.Errorf("code_challenge does not match the verifier sent")
+	}
+}
+
+func TestSSORejectsBadIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(jwt.MapClaims)
+		want   error
+	}{
+		{"nonce", func(c jwt.MapClaims) { c["nonce"] = "another-login" }, oidc.ErrNonce},
+		{"audience", func(c jwt.MapClaims) { c["aud"] = "another-client" }, nil},
+		{"issuer", func(c jwt.MapClaims) { c["iss"] = "http://evil.test" }, nil},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newSSOTest(t, alice)
+			st.idp.Tamper(tt.tamper)
+
+			_, err := st.login(t)
+			if err == nil {
+				t.Fatal("login succeeded")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("signature", func(t *testing.T) {
+		st := newSSOTest(t, alice)
+		if err := st.idp.SignWithUnpublishedKey(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := st.login(t); err == nil {
+			t.Fatal("login succeeded")
+		}
+	})
+
+	t.Run("no user created", func(t *testing.T) {
+		st := newSSOTest(t, alice)
+		st.idp.Tamper(func(c jwt.MapClaims) { c["aud"] = "another-client" })
+		st.login(t)
+
+		var n int
+		if err := st.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%d users created from a rejected token", n)
+		}
+	})
+}
+
+func TestSSOCreatesUser(t *testing.T) {
+	st := newSSOTest(t, alice)
+
+	userID, err := st.login(t)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	var email string
+	var verifiedAt sql.NullTime
+	if err := st.db.QueryRow("SELECT email, email_verified_at FROM users WHERE id = ?", userID).Scan(&email, &verifiedAt); err != nil {
+		t.Fatal(err)
+	}
+	if email != alice.Email || !verifiedAt.Valid {
+		t.Errorf("created user %q, verified %v", email, verifiedAt.Valid)
+	}
+	if n := st.identities(t, userID); n != 1 {
+		t.Errorf("user has %d identities, want 1", n)
+	}
+}
+
+func TestSSOKnownIdentity(t *testing.T) {
+	st := newSSOTest(t, alice)
+
+	first, err := st.login(t)
+	if err != nil {
+		t.Fatalf("first login: %v", err)
+	}
+
+	// The identity keeps its account even after the email changes, and
+	// even if the provider stops vouching for it
+	renamed := alice
+	renamed.Email = "alice@new.example.com"
+	renamed.EmailVerified = false
+	st.idp.SetUser(renamed)
+	second, err := st.login(t)
+	if err != nil {
+		t.Fatalf("second login: %v", err)
+	}
+	if second != first {
+		t.Errorf("second login signed in user %d, want %d", second, first)
+	}
+
+	var email string
+	if err := st.db.QueryRow("SELECT email FROM user_identities WHERE user_id = ?", first).Scan(&email); err != nil {
+		t.Fatal(err)
+	}
+	if email != renamed.Email {
+		t.Errorf("identity email = %q, want %q", email, renamed.Email)
+	}
+}
+
+func TestSSOLinksVerifiedEmail(t *testing.T) {
+	st := newSSOTest(t, alice)
+	existing := dbtest.CreateUser(t, st.db, "Alice@Example.com", true)
+
+	userID, err := st.login(t)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if userID != existing {
+		t.Errorf("signed in user %d, want existing user %d", userID, existing)
+	}
+	if n := st.identities(t, existing); n != 1 {
+		t.Errorf("user has %d identities, want 1", n)
+	}
+}
+
+func TestSSORefusesUnverifiedAccount(t *testing.T) {
+	st := newSSOTest(t, alice)
+	existing := dbtest.CreateUser(t, st.db, alice.Email, false)
+
+	if _, err := st.login(t); !errors.Is(err, ErrSSOAccountUnverified) {
+		t.Fatalf("got %v, want ErrSSOAccountUnverified", err)
+	}
+
+	var verifiedAt sql.NullTime
+	if err := st.db.QueryRow("SELECT email_verified_at FROM users WHERE id = ?", existing).Scan(&verifiedAt); err != nil {
+		t.Fatal(err)
+	}
+	if verifiedAt.Valid {
+		t.Error("account was marked verified")
+	}
+	if n := st.identities(t, existing); n != 0 {
+		t.Errorf("user has %d identities, want 0", n)
+	}
+}
+
+func TestSSORejectsUnverifiedEmail(t *testing.T) {
+	unverified := alice
+	unverified.EmailVerified = false
+	st := newSSOTest(t, unverified)
+
+	if _, err := st.login(t); !errors.Is(err, ErrSSOEmailUnverified) {
+		t.Fatalf("new user: got %v, want ErrSSOEmailUnverified", err)
+	}
+
+	// Nor may it take over an account with that address
+	existing := dbtest.CreateUser(t, st.db, alice.Email, true)
+	if _, err := st.login(t); !errors.Is(err, ErrSSOEmailUnverified) {
+		t.Fatalf("existing user: got %v, want ErrSSOEmailUnverified", err)
+	}
+	if n := st.identities(t, existing); n != 0 {
+		t.Errorf("user has %d identities, want 0", n)
+	}
+}
@@ -0,0 +1,323 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/mail"
+)
+
+const (
+	throttleAccount = "account"
+	throttleIP      = "ip"
+
+	// Failures older than this are forgotten.
+	throttleWindow = 24 * time.Hour
+	// The first delay once backoff starts; it doubles with each failure.
+	backoffBase = time.Second
+	// How long an account stays locked unless the user follows the link in
+	// the unlock email.
+	lockoutDuration = time.Hour
+	unlockTokenTTL  = 24 * time.Hour
+)
+
+// throttlePolicy sets how a series of failures is slowed down.
+type throttlePolicy struct {
+	free     int           // failures allowed before backoff starts
+	maxDelay time.Duration // longest backoff delay
+	lockAt   int           // failures that lock the account; 0 never locks
+}
+
+var (
+	accountPolicy = throttlePolicy{free: 5, maxDelay: 15 * time.Minute, lockAt: 10}
+	// Addresses can be shared by a whole office, so they get more leeway and
+	// are never locked outright.
+	ipPolicy = throttlePolicy{free: 20, maxDelay: 15 * time.Minute}
+)
+
+// ThrottledError is returned when login attempts are refused for a while.
+// Locked means the account itself is locked rather than just backing off.
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("too many failed logins, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// LoginThrottle slows down password guessing. Failed attempts are counted
+// per account and per client IP; past a few failures each further attempt
+// has to wait exponentially longer, and an account that keeps failing is
+// locked for a while and its owner emailed a link to unlock it.
+type LoginThrottle struct {
+	db      *sql.DB
+	tokens  *OneTimeTokens
+	mailer  mail.Mailer
+	baseURL string
+}
+
+func NewLoginThrottle(db *sql.DB, tokens *OneTimeTokens, mailer mail.Mailer, baseURL string) *LoginThrottle {
+	return &LoginThrottle{db: db, tokens: tokens, mailer: mailer, baseURL: baseURL}
+}
+
+// Check returns a *ThrottledError if a login for email from ip must not be
+// attempted yet.
+func (t *LoginThrottle) Check(ctx context.Context, email, ip string) error {
+	rows, err := t.db.QueryContext(ctx, `
+		SELECT kind, blocked_until, locked_until FROM login_throttles
+		WHERE (kind = ? AND subject = ?) OR (kind = ? AND subject = ?)`,
+		throttleAccount, accountKey(email), throttleIP, ip)
+	if err != nil {
+		return fmt.Errorf("query login throttles: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	var throttled *ThrottledError
+	for rows.Next() {
+		var (
+			kind            string
+			blocked, locked sql.NullTime
+		)
+		if err := rows.Scan(&kind, &blocked, &locked); err != nil {
+			return fmt.Errorf("scan login throttle: %w", err)
+		}
+		for _, until := range []sql.NullTime{blocked, locked} {
+			if !until.Valid || !until.Time.After(now) {
+				continue
+			}
+			if throttled == nil {
+				throttled = &ThrottledError{}
+			}
+			if wait := until.Time.Sub(now); wait > throttled.RetryAfter {
+				throttled.RetryAfter = wait
+			}
+		}
+		if kind == throttleAccount && locked.Valid && locked.Time.After(now) {
+			throttled.Locked = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if throttled != nil {
+		return throttled
+	}
+	return nil
+}
+
+// Fail records a failed login for email from ip.
+func (t *LoginThrottle) Fail(ctx context.Context, email, ip string) error {
+	failures, locked, err := t.record(ctx, throttleAccount, accountKey(email), accountPolicy)
+	if err != nil {
+		return err
+	}
+	if locked {
+		log.Printf("Login throttle: account %q locked for %s after %d failed attempts, the last from %s",
+			accountKey(email), lockoutDuration, failures, ip)
+		go t.sendUnlock(accountKey(email))
+	}
+
+	failures, _, err = t.record(ctx, throttleIP, ip, ipPolicy)
+	if err != nil {
+		return err
+	}
+	if failures >= ipPolicy.free && failures%ipPolicy.free == 0 {
+		log.Printf("Login throttle: %d failed attempts from %s in the last %s", failures, ip, throttleWindow)
+	}
+	return nil
+}
+
+// Reset forgets the failed attempts on an account after a successful
+// login. Those from the client's address are left to expire, so that one
+// account the attacker controls can't be used to reset them.
+func (t *LoginThrottle) Reset(ctx context.Context, email string) error {
+	_, err := t.db.ExecContext(ctx, "DELETE FROM login_throttles WHERE kind = ? AND subject = ?", throttleAccount, accountKey(email))
+	if err != nil {
+		return fmt.Errorf("reset login throttle: %w", err)
+	}
+	return nil
+}
+
+// CheckUser is Check for a user whose password has already been accepted,
+// such as one at the two-factor step.
+func (t *LoginThrottle) CheckUser(ctx context.Context, userID int, ip string) error {
+	email, err := t.emailOf(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return t.Check(ctx, email, ip)
+}
+
+// FailUser is Fail for a user whose password has already been accepted.
+func (t *LoginThrottle) FailUser(ctx context.Context, userID int, ip string) error {
+	email, err := t.emailOf(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return t.Fail(ctx, email, ip)
+}
+
+// ResetUser is Reset for a user whose password has already been accepted.
+func (t *LoginThrottle) ResetUser(ctx context.Context, userID int) error {
+	email, err := t.emailOf(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return t.Reset(ctx, email)
+}
+
+// Unlock lifts the lock on an account using the token from an unlock email.
+func (t *LoginThrottle) Unlock(ctx context.Context, token string) error {
+	userID, err := t.tokens.Consume(ctx, token, PurposeAccountUnlock)
+	if err != nil {
+		return err
+	}
+	if err := t.ResetUser(ctx, userID); err != nil {
+		return err
+	}
+	log.Printf("Login throttle: user %d unlocked their account", userID)
+	return nil
+}
+
+// DeleteStale removes counters whose last failure was before cutoff and
+// that are no longer blocking anything.
+func (t *LoginThrottle) DeleteStale(ctx context.Context, cutoff time.Time) (int64, error) {
+	now := time.Now().UTC()
+	res, err := t.db.ExecContext(ctx, `
+		DELETE FROM login_throttles
+		WHERE last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?) AND (blocked_until IS NULL OR blocked_until < ?)`,
+		cutoff.UTC(), now, now)
+	if err != nil {
+		return 0, fmt.Errorf("delete stale login throttles: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// record counts a failure against one subject and sets how long it is
+// blocked for. It reports whether this failure locked the subject.
+func (t *LoginThrottle) record(ctx context.Context, kind, subject string, policy throttlePolicy) (int, bool, error) {
+	now := time.Now().UTC()
+
+	update := func() (int64, error) {
+		res, err := t.db.ExecContext(ctx, `
+			UPDATE login_throttles
+			SET failures = CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END, last_failure_at = ?
+			WHERE kind = ? AND subject = ?`,
+			now.Add(-throttleWindow), now, kind, subject)
+		if err != nil {
+			return 0, fmt.Errorf("count failed login: %w", err)
+		}
+		return res.RowsAffected()
+	}
+	n, err := update()
+	if err != nil {
+		return 0, false, err
+	}
+	if n == 0 {
+		_, err := t.db.ExecContext(ctx, "INSERT INTO login_throttles (kind, subject, failures, last_failure_at) VALUES (?, ?, 1, ?)",
+			kind, subject, now)
+		if err != nil {
+			// Another replica inserted the row first
+			if _, err := update(); err != nil {
+				return 0, false, err
+			}
+		}
+	}
+
+	var (
+		failures int
+		locked   sql.NullTime
+	)
+	err = t.db.QueryRowContext(ctx, "SELECT failures, locked_until FROM login_throttles WHERE kind = ? AND subject = ?", kind, subject).
+		Scan(&failures, &locked)
+	if err != nil {
+		return 0, false, fmt.Errorf("load login throttle: %w", err)
+	}
+
+	var blockedUntil sql.NullTime
+	if failures >= policy.free {
+		blockedUntil = sql.NullTime{Time: now.Add(backoff(failures-policy.free, policy.maxDelay)), Valid: true}
+	}
+	lockedNow := false
+	if policy.lockAt > 0 && failures >= policy.lockAt && !(locked.Valid && locked.Time.After(now)) {
+		locked = sql.NullTime{Time: now.Add(lockoutDuration), Valid: true}
+		lockedNow = true
+	}
+
+	_, err = t.db.ExecContext(ctx, "UPDATE login_throttles SET blocked_until = ?, locked_until = ? WHERE kind = ? AND subject = ?",
+		blockedUntil, locked, kind, subject)
+	if err != nil {
+		return 0, false, fmt.Errorf("block login: %w", err)
+	}
+	return failures, lockedNow, nil
+}
+
+// sendUnlock emails the owner of a locked account, if there is one, a link
+// to unlock it. It runs in the background so that the response time of a
+// failed login doesn't reveal whether the account exists.
+func (t *LoginThrottle) sendUnlock(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var userID int
+	var address string
+	err := t.db.QueryRowContext(ctx, "SELECT id, email FROM users WHERE LOWER(email) = ?", email).Scan(&userID, &address)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Println("Unlock email lookup error:", err)
+		return
+	}
+
+	token, err := t.tokens.Issue(ctx, userID, PurposeAccountUnlock, unlockTokenTTL)
+	if err != nil {
+		log.Println("Unlock token error:", err)
+		return
+	}
+	err = t.mailer.Send(ctx, mail.Message{
+		To:      address,
+		Subject: "Your AI Note Assistant account has been locked",
+		Body: fmt.Sprintf("There were several failed attempts to log in to your account, so we've locked it for the next hour.\n\n"+
+			"If this was you, you can unlock it now by opening this link:\n\n%s/unlock-account?token=%s\n\n"+
+			"If it wasn't you, someone may be trying to guess your password. Your account is safe while it's locked, "+
+			"but consider choosing a stronger password and turning on two-factor authentication.\n",
+			t.baseURL, url.QueryEscape(token)),
+	})
+	if err != nil {
+		log.Println("Send unlock email error:", err)
+	}
+}
+
+func (t *LoginThrottle) emailOf(ctx context.Context, userID int) (string, error) {
+	var email string
+	if err := t.db.QueryRowContext(ctx, "SELECT email FROM users WHERE id = ?", userID).Scan(&email); err != nil {
+		return "", fmt.Errorf("load user: %w", err)
+	}
+	return email, nil
+}
+
+// backoff returns the delay after the nth failure past the free ones.
+func backoff(n int, max time.Duration) time.Duration {
+	if n > 30 {
+		return max
+	}
+	if d := backoffBase << n; d < max {
+		return d
+	}
+	return max
+}
+
+// accountKey normalises an email address so that changing its case doesn't
+// start a fresh count.
+func accountKey(email string) string {
+	return truncate(strings.ToLower(strings.TrimSpace(email)), 255)
+}
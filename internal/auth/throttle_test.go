@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+	"github.com/ahsanfayaz52/diaryservice/internal/mail"
+)
+
+type mailRecorder chan mail.Message
+
+func (m mailRecorder) Send(ctx context.Context, msg mail.Message) error {
+	m <- msg
+	return nil
+}
+
+func newThrottleTest(t *testing.T) (*LoginThrottle, mailRecorder) {
+	conn := dbtest.New(t)
+	dbtest.CreateUser(t, conn, "a@example.com", true)
+	sent := make(mailRecorder, 10)
+	return NewLoginThrottle(conn, NewOneTimeTokens(conn), sent, "http://localhost"), sent
+}
+
+// throttled returns the error from Check, failing the test if it is not
+// nil or a *ThrottledError.
+func throttled(t *testing.T, throttle *LoginThrottle, email, ip string) *ThrottledError {
+	t.Helper()
+	err := throttle.Check(context.Background(), email, ip)
+	var te *ThrottledError
+	if err != nil && !errors.As(err, &te) {
+		t.Fatalf("Check: %v", err)
+	}
+	return te
+}
+
+func fail(t *testing.T, throttle *LoginThrottle, email, ip string, times int) {
+	t.Helper()
+	for i := 0; i < times; i++ {
+		if err := throttle.Fail(context.Background(), email, ip); err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{9, 512 * time.Second},
+		{10, 15 * time.Minute},
+		{31, 15 * time.Minute},
+		{1000, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.n, 15*time.Minute); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottleAccount(t *testing.T) {
+	throttle, sent := newThrottleTest(t)
+	ctx := context.Background()
+
+	// The first few failures are free
+	fail(t, throttle, "a@example.com", "10.0.0.1", accountPolicy.free-1)
+	if te := throttled(t, throttle, "a@example.com", "10.0.0.1"); te != nil {
+		t.Fatalf("after %d failures: %v", accountPolicy.free-1, te)
+	}
+
+	// Then each one doubles the wait, counted case-insensitively and
+	// whichever address they come from
+	for i := 0; i < accountPolicy.lockAt-accountPolicy.free; i++ {
+		fail(t, throttle, "A@Example.com ", fmt.Sprintf("10.0.1.%d", i), 1)
+		want := backoffBase << i
+		te := throttled(t, throttle, "a@example.com", "10.0.2.1")
+		if te == nil || te.Locked || te.RetryAfter > want || te.RetryAfter < want-time.Second {
+			t.Fatalf("after %d failures: got %+v, want a wait of %s", accountPolicy.free+i, te, want)
+		}
+	}
+
+	// The tenth locks the account for an hour
+	fail(t, throttle, "a@example.com", "10.0.0.1", 1)
+	te := throttled(t, throttle, "a@example.com", "10.0.2.1")
+	if te == nil || !te.Locked || te.RetryAfter > lockoutDuration || te.RetryAfter < lockoutDuration-time.Second {
+		t.Fatalf("after %d failures: got %+v, want locked for %s", accountPolicy.lockAt, te, lockoutDuration)
+	}
+
+	// The owner is emailed a link that unlocks it
+	var msg mail.Message
+	select {
+	case msg = <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no unlock email sent")
+	}
+	match := regexp.MustCompile(`unlock-account\?token=(\S+)`).FindStringSubmatch(msg.Body)
+	if msg.To != "a@example.com" || match == nil {
+		t.Fatalf("unlock email to %q: %s", msg.To, msg.Body)
+	}
+	token, _ := url.QueryUnescape(match[1])
+	if err := throttle.Unlock(ctx, token); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if te := throttled(t, throttle, "a@example.com", "10.0.2.1"); te != nil {
+		t.Errorf("after Unlock: %v", te)
+	}
+	if err := throttle.Unlock(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("second Unlock: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestLoginThrottleResetOnSuccess(t *testing.T) {
+	throttle, _ := newThrottleTest(t)
+	ctx := context.Background()
+
+	fail(t, throttle, "a@example.com", "10.0.0.1", accountPolicy.free)
+	if te := throttled(t, throttle, "a@example.com", "10.0.0.1"); te == nil {
+		t.Fatal("not throttled")
+	}
+	if err := throttle.Reset(ctx, "A@example.com"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if te := throttled(t, throttle, "a@example.com", "10.0.0.1"); te != nil {
+		t.Fatalf("after Reset: %v", te)
+	}
+
+	// The count starts again from zero
+	fail(t, throttle, "a@example.com", "10.0.0.1", accountPolicy.free-1)
+	if te := throttled(t, throttle, "a@example.com", "10.0.0.1"); te != nil {
+		t.Errorf("after Reset and %d failures: %v", accountPolicy.free-1, te)
+	}
+}
+
+func TestLoginThrottleIP(t *testing.T) {
+	throttle, sent := newThrottleTest(t)
+	ctx := context.Background()
+
+	// Spread across accounts, failures only add up per address
+	for i := 0; i < ipPolicy.free; i++ {
+		fail(t, throttle, fmt.Sprintf("user%d@example.com", i), "10.0.0.1", 1)
+	}
+	te := throttled(t, throttle, "a@example.com", "10.0.0.1")
+	if te == nil || te.Locked || te.RetryAfter > backoffBase || te.RetryAfter <= 0 {
+		t.Fatalf("from the busy address: got %+v, want a wait of %s", te, backoffBase)
+	}
+	if te := throttled(t, throttle, "a@example.com", "10.0.0.2"); te != nil {
+		t.Errorf("from another address: %v", te)
+	}
+
+	// Signing in to one account doesn't clear the address
+	if err := throttle.Reset(ctx, "user0@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if te := throttled(t, throttle, "user0@example.com", "10.0.0.1"); te == nil {
+		t.Error("Reset cleared the address's failures")
+	}
+
+	// Addresses are never locked
+	for i := 0; i < accountPolicy.lockAt; i++ {
+		fail(t, throttle, fmt.Sprintf("other%d@example.com", i), "10.0.0.1", 1)
+	}
+	if te := throttled(t, throttle, "a@example.com", "10.0.0.1"); te == nil || te.Locked {
+		t.Errorf("after %d failures: got %+v, want backoff only", ipPolicy.free+accountPolicy.lockAt, te)
+	}
+	select {
+	case msg := <-sent:
+		t.Errorf("unexpected email to %s", msg.To)
+	default:
+	}
+}
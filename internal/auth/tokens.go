@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Purposes of one-time tokens. A token only works for the purpose it was
+// issued for.
+const (
+	PurposePasswordReset = "password_reset"
+	PurposeEmailVerify   = "email_verify"
+	PurposeAccountUnlock = "account_unlock"
+)
+
+// ErrInvalidToken means a one-time token is unknown, expired, already used
+// or issued for another purpose.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// OneTimeTokens issues single-use tokens for links sent by email. Only a
+// SHA-256 digest of each token is stored.
+type OneTimeTokens struct {
+	db *sql.DB
+}
+
+func NewOneTimeTokens(db *sql.DB) *OneTimeTokens {
+	return &OneTimeTokens{db: db}
+}
+
+// Issue creates a token for the user and purpose that is valid for ttl.
+// Unused tokens issued earlier for the same purpose stop working.
+func (t *OneTimeTokens) Issue(ctx context.Context, userID int, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, "UPDATE user_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL", now, userID, purpose)
+	if err != nil {
+		return "", fmt.Errorf("expire old tokens: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO user_tokens (token_hash, user_id, purpose, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)`, hash, userID, purpose, now, now.Add(ttl))
+	if err != nil {
+		return "", fmt.Errorf("insert token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit transaction: %w", err)
+	}
+	return token, nil
+}
+
+// Lookup returns the user a valid token belongs to without using it up.
+func (t *OneTimeTokens) Lookup(ctx context.Context, token, purpose string) (int, error) {
+	var userID int
+	err := t.db.QueryRowContext(ctx, `
+		SELECT user_id FROM user_tokens
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?`,
+		hashToken(token), purpose, time.Now().UTC()).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInvalidToken
+	}
+	if err != nil {
+		return 0, fmt.Errorf("look up token: %w", err)
+	}
+	return userID, nil
+}
+
+// Consume uses up a valid token and returns its user. Of several concurrent
+// calls with the same token only one succeeds.
+func (t *OneTimeTokens) Consume(ctx context.Context, token, purpose string) (int, error) {
+	hash := hashToken(token)
+	now := time.Now().UTC()
+	res, err := t.db.ExecContext(ctx, `
+		UPDATE user_tokens SET used_at = ?
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?`,
+		now, hash, purpose, now)
+	if err != nil {
+		return 0, fmt.Errorf("use token: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, fmt.Errorf("use token: %w", err)
+	} else if n == 0 {
+		return 0, ErrInvalidToken
+	}
+
+	var userID int
+	if err := t.db.QueryRowContext(ctx, "SELECT user_id FROM user_tokens WHERE token_hash = ?", hash).Scan(&userID); err != nil {
+		return 0, fmt.Errorf("read token: %w", err)
+	}
+	return userID, nil
+}
+
+// IssuedSince counts the tokens issued to the user for a purpose since the
+// given time, used or not, and returns when the latest was issued.
+func (t *OneTimeTokens) IssuedSince(ctx context.Context, userID int, purpose string, since time.Time) (int, time.Time, error) {
+	rows, err := t.db.QueryContext(ctx, `
+		SELECT created_at FROM user_tokens
+		WHERE user_id = ? AND purpose = ? AND created_at > ?
+		ORDER BY created_at DESC`,
+		userID, purpose, since.UTC())
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("list tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		count  int
+		latest time.Time
+	)
+	for rows.Next() {
+		var created time.Time
+		if err := rows.Scan(&created); err != nil {
+			return 0, time.Time{}, fmt.Errorf("scan token: %w", err)
+		}
+		if count == 0 {
+			latest = created
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, time.Time{}, fmt.Errorf("list tokens: %w", err)
+	}
+	return count, latest, nil
+}
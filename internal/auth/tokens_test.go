@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+)
+
+func TestOneTimeTokens(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	tokens := NewOneTimeTokens(conn)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+
+	token, err := tokens.Issue(ctx, userID, PurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	// Looking a token up leaves it valid
+	for i := 0; i < 2; i++ {
+		if got, err := tokens.Lookup(ctx, token, PurposePasswordReset); err != nil || got != userID {
+			t.Fatalf("Lookup = %d, %v; want %d", got, err, userID)
+		}
+	}
+	if _, err := tokens.Lookup(ctx, token, PurposeEmailVerify); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Lookup for another purpose: got %v, want ErrInvalidToken", err)
+	}
+	if _, err := tokens.Consume(ctx, token, PurposeEmailVerify); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Consume for another purpose: got %v, want ErrInvalidToken", err)
+	}
+
+	if got, err := tokens.Consume(ctx, token, PurposePasswordReset); err != nil || got != userID {
+		t.Fatalf("Consume = %d, %v; want %d", got, err, userID)
+	}
+	if _, err := tokens.Consume(ctx, token, PurposePasswordReset); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("second Consume: got %v, want ErrInvalidToken", err)
+	}
+	if _, err := tokens.Lookup(ctx, token, PurposePasswordReset); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Lookup after Consume: got %v, want ErrInvalidToken", err)
+	}
+	if _, err := tokens.Lookup(ctx, "not-a-token", PurposePasswordReset); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Lookup of unknown token: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestOneTimeTokenExpiry(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	tokens := NewOneTimeTokens(conn)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+
+	token, err := tokens.Issue(ctx, userID, PurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("UPDATE user_tokens SET expires_at = ?", time.Now().UTC().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tokens.Lookup(ctx, token, PurposePasswordReset); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Lookup: got %v, want ErrInvalidToken", err)
+	}
+	if _, err := tokens.Consume(ctx, token, PurposePasswordReset); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Consume: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestOneTimeTokenReissue(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	tokens := NewOneTimeTokens(conn)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+
+	first, err := tokens.Issue(ctx, userID, PurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify, err := tokens.Issue(ctx, userID, PurposeEmailVerify, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tokens.Issue(ctx, userID, PurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tokens.Consume(ctx, first, PurposePasswordReset); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("replaced token: got %v, want ErrInvalidToken", err)
+	}
+	if _, err := tokens.Consume(ctx, second, PurposePasswordReset); err != nil {
+		t.Errorf("latest token: %v", err)
+	}
+	// Tokens for other purposes are left alone
+	if _, err := tokens.Consume(ctx, verify, PurposeEmailVerify); err != nil {
+		t.Errorf("token for another purpose: %v", err)
+	}
+
+	n, last, err := tokens.IssuedSince(ctx, userID, PurposePasswordReset, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("IssuedSince: %v", err)
+	}
+	if n != 2 || time.Since(last) > time.Minute {
+		t.Errorf("IssuedSince = %d, %v; want 2, just now", n, last)
+	}
+}
+
+func TestOneTimeTokenConsumedOnce(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	tokens := NewOneTimeTokens(conn)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+
+	token, err := tokens.Issue(ctx, userID, PurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan error, 8)
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := tokens.Consume(ctx, token, PurposePasswordReset)
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrInvalidToken):
+			t.Errorf("Consume: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d concurrent Consume calls succeeded, want 1", succeeded)
+	}
+}
@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/encryption"
+	"github.com/ahsanfayaz52/diaryservice/internal/totp"
+)
+
+const (
+	// ChallengeCookie carries the signed-in-with-password state between the
+	// two steps of a two-factor login.
+	ChallengeCookie = "login_challenge"
+	challengeTTL    = 5 * time.Minute
+	purposeTwoFA    = "2fa"
+
+	TOTPIssuer        = "AI Note Assistant"
+	recoveryCodeCount = 10
+)
+
+var (
+	// ErrInvalidCode means a TOTP or recovery code was wrong, expired or
+	// already used.
+	ErrInvalidCode = errors.New("invalid code")
+	// ErrNoEnrollment is returned when confirming 2FA that was not set up.
+	ErrNoEnrollment = errors.New("two-factor enrollment not started")
+)
+
+// TwoFactor manages TOTP two-factor authentication and recovery codes.
+// Secrets are stored encrypted under the master key.
+type TwoFactor struct {
+	db            *sql.DB
+	encryptionSvc *encryption.Service
+	jwt           *JWTService
+}
+
+func NewTwoFactor(db *sql.DB, encryptionSvc *encryption.Service, jwtService *JWTService) *TwoFactor {
+	return &TwoFactor{db: db, encryptionSvc: encryptionSvc, jwt: jwtService}
+}
+
+// Enabled reports whether the user has confirmed a TOTP enrollment.
+func (t *TwoFactor) Enabled(ctx context.Context, userID int) (bool, error) {
+	var enabledAt sql.NullTime
+	err := t.db.QueryRowContext(ctx, "SELECT enabled_at FROM user_totp WHERE user_id = ?", userID).Scan(&enabledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("load two-factor status: %w", err)
+	}
+	return enabledAt.Valid, nil
+}
+
+// BeginEnrollment generates a new secret for a user who has not enabled
+// 2FA yet, replacing any unconfirmed one, and returns it.
+func (t *TwoFactor) BeginEnrollment(ctx context.Context, userID int) (string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := t.encryptionSvc.Encrypt(secret)
+	if err != nil {
+		return "", fmt.Errorf("encrypt secret: %w", err)
+	}
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = ? AND enabled_at IS NULL", userID); err != nil {
+		return "", fmt.Errorf("clear enrollment: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO user_totp (user_id, secret, created_at) VALUES (?, ?, ?)", userID, sealed, time.Now().UTC())
+	if err != nil {
+		return "", fmt.Errorf("save enrollment: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit transaction: %w", err)
+	}
+	return secret, nil
+}
+
+// PendingSecret returns the secret of an enrollment that has not been
+// confirmed yet.
+func (t *TwoFactor) PendingSecret(ctx context.Context, userID int) (string, error) {
+	var sealed string
+	err := t.db.QueryRowContext(ctx, "SELECT secret FROM user_totp WHERE user_id = ? AND enabled_at IS NULL", userID).Scan(&sealed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNoEnrollment
+	}
+	if err != nil {
+		return "", fmt.Errorf("load enrollment: %w", err)
+	}
+	secret, err := t.encryptionSvc.Decrypt(sealed)
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret: %w", err)
+	}
+	return secret, nil
+}
+
+// Enable confirms an enrollment with a code from the authenticator and
+// returns a fresh set of recovery codes to show the user once.
+func (t *TwoFactor) Enable(ctx context.Context, userID int, code string) ([]string, error) {
+	secret, err := t.PendingSecret(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	_, err = t.db.ExecContext(ctx, "UPDATE user_totp SET enabled_at = ?, last_step = ? WHERE user_id = ? AND enabled_at IS NULL",
+		time.Now().UTC(), step, userID)
+	if err != nil {
+		return nil, fmt.Errorf("enable two-factor: %w", err)
+	}
+	return t.RegenerateRecoveryCodes(ctx, userID)
+}
+
+// Verify checks a second-factor code for a user with 2FA enabled. It
+// accepts a current TOTP code that has not been used before, or an unused
+// recovery code, which is then used up.
+func (t *TwoFactor) Verify(ctx context.Context, userID int, code string) error {
+	// Apps often show codes as "123 456"
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if strings.Contains(code, "-") || len(code) > totp.Digits {
+		return t.useRecoveryCode(ctx, userID, code)
+	}
+
+	var (
+		sealed   string
+		lastStep int64
+	)
+	err := t.db.QueryRowContext(ctx, "SELECT secret, last_step FROM user_totp WHERE user_id = ? AND enabled_at IS NOT NULL", userID).Scan(&sealed, &lastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidCode
+	}
+	if err != nil {
+		return fmt.Errorf("load two-factor secret: %w", err)
+	}
+	secret, err := t.encryptionSvc.Decrypt(sealed)
+	if err != nil {
+		return fmt.Errorf("decrypt secret: %w", err)
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok || step <= lastStep {
+		return ErrInvalidCode
+	}
+	// Only one request can move last_step past a given step
+	res, err := t.db.ExecContext(ctx, "UPDATE user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?", step, userID, step)
+	if err != nil {
+		return fmt.Errorf("record code use: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("record code use: %w", err)
+	} else if n == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// Disable turns 2FA off and deletes the secret and recovery codes. Callers
+// must have re-authenticated the user first.
+func (t *TwoFactor) Disable(ctx context.Context, userID int) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete two-factor secret: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes and returns
+// the new ones. Only their digests are kept.
+func (t *TwoFactor) RegenerateRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, fmt.Errorf("delete recovery codes: %w", err)
+	}
+	for _, code := range codes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashToken(code)); err != nil {
+			return nil, fmt.Errorf("insert recovery code: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return codes, nil
+}
+
+// RecoveryCodesLeft returns how many unused recovery codes the user has.
+func (t *TwoFactor) RecoveryCodesLeft(ctx context.Context, userID int) (int, error) {
+	var n int
+	err := t.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count recovery codes: %w", err)
+	}
+	return n, nil
+}
+
+func (t *TwoFactor) useRecoveryCode(ctx context.Context, userID int, code string) error {
+	res, err := t.db.ExecContext(ctx, "UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now().UTC(), userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("use recovery code: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("use recovery code: %w", err)
+	} else if n == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// StartChallenge remembers that the user got their password right and
+// still has to enter a second factor.
+func (t *TwoFactor) StartChallenge(w http.ResponseWriter, userID int) error {
+	token, err := t.jwt.GeneratePurposeToken(userID, purposeTwoFA, "", challengeTTL)
+	if err != nil {
+		return fmt.Errorf("generate challenge: %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     ChallengeCookie,
+		Value:    token,
+		HttpOnly: true,
+		Path:     "/login",
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(challengeTTL / time.Second),
+	})
+	return nil
+}
+
+// Challenge returns the user with a pending second login step.
+func (t *TwoFactor) Challenge(r *http.Request) (int, error) {
+	cookie, err := r.Cookie(ChallengeCookie)
+	if err != nil {
+		return 0, ErrNoSession
+	}
+	userID, _, err := t.jwt.ValidatePurposeToken(cookie.Value, purposeTwoFA)
+	if err != nil {
+		return 0, ErrNoSession
+	}
+	return userID, nil
+}
+
+// EndChallenge removes the pending login step.
+func (t *TwoFactor) EndChallenge(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ChallengeCookie,
+		Value:    "",
+		HttpOnly: true,
+		Path:     "/login",
+		MaxAge:   -1,
+	})
+}
+
+// newRecoveryCode returns a code such as "k3m9x-2qpvr": 50 random bits in
+// lowercase base32.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate recovery code: %w", err)
+	}
+	s := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return s[:5] + "-" + s[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(code, " ", ""), "-", ""))
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+	"github.com/ahsanfayaz52/diaryservice/internal/encryption"
+	"github.com/ahsanfayaz52/diaryservice/internal/totp"
+)
+
+func TestTwoFactorVerify(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	keyring, err := encryption.ParseKeyring("test:"+base64.StdEncoding.EncodeToString(key), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptionSvc, err := encryption.NewService(keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf := NewTwoFactor(conn, encryptionSvc, nil)
+
+	secret, err := tf.BeginEnrollment(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := totp.Step(time.Now())
+	code := func(step int64) string {
+		t.Helper()
+		c, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	recovery, err := tf.Enable(ctx, userID, code(now-1))
+	if err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+
+	// The step used to enable is spent
+	if err := tf.Verify(ctx, userID, code(now-1)); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("enrollment code: got %v, want ErrInvalidCode", err)
+	}
+
+	// Codes are accepted as apps display them
+	spaced := code(now)[:3] + " " + code(now)[3:]
+	if err := tf.Verify(ctx, userID, spaced); err != nil {
+		t.Fatalf("Verify(%q): %v", spaced, err)
+	}
+	if err := tf.Verify(ctx, userID, code(now)); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("reused code: got %v, want ErrInvalidCode", err)
+	}
+
+	// So are recovery codes, once each
+	spaced = strings.ReplaceAll(recovery[0], "-", " ")
+	if err := tf.Verify(ctx, userID, spaced); err != nil {
+		t.Fatalf("Verify(%q): %v", spaced, err)
+	}
+	if err := tf.Verify(ctx, userID, recovery[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("reused recovery code: got %v, want ErrInvalidCode", err)
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/mail"
+)
+
+const (
+	verifyTokenTTL = 48 * time.Hour
+
+	// A user can ask for a new verification email once a minute and at most
+	// five times a day.
+	resendInterval = time.Minute
+	resendWindow   = 24 * time.Hour
+	resendMax      = 5
+)
+
+// RateLimitError is returned when a verification email was sent too
+// recently. RetryAfter is how long to wait before asking again.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("too many verification emails, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// ErrAlreadyVerified is returned when resending to a verified address.
+var ErrAlreadyVerified = errors.New("email already verified")
+
+// EmailVerifier confirms that users own the address they registered with by
+// emailing them a one-time link.
+type EmailVerifier struct {
+	db      *sql.DB
+	tokens  *OneTimeTokens
+	mailer  mail.Mailer
+	baseURL string
+}
+
+func NewEmailVerifier(db *sql.DB, tokens *OneTimeTokens, mailer mail.Mailer, baseURL string) *EmailVerifier {
+	return &EmailVerifier{db: db, tokens: tokens, mailer: mailer, baseURL: baseURL}
+}
+
+// Verified reports whether the user has confirmed their email address.
+func (v *EmailVerifier) Verified(ctx context.Context, userID int) (bool, error) {
+	var verifiedAt sql.NullTime
+	err := v.db.QueryRowContext(ctx, "SELECT email_verified_at FROM users WHERE id = ?", userID).Scan(&verifiedAt)
+	if err != nil {
+		return false, fmt.Errorf("load verification status: %w", err)
+	}
+	return verifiedAt.Valid, nil
+}
+
+// Send emails the user a new verification link. Links sent earlier stop
+// working.
+func (v *EmailVerifier) Send(ctx context.Context, userID int, email string) error {
+	token, err := v.tokens.Issue(ctx, userID, PurposeEmailVerify, verifyTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	return v.mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Confirm your email address for AI Note Assistant",
+		Body: fmt.Sprintf("Welcome to AI Note Assistant!\n\n"+
+			"Please confirm your email address by opening this link within the next 48 hours:\n\n%s/verify-email?token=%s\n\n"+
+			"If you didn't create an account, you can ignore this email.\n",
+			v.baseURL, url.QueryEscape(token)),
+	})
+}
+
+// Resend sends a new verification link unless the user is already verified
+// or has asked too often, in which case it returns a *RateLimitError.
+func (v *EmailVerifier) Resend(ctx context.Context, userID int) error {
+	var (
+		email      string
+		verifiedAt sql.NullTime
+	)
+	err := v.db.QueryRowContext(ctx, "SELECT email, email_verified_at FROM users WHERE id = ?", userID).Scan(&email, &verifiedAt)
+	if err != nil {
+		return fmt.Errorf("load user: %w", err)
+	}
+	if verifiedAt.Valid {
+		return ErrAlreadyVerified
+	}
+
+	now := time.Now()
+	count, latest, err := v.tokens.IssuedSince(ctx, userID, PurposeEmailVerify, now.Add(-resendWindow))
+	if err != nil {
+		return err
+	}
+	if wait := latest.Add(resendInterval).Sub(now); count > 0 && wait > 0 {
+		return &RateLimitError{RetryAfter: wait}
+	}
+	if count >= resendMax {
+		return &RateLimitError{RetryAfter: resendWindow}
+	}
+
+	return v.Send(ctx, userID, email)
+}
+
+// Confirm uses up a verification link and marks the user's address as
+// verified. It returns the user the link was issued to.
+func (v *EmailVerifier) Confirm(ctx context.Context, token string) (int, error) {
+	userID, err := v.tokens.Consume(ctx, token, PurposeEmailVerify)
+	if err != nil {
+		return 0, err
+	}
+	return userID, v.MarkVerified(ctx, userID)
+}
+
+// MarkVerified records that the user proved they own their address, for
+// example by following any emailed link.
+func (v *EmailVerifier) MarkVerified(ctx context.Context, userID int) error {
+	_, err := v.db.ExecContext(ctx, "UPDATE users SET email_verified_at = ? WHERE id = ? AND email_verified_at IS NULL", time.Now().UTC(), userID)
+	if err != nil {
+		return fmt.Errorf("mark email verified: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Package blindindex turns note text into keyed digests that can be stored
+// and matched without revealing the words they came from.
+package blindindex
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+)
+
+// Version identifies the tokenizer and digest scheme. Notes indexed under an
+// older version are reindexed in the background.
+const Version = 1
+
+const (
+	minWordLen = 2
+	maxWordLen = 64
+	digestLen  = 16 // bytes of HMAC-SHA256 kept; stored as 32 hex characters
+)
+
+// Words splits text into lowercased runs of letters and digits, dropping
+// duplicates and words shorter than two characters. Overlong words are cut
+// to 64 characters.
+func Words(text string) []string {
+	seen := map[string]bool{}
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		runes := []rune(w)
+		if len(runes) < minWordLen {
+			continue
+		}
+		if len(runes) > maxWordLen {
+			w = string(runes[:maxWordLen])
+		}
+		if !seen[w] {
+			seen[w] = true
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// Index computes digests under one user's key, so the same word produces
+// unrelated digests for different users.
+type Index struct {
+	key []byte
+}
+
+func New(key []byte) *Index {
+	return &Index{key: key}
+}
+
+// Word returns the digest of a single normalized word.
+func (ix *Index) Word(word string) string {
+	return ix.digest("word:" + word)
+}
+
+// Tag returns the digest of a whole tag. Tags are matched case-insensitively
+// and in their own namespace, so tag "work" and the word "work" differ.
+func (ix *Index) Tag(tag string) string {
+	return ix.digest("tag:" + strings.ToLower(strings.TrimSpace(tag)))
+}
+
+// Name returns the digest of a person's name, such as the owner of a
+// meeting action item. Case, punctuation and spacing are ignored, so
+// "J. Smith" and "j smith" match. It returns "" for a name with no words.
+func (ix *Index) Name(name string) string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words = append(words, w)
+	}
+	if len(words) == 0 {
+		return ""
+	}
+	return ix.digest("name:" + strings.Join(words, " "))
+}
+
+// Query returns the digests a note must carry to match a search, one per
+// word of the search text.
+func (ix *Index) Query(search string) []string {
+	var digests []string
+	for _, w := range Words(search) {
+		digests = append(digests, ix.Word(w))
+	}
+	return digests
+}
+
+// Terms returns every digest to store for a note: the words of its title,
+// text and tags, and each tag as a whole.
+func (ix *Index) Terms(title, text string, tags []string) []string {
+	seen := map[string]bool{}
+	var digests []string
+	add := func(d string) {
+		if !seen[d] {
+			seen[d] = true
+			digests = append(digests, d)
+		}
+	}
+
+	for _, w := range Words(title + "\n" + text + "\n" + strings.Join(tags, " ")) {
+		add(ix.Word(w))
+	}
+	for _, t := range tags {
+		add(ix.Tag(t))
+	}
+	return digests
+}
+
+func (ix *Index) digest(term string) string {
+	mac := hmac.New(sha256.New, ix.key)
+	mac.Write([]byte(term))
+	return hex.EncodeToString(mac.Sum(nil)[:digestLen])
+}
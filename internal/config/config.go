@@ -1,12 +1,22 @@
 package config
 
 import (
+	"github.com/ahsanfayaz52/diaryservice/internal/ai"
+	"github.com/ahsanfayaz52/diaryservice/internal/db"
+	"github.com/ahsanfayaz52/diaryservice/internal/encryption"
+	"github.com/ahsanfayaz52/diaryservice/internal/mail"
+	"github.com/ahsanfayaz52/diaryservice/internal/oidc"
 	"github.com/ahsanfayaz52/diaryservice/internal/stripe"
+	"github.com/ahsanfayaz52/diaryservice/internal/transcribe"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
+	DBDriver   string // "mysql" or "sqlite"
+	DBPath     string // SQLite database file
 	DBUser     string
 	DBPassword string
 	DBHost     string
@@ -28,14 +38,87 @@ type Config struct {
 	StripeCancelURL      string
 
 	EncryptionKey string `yaml:"encryption_key"`
+	// Keyring as comma-separated id:base64key pairs, and the ID of the key
+	// used for new data. ENCRYPTION_KEY, if set, joins the keyring as "default".
+	EncryptionKeys        string
+	EncryptionActiveKeyID string
 
 	// Business Logic Limits
 	FreeNoteLimit   int
 	FreeMeetingMins int
+	// Notes a user can create before verifying their email address
+	UnverifiedNoteLimit int
+
+	// AI provider: "openai" (the default), "ollama" for Ollama or another
+	// OpenAI-compatible server at AIBaseURL, or "fake" to answer without a
+	// model. An empty AIModel uses the provider's default.
+	AIProvider       string
+	AIModel          string
+	AIBaseURL        string
+	AITemperature    float32
+	AITimeoutSeconds int
+
+	// Daily AI quotas for free users and subscribers: requests and OpenAI
+	// tokens per user per UTC day
+	AIFreeDailyRequests       int
+	AIFreeDailyTokens         int
+	AISubscriberDailyRequests int
+	AISubscriberDailyTokens   int
+
+	// Meeting audio transcription: "openai" (the default) for the Whisper
+	// API or another server at TranscribeBaseURL, "command" to run
+	// TranscribeCommand (such as whisper.cpp) with the audio file's path
+	// added, or "fake" to answer without transcribing
+	TranscribeProvider       string
+	TranscribeModel          string
+	TranscribeBaseURL        string
+	TranscribeCommand        string
+	TranscribeTimeoutSeconds int
+	// Largest recording a user can upload, in megabytes
+	MaxRecordingMB int
+
+	// Days a deleted note stays in the trash before it is purged
+	TrashRetentionDays int
+
+	// Sessions: access tokens are short-lived JWTs renewed from a rotating
+	// refresh token, which expires after SessionTTLDays.
+	AccessTokenTTLMinutes int
+	SessionTTLDays        int
+	// Take client IPs from X-Forwarded-For; only safe behind a proxy that sets it
+	TrustProxy bool
+
+	// Public URL of the app, used for links in emails
+	AppBaseURL string
+
+	// Mail: "smtp" sends through the SMTP server, "log" (the default) only
+	// logs messages and, if MailDir is set, writes them there as .eml files.
+	MailDriver   string
+	MailFrom     string
+	MailDir      string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+
+	// Single sign-on through an OpenID Connect provider, enabled when the
+	// issuer and client ID are set. Register APP_BASE_URL/login/sso/callback
+	// as the redirect URI with the provider.
+	OIDCIssuerURL    string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCProviderName string
 }
 
 func LoadConfig() *Config {
 	// Database configuration
+	dbDriver := os.Getenv("DB_DRIVER")
+	if dbDriver == "" {
+		dbDriver = db.DriverMySQL
+	}
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "data/diary.db"
+	}
 	dbUser := os.Getenv("DB_USER")
 	dbPassword := os.Getenv("DB_PASSWORD")
 	dbHost := os.Getenv("DB_HOST")
@@ -47,6 +130,18 @@ func LoadConfig() *Config {
 
 	// OpenAI configuration
 	aiKey := os.Getenv("OPENAI_KEY")
+	aiTemperature := float32(0.3) // default value
+	if v := os.Getenv("AI_TEMPERATURE"); v != "" {
+		if val, err := strconv.ParseFloat(v, 32); err == nil && val >= 0 && val <= 2 {
+			aiTemperature = float32(val)
+		}
+	}
+	aiTimeoutSeconds := 60 // default value
+	if v := os.Getenv("AI_TIMEOUT_SECONDS"); v != "" {
+		if val, err := strconv.Atoi(v); err == nil && val > 0 {
+			aiTimeoutSeconds = val
+		}
+	}
 
 	// Stripe configuration with test defaults
 	stripeSecret := os.Getenv("STRIPE_SECRET_KEY")
@@ -60,6 +155,8 @@ func LoadConfig() *Config {
 	stripeSuccess := os.Getenv("STRIPE_SUCCESS_URL")
 	stripeCancel := os.Getenv("STRIPE_CANCEL_URL")
 	encKey := os.Getenv("ENCRYPTION_KEY")
+	encKeys := os.Getenv("ENCRYPTION_KEYS")
+	encActiveKeyID := os.Getenv("ENCRYPTION_ACTIVE_KEY_ID")
 
 	freeNoteLimitStr := os.Getenv("FREE_NOTE_LIMIT")
 	freeNoteLimit := 10 // default value
@@ -77,7 +174,103 @@ func LoadConfig() *Config {
 		}
 	}
 
+	unverifiedNoteLimit := 3 // default value
+	if v := os.Getenv("UNVERIFIED_NOTE_LIMIT"); v != "" {
+		if val, err := strconv.Atoi(v); err == nil && val >= 0 {
+			unverifiedNoteLimit = val
+		}
+	}
+
+	aiFreeRequests := 20 // default value
+	if v := os.Getenv("AI_FREE_DAILY_REQUESTS"); v != "" {
+		if val, err := strconv.Atoi(v); err == nil && val > 0 {
+			aiFreeRequests = val
+		}
+	}
+
+	aiFreeTokens := 30000 // default value
+	if v := os.Getenv("AI_FREE_DAILY_TOKENS"); v != "" {
+		if val, err := strconv.Atoi(v); err == nil && val > 0 {
+			aiFreeTokens = val
+		}
+	}
+
+	aiSubscriberRequests := 300 // default value
+	if v := os.Getenv("AI_SUBSCRIBER_DAILY_REQUESTS"); v != "" {
+		if val, err := strconv.Atoi(v); err == nil && val > 0 {
+			aiSubscriberRequests = val
+		}
+	}
+
+	aiSubscriberTokens := 600000 // default value
+	if v := os.Getenv("AI_SUBSCRIBER_DAILY_TOKENS"); v != "" {
+		if val, err := strconv.Atoi(v); err == nil && val > 0 {
+			aiSubscriberTokens = val
+		}
+	}
+
+	transcribeTimeoutSeconds := 600 // default value
+	if v := os.Getenv("TRANSCRIBE_TIMEOUT_SECONDS"); v != "" {
+		if val, err := strconv.Atoi(v); err == nil && val > 0 {
+			transcribeTimeoutSeconds = val
+		}
+	}
+	maxRecordingMB := 25 // the Whisper API's upload limit
+	if v := os.Getenv("MAX_RECORDING_MB"); v != "" {
+		if val, err := strconv.Atoi(v); err == nil && val > 0 {
+			maxRecordingMB = val
+		}
+	}
+
+	trashRetentionDays := 30 // default value
+	if v := os.Getenv("TRASH_RETENTION_DAYS"); v != "" {
+		if val, err := strconv.Atoi(v); err == nil && val > 0 {
+			trashRetentionDays = val
+		}
+	}
+
+	accessTokenTTL := 15 // minutes
+	if v := os.Getenv("ACCESS_TOKEN_TTL_MINUTES"); v != "" {
+		if val, err := strconv.Atoi(v); err == nil && val > 0 {
+			accessTokenTTL = val
+		}
+	}
+
+	sessionTTLDays := 30 // default value
+	if v := os.Getenv("SESSION_TTL_DAYS"); v != "" {
+		if val, err := strconv.Atoi(v); err == nil && val > 0 {
+			sessionTTLDays = val
+		}
+	}
+
+	trustProxy, _ := strconv.ParseBool(os.Getenv("TRUST_PROXY"))
+
+	appBaseURL := strings.TrimSuffix(os.Getenv("APP_BASE_URL"), "/")
+	if appBaseURL == "" {
+		appBaseURL = "http://localhost:" + port
+		if port == "" {
+			appBaseURL = "http://localhost:8080"
+		}
+	}
+
+	mailDriver := os.Getenv("MAIL_DRIVER")
+	if mailDriver == "" {
+		mailDriver = mail.DriverLog
+	}
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "AI Note Assistant <no-reply@localhost>"
+	}
+	smtpPort := 587 // default value
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		if val, err := strconv.Atoi(v); err == nil && val > 0 {
+			smtpPort = val
+		}
+	}
+
 	return &Config{
+		DBDriver:   dbDriver,
+		DBPath:     dbPath,
 		DBUser:     dbUser,
 		DBPassword: dbPassword,
 		DBHost:     dbHost,
@@ -98,9 +291,53 @@ func LoadConfig() *Config {
 		StripeCancelURL:      stripeCancel,
 		EncryptionKey:        encKey,
 
+		EncryptionKeys:        encKeys,
+		EncryptionActiveKeyID: encActiveKeyID,
+
 		// Business Limits
 		FreeNoteLimit:   freeNoteLimit,    // Default free plan note limit
 		FreeMeetingMins: freeMeetingLimit, // Default free plan meeting minutes
+
+		UnverifiedNoteLimit: unverifiedNoteLimit,
+
+		AIProvider:       os.Getenv("AI_PROVIDER"),
+		AIModel:          os.Getenv("AI_MODEL"),
+		AIBaseURL:        os.Getenv("AI_BASE_URL"),
+		AITemperature:    aiTemperature,
+		AITimeoutSeconds: aiTimeoutSeconds,
+
+		AIFreeDailyRequests:       aiFreeRequests,
+		AIFreeDailyTokens:         aiFreeTokens,
+		AISubscriberDailyRequests: aiSubscriberRequests,
+		AISubscriberDailyTokens:   aiSubscriberTokens,
+
+		TranscribeProvider:       os.Getenv("TRANSCRIBE_PROVIDER"),
+		TranscribeModel:          os.Getenv("TRANSCRIBE_MODEL"),
+		TranscribeBaseURL:        os.Getenv("TRANSCRIBE_BASE_URL"),
+		TranscribeCommand:        os.Getenv("TRANSCRIBE_COMMAND"),
+		TranscribeTimeoutSeconds: transcribeTimeoutSeconds,
+		MaxRecordingMB:           maxRecordingMB,
+
+		TrashRetentionDays: trashRetentionDays,
+
+		AccessTokenTTLMinutes: accessTokenTTL,
+		SessionTTLDays:        sessionTTLDays,
+		TrustProxy:            trustProxy,
+
+		AppBaseURL: appBaseURL,
+
+		MailDriver:   mailDriver,
+		MailFrom:     mailFrom,
+		MailDir:      os.Getenv("MAIL_DIR"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     smtpPort,
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+
+		OIDCIssuerURL:    os.Getenv("OIDC_ISSUER_URL"),
+		OIDCClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCProviderName: os.Getenv("OIDC_PROVIDER_NAME"),
 	}
 }
 
@@ -117,3 +354,74 @@ func (c *Config) StripeConfig() stripe.Config {
 		FreeMeetingMins: c.FreeMeetingMins,
 	}
 }
+
+// MailConfig returns the mailer configuration
+func (c *Config) MailConfig() mail.Config {
+	return mail.Config{
+		Driver:   c.MailDriver,
+		From:     c.MailFrom,
+		Host:     c.SMTPHost,
+		Port:     c.SMTPPort,
+		Username: c.SMTPUsername,
+		Password: c.SMTPPassword,
+		Dir:      c.MailDir,
+	}
+}
+
+// OIDCConfig returns the single sign-on provider settings
+func (c *Config) OIDCConfig() oidc.Config {
+	return oidc.Config{
+		IssuerURL:    c.OIDCIssuerURL,
+		ClientID:     c.OIDCClientID,
+		ClientSecret: c.OIDCClientSecret,
+		RedirectURL:  c.AppBaseURL + "/login/sso/callback",
+		Name:         c.OIDCProviderName,
+	}
+}
+
+// Keyring builds the encryption keyring from the configured keys
+func (c *Config) Keyring() (*encryption.Keyring, error) {
+	return encryption.ParseKeyring(c.EncryptionKeys, c.EncryptionActiveKeyID, c.EncryptionKey)
+}
+
+// AIConfig returns the AI provider settings
+func (c *Config) AIConfig() ai.Config {
+	return ai.Config{
+		Provider:    c.AIProvider,
+		APIKey:      c.OpenAIKey,
+		BaseURL:     c.AIBaseURL,
+		Model:       c.AIModel,
+		Temperature: c.AITemperature,
+		Timeout:     time.Duration(c.AITimeoutSeconds) * time.Second,
+	}
+}
+
+// AIQuota returns the daily AI limits for free users and subscribers
+func (c *Config) AIQuota() (free, subscriber ai.Limits) {
+	return ai.Limits{Requests: c.AIFreeDailyRequests, Tokens: c.AIFreeDailyTokens},
+		ai.Limits{Requests: c.AISubscriberDailyRequests, Tokens: c.AISubscriberDailyTokens}
+}
+
+// TranscribeConfig returns the meeting transcription settings
+func (c *Config) TranscribeConfig() transcribe.Config {
+	return transcribe.Config{
+		Provider: c.TranscribeProvider,
+		APIKey:   c.OpenAIKey,
+		BaseURL:  c.TranscribeBaseURL,
+		Model:    c.TranscribeModel,
+		Command:  strings.Fields(c.TranscribeCommand),
+		Timeout:  time.Duration(c.TranscribeTimeoutSeconds) * time.Second,
+	}
+}
+
+// DBConfig returns the database connection settings
+func (c *Config) DBConfig() db.Config {
+	return db.Config{
+		Driver:   c.DBDriver,
+		User:     c.DBUser,
+		Password: c.DBPassword,
+		Host:     c.DBHost,
+		Name:     c.DBName,
+		Path:     c.DBPath,
+	}
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
+)
+
+// Config selects the storage backend and how to reach it.
+type Config struct {
+	Driver string // "mysql" (default) or "sqlite"
+
+	// MySQL connection settings
+	User     string
+	Password string
+	Host     string
+	Name     string
+
+	// SQLite database file
+	Path string
+}
+
+// Open connects to the configured database without touching the schema.
+func Open(cfg Config) (*sql.DB, error) {
+	switch cfg.Driver {
+	case DriverSQLite:
+		return openSQLite(cfg.Path)
+	case DriverMySQL, "":
+		return openMySQL(cfg.User, cfg.Password, cfg.Host, cfg.Name)
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q (expected %q or %q)", cfg.Driver, DriverMySQL, DriverSQLite)
+	}
+}
+
+// InitDB opens the configured database and applies any pending migrations.
+func InitDB(cfg Config) *sql.DB {
+	db, err := Open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+
+	migrator, err := NewMigrator(db, cfg.Driver)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	return db
+}
+
+// Execer is satisfied by *sql.DB and *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// EnsureUserLimits creates the user_limits row for a user if it is missing.
+// It replaces MySQL's ON DUPLICATE KEY UPDATE with SQL every backend accepts:
+// callers follow it with a plain UPDATE.
+func EnsureUserLimits(ctx context.Context, db Execer, userID int) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO user_limits (user_id)
+		SELECT ? FROM (SELECT 1 AS one) AS seed
+		WHERE NOT EXISTS (SELECT 1 FROM user_limits WHERE user_id = ?)`,
+		userID, userID)
+	return err
+}
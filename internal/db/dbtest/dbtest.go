@@ -0,0 +1,75 @@
+// Package dbtest provides migrated SQLite databases for tests.
+package dbtest
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/db"
+	"github.com/ahsanfayaz52/diaryservice/internal/encryption"
+	"github.com/ahsanfayaz52/diaryservice/internal/keystore"
+)
+
+// New returns an empty database with every migration applied, in a file
+// removed when the test ends.
+func New(t testing.TB) *sql.DB {
+	t.Helper()
+
+	conn, err := db.Open(db.Config{Driver: db.DriverSQLite, Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	migrator, err := db.NewMigrator(conn, db.DriverSQLite)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	return conn
+}
+
+// CreateUser adds a user with the given email and returns its ID. verified
+// sets whether the email address has been confirmed.
+func CreateUser(t testing.TB, conn *sql.DB, email string, verified bool) int {
+	t.Helper()
+
+	query := "INSERT INTO users (email, password) VALUES (?, 'x')"
+	if verified {
+		query = "INSERT INTO users (email, password, email_verified_at) VALUES (?, 'x', CURRENT_TIMESTAMP)"
+	}
+	res, err := conn.Exec(query, email)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatalf("read user id: %v", err)
+	}
+	return int(id)
+}
+
+// Keys returns a data key store for conn under a random master key.
+func Keys(t testing.TB, conn *sql.DB) *keystore.Store {
+	t.Helper()
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := encryption.ParseKeyring("test:"+base64.StdEncoding.EncodeToString(key), "", "")
+	if err != nil {
+		t.Fatalf("build keyring: %v", err)
+	}
+	master, err := encryption.NewService(keyring)
+	if err != nil {
+		t.Fatalf("create encryption service: %v", err)
+	}
+	return keystore.NewStore(conn, master)
+}
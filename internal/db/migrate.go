@@ -0,0 +1,403 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+const migrationLockName = "diaryservice_schema_migrations"
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one numbered schema change with its rollback.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a known migration has been applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	Dirty     bool
+	AppliedAt time.Time
+}
+
+type migrationLocker interface {
+	Lock(ctx context.Context) (unlock func(), err error)
+}
+
+// Migrator applies the embedded migrations for one driver and records them
+// in the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	locker     migrationLocker
+	// transactionalDDL is true when a failed migration rolls back cleanly,
+	// so it does not have to leave the schema marked dirty.
+	transactionalDDL bool
+}
+
+// NewMigrator loads the migrations embedded for the given driver.
+func NewMigrator(db *sql.DB, driver string) (*Migrator, error) {
+	m := &Migrator{db: db}
+	switch driver {
+	case DriverSQLite:
+		m.locker = &sqliteLocker{db: db, timeout: 2 * time.Minute, staleAfter: 15 * time.Minute}
+		m.transactionalDDL = true
+	case DriverMySQL, "":
+		driver = DriverMySQL
+		m.locker = &mysqlLocker{db: db, timeout: 2 * time.Minute}
+	default:
+		return nil, fmt.Errorf("unsupported driver %q", driver)
+	}
+
+	migrations, err := loadMigrations(path.Join("migrations", driver))
+	if err != nil {
+		return nil, err
+	}
+	m.migrations = migrations
+	return m, nil
+}
+
+func loadMigrations(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		match := migrationFileRe.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file name %q", e.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", e.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every migration that has not been applied yet.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func() error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkClean(applied); err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.run(ctx, mig, mig.Up, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the n most recently applied migrations.
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	if n < 1 {
+		return errors.New("number of migrations to roll back must be at least 1")
+	}
+
+	return m.locked(ctx, func() error {
+		applied, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkClean(applied); err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && n > 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("migration %d_%s cannot be rolled back: no down script", mig.Version, mig.Name)
+			}
+			if err := m.run(ctx, mig, mig.Down, false); err != nil {
+				return err
+			}
+			n--
+		}
+		return nil
+	})
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		st := MigrationStatus{Migration: mig}
+		if rec, ok := applied[mig.Version]; ok {
+			st.Applied = true
+			st.Dirty = rec.dirty
+			st.AppliedAt = rec.appliedAt
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// Force records the schema as being exactly at version without running any
+// SQL, clearing the dirty flag left behind by a failed migration. Use it
+// after repairing the database by hand.
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+
+	return m.locked(ctx, func() error {
+		tx, err := m.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version > ?", version); err != nil {
+			return fmt.Errorf("remove later versions: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE schema_migrations SET dirty = ?", false); err != nil {
+			return fmt.Errorf("clear dirty flag: %w", err)
+		}
+		for _, mig := range m.migrations {
+			if mig.Version > version {
+				break
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, dirty, applied_at)
+				SELECT ?, ?, ?, CURRENT_TIMESTAMP FROM (SELECT 1 AS one) AS seed
+				WHERE NOT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)`,
+				mig.Version, mig.Name, false, mig.Version)
+			if err != nil {
+				return fmt.Errorf("record version %d: %w", mig.Version, err)
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+func (m *Migrator) find(version int) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) locked(ctx context.Context, fn func() error) error {
+	unlock, err := m.locker.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+	return fn()
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		dirty BOOLEAN NOT NULL DEFAULT FALSE,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+type appliedMigration struct {
+	dirty     bool
+	appliedAt time.Time
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT version, dirty, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var rec appliedMigration
+		var appliedAt sql.NullTime
+		if err := rows.Scan(&version, &rec.dirty, &appliedAt); err != nil {
+			return nil, fmt.Errorf("scan schema_migrations: %w", err)
+		}
+		rec.appliedAt = appliedAt.Time
+		applied[version] = rec
+	}
+	return applied, rows.Err()
+}
+
+func checkClean(applied map[int]appliedMigration) error {
+	for version, rec := range applied {
+		if rec.dirty {
+			return fmt.Errorf("database is dirty at migration %d: repair it by hand, then run `migrate force <version>`", version)
+		}
+	}
+	return nil
+}
+
+// run executes one migration script. The version is marked dirty before any
+// statement runs so that a half-applied migration on a database without
+// transactional DDL is noticed instead of silently skipped.
+func (m *Migrator) run(ctx context.Context, mig Migration, script string, up bool) error {
+	direction := "down"
+	if up {
+		direction = "up"
+		_, err := m.db.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, dirty, applied_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)",
+			mig.Version, mig.Name, true)
+		if err != nil {
+			return fmt.Errorf("record migration %d: %w", mig.Version, err)
+		}
+	} else {
+		if _, err := m.db.ExecContext(ctx, "UPDATE schema_migrations SET dirty = ? WHERE version = ?", true, mig.Version); err != nil {
+			return fmt.Errorf("mark migration %d dirty: %w", mig.Version, err)
+		}
+	}
+
+	err := m.exec(ctx, mig, script, up)
+	if err != nil && m.transactionalDDL {
+		// Nothing from the script survived the rollback, so restore the
+		// bookkeeping to what it was before we started.
+		if up {
+			m.db.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", mig.Version)
+		} else {
+			m.db.ExecContext(ctx, "UPDATE schema_migrations SET dirty = ? WHERE version = ?", false, mig.Version)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+	return nil
+}
+
+func (m *Migrator) exec(ctx context.Context, mig Migration, script string, up bool) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "UPDATE schema_migrations SET dirty = ?, applied_at = CURRENT_TIMESTAMP WHERE version = ?", false, mig.Version)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", mig.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// splitStatements breaks a script into single statements on semicolons that
+// are outside quotes, dropping blank statements and "--" comment lines.
+func splitStatements(script string) []string {
+	var (
+		stmts   []string
+		current strings.Builder
+		quote   rune
+	)
+
+	flush := func() {
+		stmt := strings.TrimSpace(current.String())
+		current.Reset()
+		if stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+
+	for _, line := range strings.Split(script, "\n") {
+		if quote == 0 && strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		for _, r := range line {
+			switch {
+			case quote != 0:
+				if r == quote {
+					quote = 0
+				}
+			case r == '\'' || r == '"' || r == '`':
+				quote = r
+			case r == ';':
+				flush()
+				continue
+			}
+			current.WriteRune(r)
+		}
+		current.WriteRune('\n')
+	}
+	flush()
+	return stmts
+}
@@ -0,0 +1,17 @@
+CREATE TABLE note_revisions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    note_id INT NOT NULL,
+    user_id INT NOT NULL,
+    title TEXT,
+    content TEXT,
+    tags TEXT,
+    source VARCHAR(16) NOT NULL DEFAULT 'edit',
+    restored_from INT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_note_revisions_note (note_id, id),
+    FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
+
+-- Seed one revision per existing note so every note has a starting point.
+INSERT INTO note_revisions (note_id, user_id, title, content, tags, source, created_at)
+SELECT id, user_id, title, content, tags, 'create', updated_at FROM notes;
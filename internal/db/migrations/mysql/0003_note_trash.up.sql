@@ -0,0 +1,6 @@
+ALTER TABLE notes ADD COLUMN deleted_at DATETIME NULL;
+
+CREATE INDEX idx_notes_user_deleted ON notes (user_id, deleted_at);
+
+-- note_count was never decremented on delete; bring it back in line.
+UPDATE user_limits SET note_count = (SELECT COUNT(*) FROM notes WHERE notes.user_id = user_limits.user_id);
@@ -0,0 +1,5 @@
+-- Titles and tags that were already encrypted stay encrypted; rolling back
+-- leaves them unreadable to older releases.
+ALTER TABLE note_revisions DROP COLUMN meta_encrypted;
+
+ALTER TABLE notes DROP COLUMN meta_encrypted;
@@ -0,0 +1,5 @@
+-- Titles and tags are encrypted from now on. Existing rows keep their
+-- plaintext until the background job encrypts them and sets the flag.
+ALTER TABLE notes ADD COLUMN meta_encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE note_revisions ADD COLUMN meta_encrypted BOOLEAN NOT NULL DEFAULT FALSE;
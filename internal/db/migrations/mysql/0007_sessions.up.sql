@@ -0,0 +1,19 @@
+-- Server-side sessions. Only SHA-256 digests of refresh tokens are stored;
+-- previous_hash lets a just-rotated token be recognised when it is replayed.
+CREATE TABLE sessions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    refresh_hash CHAR(64) NOT NULL,
+    previous_hash CHAR(64) NULL,
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    last_seen_at DATETIME NOT NULL,
+    rotated_at DATETIME NULL,
+    expires_at DATETIME NOT NULL,
+    revoked_at DATETIME NULL,
+    UNIQUE KEY uq_sessions_refresh (refresh_hash),
+    INDEX idx_sessions_previous (previous_hash),
+    INDEX idx_sessions_user (user_id, revoked_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME NULL;
+
+-- Accounts created before verification existed keep working as before.
+UPDATE users SET email_verified_at = CURRENT_TIMESTAMP;
@@ -0,0 +1,22 @@
+-- TOTP secrets, encrypted under the master key. A row with enabled_at NULL
+-- is an enrollment the user has not confirmed yet. last_step is the time
+-- step of the last accepted code, so a code cannot be replayed.
+CREATE TABLE user_totp (
+    user_id INT PRIMARY KEY,
+    secret TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    enabled_at DATETIME NULL,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
+
+-- One-time codes for signing in without the authenticator. Only SHA-256
+-- digests are stored.
+CREATE TABLE recovery_codes (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at DATETIME NULL,
+    INDEX idx_recovery_codes_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
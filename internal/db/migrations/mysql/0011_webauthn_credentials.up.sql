@@ -0,0 +1,20 @@
+-- Passkeys and security keys registered for passwordless login.
+-- credential_id is base64url encoded; sign_count is the authenticator's
+-- signature counter from the last login, used to detect cloned keys.
+CREATE TABLE webauthn_credentials (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    credential_id VARCHAR(255) NOT NULL,
+    public_key BLOB NOT NULL,
+    attestation_type VARCHAR(32) NOT NULL DEFAULT '',
+    aaguid VARBINARY(16) NULL,
+    transports VARCHAR(255) NOT NULL DEFAULT '',
+    flags TINYINT UNSIGNED NOT NULL DEFAULT 0,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    name VARCHAR(100) NOT NULL,
+    created_at DATETIME NOT NULL,
+    last_used_at DATETIME NULL,
+    UNIQUE KEY idx_webauthn_credentials_credential (credential_id),
+    INDEX idx_webauthn_credentials_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
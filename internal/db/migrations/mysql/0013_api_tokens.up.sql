@@ -0,0 +1,18 @@
+-- Personal access tokens for scripts calling the API. Only a SHA-256 digest
+-- of each token is stored; prefix is its first characters, shown so users
+-- can tell tokens apart. scopes is a space-separated list.
+CREATE TABLE api_tokens (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL,
+    last_used_at DATETIME NULL,
+    expires_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    UNIQUE KEY idx_api_tokens_hash (token_hash),
+    INDEX idx_api_tokens_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
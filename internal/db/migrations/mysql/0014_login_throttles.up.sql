@@ -0,0 +1,14 @@
+-- Failed login attempts, counted per account (the lower-cased email
+-- address, whether or not such a user exists) and per client IP. Kept in
+-- the database so every replica enforces the same limits.
+CREATE TABLE login_throttles (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at DATETIME NOT NULL,
+    blocked_until DATETIME NULL,
+    locked_until DATETIME NULL,
+    UNIQUE KEY idx_login_throttles_subject (kind, subject),
+    INDEX idx_login_throttles_last_failure (last_failure_at)
+) ENGINE=InnoDB;
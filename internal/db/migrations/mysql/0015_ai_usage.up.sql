@@ -0,0 +1,11 @@
+-- AI requests and OpenAI tokens used by each user per UTC day, for the
+-- daily AI quotas. day is formatted as YYYY-MM-DD.
+CREATE TABLE ai_usage (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    day CHAR(10) NOT NULL,
+    requests INT NOT NULL DEFAULT 0,
+    tokens INT NOT NULL DEFAULT 0,
+    UNIQUE KEY idx_ai_usage_user_day (user_id, day),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
@@ -0,0 +1,49 @@
+-- Meeting summaries in structured form, so action items and decisions can
+-- be queried and the summary rendered again in other layouts. Text columns
+-- are encrypted with the owner's data key, lists as JSON arrays. note_id is
+-- NULL until the summary is inserted into a note and the note is saved.
+CREATE TABLE meetings (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    note_id INT NULL,
+    summary TEXT NOT NULL,
+    key_points TEXT NOT NULL,
+    participants TEXT NOT NULL,
+    follow_ups TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_meetings_user (user_id, created_at),
+    INDEX idx_meetings_note (note_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
+
+-- owner_hash is the blind index digest of the owner's name, for finding
+-- the items someone owns without storing the name in the clear.
+CREATE TABLE meeting_action_items (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    meeting_id INT NOT NULL,
+    user_id INT NOT NULL,
+    position INT NOT NULL,
+    task TEXT NOT NULL,
+    owner TEXT NOT NULL,
+    owner_hash CHAR(32) NULL,
+    deadline TEXT NOT NULL,
+    dependencies TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'open',
+    completed_at DATETIME NULL,
+    INDEX idx_meeting_action_items_meeting (meeting_id, position),
+    INDEX idx_meeting_action_items_user (user_id, status, owner_hash),
+    FOREIGN KEY (meeting_id) REFERENCES meetings(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
+
+CREATE TABLE meeting_decisions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    meeting_id INT NOT NULL,
+    user_id INT NOT NULL,
+    position INT NOT NULL,
+    description TEXT NOT NULL,
+    rationale TEXT NOT NULL,
+    alternatives TEXT NOT NULL,
+    INDEX idx_meeting_decisions_meeting (meeting_id, position),
+    FOREIGN KEY (meeting_id) REFERENCES meetings(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
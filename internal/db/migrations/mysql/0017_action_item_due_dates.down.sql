@@ -0,0 +1,3 @@
+DROP INDEX idx_meeting_action_items_due ON meeting_action_items;
+
+ALTER TABLE meeting_action_items DROP COLUMN due_date;
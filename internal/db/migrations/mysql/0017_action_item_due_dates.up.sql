@@ -0,0 +1,6 @@
+-- The day each action item is due, read from its deadline when the meeting
+-- is stored or set by the user on the tasks page. The deadline keeps the
+-- wording from the meeting.
+ALTER TABLE meeting_action_items ADD COLUMN due_date DATETIME NULL;
+
+CREATE INDEX idx_meeting_action_items_due ON meeting_action_items (user_id, status, due_date);
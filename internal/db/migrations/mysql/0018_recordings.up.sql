@@ -0,0 +1,31 @@
+-- Meeting audio recorded in the browser, uploaded in chunks while the
+-- meeting runs and transcribed once it ends. Chunks and the transcript (a
+-- JSON array of timestamped segments) are encrypted with the owner's data
+-- key. note_id is NULL until the transcript's note is saved.
+CREATE TABLE recordings (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    note_id INT NULL,
+    mime_type VARCHAR(100) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'uploading',
+    chunks INT NOT NULL DEFAULT 0,
+    size BIGINT NOT NULL DEFAULT 0,
+    transcript MEDIUMTEXT NULL,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    error TEXT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    transcribed_at DATETIME NULL,
+    INDEX idx_recordings_user (user_id, created_at),
+    INDEX idx_recordings_note (note_id),
+    INDEX idx_recordings_status (status, created_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
+
+CREATE TABLE recording_chunks (
+    recording_id INT NOT NULL,
+    seq INT NOT NULL,
+    data MEDIUMTEXT NOT NULL,
+    PRIMARY KEY (recording_id, seq),
+    FOREIGN KEY (recording_id) REFERENCES recordings(id) ON DELETE CASCADE
+) ENGINE=InnoDB;
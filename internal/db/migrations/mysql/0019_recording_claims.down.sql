@@ -0,0 +1 @@
+ALTER TABLE recordings DROP COLUMN claimed_at;
@@ -0,0 +1,7 @@
+-- WebAuthn challenges that have been answered, kept until they expire so
+-- that a ceremony cookie can't be replayed to answer one again.
+CREATE TABLE webauthn_challenges (
+    challenge VARCHAR(128) PRIMARY KEY,
+    expires_at DATETIME NOT NULL,
+    INDEX idx_webauthn_challenges_expires (expires_at)
+) ENGINE=InnoDB;
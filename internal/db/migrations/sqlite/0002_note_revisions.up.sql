@@ -0,0 +1,18 @@
+CREATE TABLE note_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    note_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    title TEXT,
+    content TEXT,
+    tags TEXT,
+    source VARCHAR(16) NOT NULL DEFAULT 'edit',
+    restored_from INTEGER NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_note_revisions_note ON note_revisions (note_id, id);
+
+-- Seed one revision per existing note so every note has a starting point.
+INSERT INTO note_revisions (note_id, user_id, title, content, tags, source, created_at)
+SELECT id, user_id, title, content, tags, 'create', updated_at FROM notes;
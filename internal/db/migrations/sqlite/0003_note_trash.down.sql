@@ -0,0 +1,5 @@
+DELETE FROM notes WHERE deleted_at IS NOT NULL;
+
+DROP INDEX idx_notes_user_deleted;
+
+ALTER TABLE notes DROP COLUMN deleted_at;
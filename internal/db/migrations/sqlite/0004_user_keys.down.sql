@@ -0,0 +1,4 @@
+-- Dropping the data keys makes every note already encrypted with them
+-- unreadable. Only roll back before the re-encryption job has moved any
+-- notes onto data keys.
+DROP TABLE IF EXISTS user_keys;
@@ -0,0 +1,7 @@
+-- One data key per user, wrapped (encrypted) by the master keyring.
+CREATE TABLE user_keys (
+    user_id INTEGER PRIMARY KEY,
+    wrapped_key TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
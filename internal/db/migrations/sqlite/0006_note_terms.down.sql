@@ -0,0 +1,3 @@
+ALTER TABLE notes DROP COLUMN index_version;
+
+DROP TABLE IF EXISTS note_terms;
@@ -0,0 +1,13 @@
+-- Blind index for search: keyed digests of the words and tags in each note.
+CREATE TABLE note_terms (
+    note_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    term_hash CHAR(32) NOT NULL,
+    PRIMARY KEY (note_id, term_hash),
+    FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_note_terms_user_term ON note_terms (user_id, term_hash);
+
+-- Notes start unindexed; the server indexes them in the background.
+ALTER TABLE notes ADD COLUMN index_version INT NOT NULL DEFAULT 0;
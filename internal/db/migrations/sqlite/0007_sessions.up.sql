@@ -0,0 +1,20 @@
+-- Server-side sessions. Only SHA-256 digests of refresh tokens are stored;
+-- previous_hash lets a just-rotated token be recognised when it is replayed.
+CREATE TABLE sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    refresh_hash CHAR(64) NOT NULL UNIQUE,
+    previous_hash CHAR(64) NULL,
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    last_seen_at DATETIME NOT NULL,
+    rotated_at DATETIME NULL,
+    expires_at DATETIME NOT NULL,
+    revoked_at DATETIME NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_sessions_previous ON sessions (previous_hash);
+
+CREATE INDEX idx_sessions_user ON sessions (user_id, revoked_at);
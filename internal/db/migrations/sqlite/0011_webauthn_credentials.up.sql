@@ -0,0 +1,21 @@
+-- Passkeys and security keys registered for passwordless login.
+-- credential_id is base64url encoded; sign_count is the authenticator's
+-- signature counter from the last login, used to detect cloned keys.
+CREATE TABLE webauthn_credentials (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    credential_id VARCHAR(255) NOT NULL,
+    public_key BLOB NOT NULL,
+    attestation_type VARCHAR(32) NOT NULL DEFAULT '',
+    aaguid BLOB NULL,
+    transports VARCHAR(255) NOT NULL DEFAULT '',
+    flags INTEGER NOT NULL DEFAULT 0,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    name VARCHAR(100) NOT NULL,
+    created_at DATETIME NOT NULL,
+    last_used_at DATETIME NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_webauthn_credentials_credential ON webauthn_credentials (credential_id);
+CREATE INDEX idx_webauthn_credentials_user ON webauthn_credentials (user_id);
@@ -0,0 +1,16 @@
+-- Accounts at external OpenID Connect providers linked to local users. A
+-- provider identifies its users by subject, which unlike the email address
+-- never changes.
+CREATE TABLE user_identities (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    last_login_at DATETIME NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_user_identities_subject ON user_identities (issuer, subject);
+CREATE INDEX idx_user_identities_user ON user_identities (user_id);
@@ -0,0 +1,19 @@
+-- Personal access tokens for scripts calling the API. Only a SHA-256 digest
+-- of each token is stored; prefix is its first characters, shown so users
+-- can tell tokens apart. scopes is a space-separated list.
+CREATE TABLE api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL,
+    last_used_at DATETIME NULL,
+    expires_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_api_tokens_hash ON api_tokens (token_hash);
+CREATE INDEX idx_api_tokens_user ON api_tokens (user_id);
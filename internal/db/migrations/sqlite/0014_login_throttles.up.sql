@@ -0,0 +1,15 @@
+-- Failed login attempts, counted per account (the lower-cased email
+-- address, whether or not such a user exists) and per client IP. Kept in
+-- the database so every replica enforces the same limits.
+CREATE TABLE login_throttles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind VARCHAR(16) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at DATETIME NOT NULL,
+    blocked_until DATETIME NULL,
+    locked_until DATETIME NULL
+);
+
+CREATE UNIQUE INDEX idx_login_throttles_subject ON login_throttles (kind, subject);
+CREATE INDEX idx_login_throttles_last_failure ON login_throttles (last_failure_at);
@@ -0,0 +1,12 @@
+-- AI requests and OpenAI tokens used by each user per UTC day, for the
+-- daily AI quotas. day is formatted as YYYY-MM-DD.
+CREATE TABLE ai_usage (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    day CHAR(10) NOT NULL,
+    requests INTEGER NOT NULL DEFAULT 0,
+    tokens INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_ai_usage_user_day ON ai_usage (user_id, day);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS meeting_decisions;
+DROP TABLE IF EXISTS meeting_action_items;
+DROP TABLE IF EXISTS meetings;
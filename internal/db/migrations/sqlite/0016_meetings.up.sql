@@ -0,0 +1,52 @@
+-- Meeting summaries in structured form, so action items and decisions can
+-- be queried and the summary rendered again in other layouts. Text columns
+-- are encrypted with the owner's data key, lists as JSON arrays. note_id is
+-- NULL until the summary is inserted into a note and the note is saved.
+CREATE TABLE meetings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    note_id INTEGER NULL,
+    summary TEXT NOT NULL,
+    key_points TEXT NOT NULL,
+    participants TEXT NOT NULL,
+    follow_ups TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_meetings_user ON meetings (user_id, created_at);
+CREATE INDEX idx_meetings_note ON meetings (note_id);
+
+-- owner_hash is the blind index digest of the owner's name, for finding
+-- the items someone owns without storing the name in the clear.
+CREATE TABLE meeting_action_items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    meeting_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    task TEXT NOT NULL,
+    owner TEXT NOT NULL,
+    owner_hash CHAR(32) NULL,
+    deadline TEXT NOT NULL,
+    dependencies TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'open',
+    completed_at DATETIME NULL,
+    FOREIGN KEY (meeting_id) REFERENCES meetings(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_meeting_action_items_meeting ON meeting_action_items (meeting_id, position);
+CREATE INDEX idx_meeting_action_items_user ON meeting_action_items (user_id, status, owner_hash);
+
+CREATE TABLE meeting_decisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    meeting_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    description TEXT NOT NULL,
+    rationale TEXT NOT NULL,
+    alternatives TEXT NOT NULL,
+    FOREIGN KEY (meeting_id) REFERENCES meetings(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_meeting_decisions_meeting ON meeting_decisions (meeting_id, position);
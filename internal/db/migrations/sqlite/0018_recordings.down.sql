@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS recording_chunks;
+DROP TABLE IF EXISTS recordings;
@@ -0,0 +1,32 @@
+-- Meeting audio recorded in the browser, uploaded in chunks while the
+-- meeting runs and transcribed once it ends. Chunks and the transcript (a
+-- JSON array of timestamped segments) are encrypted with the owner's data
+-- key. note_id is NULL until the transcript's note is saved.
+CREATE TABLE recordings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    note_id INTEGER NULL,
+    mime_type VARCHAR(100) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'uploading',
+    chunks INTEGER NOT NULL DEFAULT 0,
+    size INTEGER NOT NULL DEFAULT 0,
+    transcript TEXT NULL,
+    duration_ms INTEGER NOT NULL DEFAULT 0,
+    error TEXT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    transcribed_at DATETIME NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recordings_user ON recordings (user_id, created_at);
+CREATE INDEX idx_recordings_note ON recordings (note_id);
+CREATE INDEX idx_recordings_status ON recordings (status, created_at);
+
+CREATE TABLE recording_chunks (
+    recording_id INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    data TEXT NOT NULL,
+    PRIMARY KEY (recording_id, seq),
+    FOREIGN KEY (recording_id) REFERENCES recordings(id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+-- When a server took a recording to transcribe it, so that claims left by a
+-- server that stopped can be told apart from transcriptions still running.
+ALTER TABLE recordings ADD COLUMN claimed_at DATETIME NULL;
@@ -0,0 +1 @@
+ALTER TABLE recordings DROP COLUMN quota_day;
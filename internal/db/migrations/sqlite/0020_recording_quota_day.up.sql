@@ -0,0 +1,4 @@
+-- The ai_usage day a recording's transcription was counted against, so the
+-- request is given back to that day if transcription fails. Recordings from
+-- before this column use the day they were created.
+ALTER TABLE recordings ADD COLUMN quota_day CHAR(10) NULL;
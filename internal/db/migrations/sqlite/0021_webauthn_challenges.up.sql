@@ -0,0 +1,8 @@
+-- WebAuthn challenges that have been answered, kept until they expire so
+-- that a ceremony cookie can't be replayed to answer one again.
+CREATE TABLE webauthn_challenges (
+    challenge VARCHAR(128) PRIMARY KEY,
+    expires_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_webauthn_challenges_expires ON webauthn_challenges (expires_at);
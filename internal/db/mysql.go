@@ -1,70 +1,58 @@
-// db/db.go
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
-func InitDB(user, password, host, dbName string) *sql.DB {
+func openMySQL(user, password, host, dbName string) (*sql.DB, error) {
 	// Build DSN (Data Source Name)
 	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true", user, password, host, dbName)
 
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		log.Fatalf("Failed to connect to MySQL database: %v", err)
+		return nil, fmt.Errorf("connect to MySQL database: %w", err)
 	}
 
 	// Ping to verify connection
 	if err := db.Ping(); err != nil {
-		log.Fatalf("MySQL ping failed: %v", err)
+		db.Close()
+		return nil, fmt.Errorf("MySQL ping failed: %w", err)
 	}
 
-	createUsersTable := `CREATE TABLE IF NOT EXISTS users (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		email VARCHAR(255) UNIQUE NOT NULL,
-		password VARCHAR(255) NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		stripe_customer_id VARCHAR(255),
-		is_active BOOLEAN DEFAULT FALSE,
-		subscription_id VARCHAR(255),
-		plan_id VARCHAR(255),
-		current_period_end DATETIME
-	) ENGINE=InnoDB;`
-
-	createNotesTable := `CREATE TABLE IF NOT EXISTS notes (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		user_id INT NOT NULL,
-		title TEXT,
-		content TEXT,
-		tags TEXT,
-		is_pinned BOOLEAN DEFAULT FALSE,
-		is_starred BOOLEAN DEFAULT FALSE,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-	) ENGINE=InnoDB;`
+	return db, nil
+}
 
-	createUserLimitsTable := `CREATE TABLE IF NOT EXISTS user_limits (
-		user_id INT PRIMARY KEY,
-		note_count INT DEFAULT 0,
-		meeting_seconds_used INT DEFAULT 0,
-		last_meeting_start DATETIME,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-	) ENGINE=InnoDB;`
+// mysqlLocker serialises migrations across replicas with a named lock. The
+// lock belongs to a connection, so one is pinned until it is released.
+type mysqlLocker struct {
+	db      *sql.DB
+	timeout time.Duration
+}
 
-	if _, err := db.Exec(createUsersTable); err != nil {
-		log.Fatalf("Error creating users table: %v", err)
+func (l *mysqlLocker) Lock(ctx context.Context) (func(), error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if _, err := db.Exec(createNotesTable); err != nil {
-		log.Fatalf("Error creating notes table: %v", err)
+
+	var acquired sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, int(l.timeout.Seconds())).Scan(&acquired)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("acquire migration lock: %w", err)
 	}
-	if _, err := db.Exec(createUserLimitsTable); err != nil {
-		log.Fatalf("Error creating user_limits table: %v", err)
+	if !acquired.Valid || acquired.Int64 != 1 {
+		conn.Close()
+		return nil, fmt.Errorf("timed out after %s waiting for migration lock", l.timeout)
 	}
 
-	return db
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrationLockName)
+		conn.Close()
+	}, nil
 }
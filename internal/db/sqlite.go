@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openSQLite(path string) (*sql.DB, error) {
+	if path == "" {
+		path = "data/diary.db"
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("create SQLite directory: %w", err)
+		}
+	}
+
+	// Foreign keys are off by default in SQLite; immediate transactions avoid
+	// lock upgrade failures when two requests write at the same time.
+	dsn := "file:" + path + "?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open SQLite database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("SQLite ping failed: %w", err)
+	}
+
+	return db, nil
+}
+
+// sqliteLocker has no named locks to lean on, so it claims a row in a lock
+// table instead. A lock older than staleAfter is assumed to belong to a
+// process that died mid-migration and is taken over.
+type sqliteLocker struct {
+	db         *sql.DB
+	timeout    time.Duration
+	staleAfter time.Duration
+}
+
+func (l *sqliteLocker) Lock(ctx context.Context) (func(), error) {
+	_, err := l.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations_lock (
+		id INTEGER PRIMARY KEY,
+		locked_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("create migration lock table: %w", err)
+	}
+
+	deadline := time.Now().Add(l.timeout)
+	for {
+		_, err = l.db.ExecContext(ctx, "DELETE FROM schema_migrations_lock WHERE locked_at < ?", time.Now().UTC().Add(-l.staleAfter))
+		if err != nil {
+			return nil, fmt.Errorf("clear stale migration lock: %w", err)
+		}
+
+		res, err := l.db.ExecContext(ctx, `INSERT INTO schema_migrations_lock (id, locked_at)
+			SELECT 1, ? WHERE NOT EXISTS (SELECT 1 FROM schema_migrations_lock WHERE id = 1)`, time.Now().UTC())
+		if err != nil {
+			return nil, fmt.Errorf("acquire migration lock: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for migration lock", l.timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+
+	return func() {
+		l.db.ExecContext(context.Background(), "DELETE FROM schema_migrations_lock WHERE id = 1")
+	}, nil
+}
@@ -0,0 +1,151 @@
+// Package diff computes word-level differences between two texts.
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Kind says whether a span of text is shared, added or removed.
+type Kind string
+
+const (
+	Equal  Kind = "equal"
+	Insert Kind = "insert"
+	Delete Kind = "delete"
+)
+
+// Op is a run of consecutive tokens of the same kind.
+type Op struct {
+	Kind Kind
+	Text string
+}
+
+// Words diffs a against b one word at a time. Whitespace is kept as its own
+// token so the result reproduces both texts exactly.
+func Words(a, b string) []Op {
+	return merge(myers(tokenize(a), tokenize(b)))
+}
+
+// tokenize splits text into alternating runs of whitespace and non-whitespace.
+func tokenize(s string) []string {
+	var tokens []string
+	start := 0
+	inSpace := false
+	for i, r := range s {
+		space := unicode.IsSpace(r)
+		if i > start && space != inSpace {
+			tokens = append(tokens, s[start:i])
+			start = i
+		}
+		inSpace = space
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+type edit struct {
+	kind  Kind
+	token string
+}
+
+// myers implements Eugene Myers' O((N+M)D) shortest edit script algorithm.
+func myers(a, b []string) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	offset := max
+	v := make([]int, 2*max+1)
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		snapshot := make([]int, len(v))
+		copy(snapshot, v)
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, offset, d)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrack(a, b []string, trace [][]int, offset, d int) []edit {
+	x, y := len(a), len(b)
+	var edits []edit
+
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{Equal, a[x]})
+		}
+		if x == prevX {
+			y--
+			edits = append(edits, edit{Insert, b[y]})
+		} else {
+			x--
+			edits = append(edits, edit{Delete, a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		edits = append(edits, edit{Equal, a[x]})
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// merge joins neighbouring edits of the same kind into single ops.
+func merge(edits []edit) []Op {
+	var ops []Op
+	var text strings.Builder
+	var kind Kind
+
+	for _, e := range edits {
+		if e.kind != kind && text.Len() > 0 {
+			ops = append(ops, Op{Kind: kind, Text: text.String()})
+			text.Reset()
+		}
+		kind = e.kind
+		text.WriteString(e.token)
+	}
+	if text.Len() > 0 {
+		ops = append(ops, Op{Kind: kind, Text: text.String()})
+	}
+	return ops
+}
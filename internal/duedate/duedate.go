@@ -0,0 +1,181 @@
+// Package duedate reads due dates out of the free-form deadlines given in
+// meetings, such as "next Friday", "by March 3" or "in two weeks".
+package duedate
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	isoDate   = regexp.MustCompile(`\b(\d{4})[-/](\d{1,2})[-/](\d{1,2})\b`)
+	monthDay  = regexp.MustCompile(`\b(` + monthPattern + `)\.? (\d{1,2})(?:st|nd|rd|th)?(?:,? (\d{4}))?\b`)
+	dayMonth  = regexp.MustCompile(`\b(\d{1,2})(?:st|nd|rd|th)?(?: of)? (` + monthPattern + `)\b\.?(?:,? (\d{4}))?`)
+	inPeriod  = regexp.MustCompile(`\b(?:in|within) (\d+|` + numberPattern + `|a|an) (day|week|month)s?\b`)
+	weekday   = regexp.MustCompile(`\b(next |this )?(` + weekdayPattern + `)\b`)
+	endOfNext = regexp.MustCompile(`\bend of next (week|month)\b`)
+)
+
+const (
+	monthPattern   = `jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sep(?:t(?:ember)?)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?`
+	weekdayPattern = `mon(?:day)?|tue(?:s(?:day)?)?|wed(?:nesday)?|thu(?:rs(?:day)?)?|fri(?:day)?|sat(?:urday)?|sun(?:day)?`
+	numberPattern  = `one|two|three|four|five|six|seven|eight|nine|ten|eleven|twelve`
+)
+
+var numbers = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+	"seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12,
+}
+
+// Parse returns the day a deadline refers to, reading relative deadlines
+// as relative to ref, the day it was set. It reports false for deadlines it
+// can't place, such as "ASAP" or "Q3". The day is returned as midnight UTC.
+func Parse(deadline string, ref time.Time) (time.Time, bool) {
+	text := strings.Join(strings.Fields(strings.ToLower(deadline)), " ")
+	if text == "" {
+		return time.Time{}, false
+	}
+	y, m, d := ref.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+
+	if match := isoDate.FindStringSubmatch(text); match != nil {
+		year, _ := strconv.Atoi(match[1])
+		month, _ := strconv.Atoi(match[2])
+		day, _ := strconv.Atoi(match[3])
+		return date(year, time.Month(month), day)
+	}
+
+	if match := monthDay.FindStringSubmatch(text); match != nil {
+		day, _ := strconv.Atoi(match[2])
+		return inYear(today, monthOf(match[1]), day, match[3])
+	}
+	if match := dayMonth.FindStringSubmatch(text); match != nil {
+		day, _ := strconv.Atoi(match[1])
+		return inYear(today, monthOf(match[2]), day, match[3])
+	}
+
+	if match := inPeriod.FindStringSubmatch(text); match != nil {
+		n, ok := numbers[match[1]]
+		if !ok {
+			n, _ = strconv.Atoi(match[1])
+		}
+		switch match[2] {
+		case "day":
+			return today.AddDate(0, 0, n), true
+		case "week":
+			return today.AddDate(0, 0, 7*n), true
+		default:
+			return today.AddDate(0, n, 0), true
+		}
+	}
+
+	if match := endOfNext.FindStringSubmatch(text); match != nil {
+		if match[1] == "week" {
+			return endOfWeek(today).AddDate(0, 0, 7), true
+		}
+		return endOfMonth(today.AddDate(0, 0, 1-today.Day()).AddDate(0, 1, 0)), true
+	}
+	switch {
+	case strings.Contains(text, "next week"):
+		return endOfWeek(today).AddDate(0, 0, 7), true
+	case strings.Contains(text, "next month"):
+		return endOfMonth(today.AddDate(0, 0, 1-today.Day()).AddDate(0, 1, 0)), true
+	case strings.Contains(text, "end of week"), strings.Contains(text, "end of the week"),
+		strings.Contains(text, "this week"), hasWord(text, "eow"):
+		return endOfWeek(today), true
+	case strings.Contains(text, "end of month"), strings.Contains(text, "end of the month"),
+		strings.Contains(text, "this month"), hasWord(text, "eom"):
+		return endOfMonth(today), true
+	case hasWord(text, "tomorrow"):
+		return today.AddDate(0, 0, 1), true
+	}
+
+	if match := weekday.FindStringSubmatch(text); match != nil {
+		target := weekdayOf(match[2])
+		ahead := (int(target) - int(today.Weekday()) + 7) % 7
+		switch match[1] {
+		case "this ":
+			// This week's, even if that is today
+		case "next ":
+			// The one in the following week, counting weeks from Monday
+			ahead = (int(target)+6)%7 - (int(today.Weekday())+6)%7 + 7
+		default:
+			if ahead == 0 {
+				ahead = 7
+			}
+		}
+		return today.AddDate(0, 0, ahead), true
+	}
+
+	// Last, so that "Friday EOD" means Friday
+	if hasWord(text, "today") || hasWord(text, "tonight") || hasWord(text, "eod") || strings.Contains(text, "end of day") {
+		return today, true
+	}
+	return time.Time{}, false
+}
+
+// date validates a calendar date, rejecting overflows such as February 30.
+func date(year int, month time.Month, day int) (time.Time, bool) {
+	t := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if t.Year() != year || t.Month() != month || t.Day() != day {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// inYear returns month and day in the given year, or without one, the next
+// time that day comes round, counting today.
+func inYear(today time.Time, month time.Month, day int, year string) (time.Time, bool) {
+	if year != "" {
+		y, _ := strconv.Atoi(year)
+		return date(y, month, day)
+	}
+	t, ok := date(today.Year(), month, day)
+	if ok && t.Before(today) {
+		return date(today.Year()+1, month, day)
+	}
+	return t, ok
+}
+
+// endOfWeek returns the Friday of today's week, or today at the weekend.
+func endOfWeek(today time.Time) time.Time {
+	if today.Weekday() == time.Saturday || today.Weekday() == time.Sunday {
+		return today
+	}
+	return today.AddDate(0, 0, int(time.Friday-today.Weekday()))
+}
+
+func endOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC)
+}
+
+func monthOf(name string) time.Month {
+	for m := time.January; m <= time.December; m++ {
+		if strings.HasPrefix(strings.ToLower(m.String()), name[:3]) {
+			return m
+		}
+	}
+	return 0
+}
+
+func weekdayOf(name string) time.Weekday {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.HasPrefix(strings.ToLower(d.String()), name[:3]) {
+			return d
+		}
+	}
+	return 0
+}
+
+func hasWord(text, word string) bool {
+	for _, w := range strings.FieldsFunc(text, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z')
+	}) {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package duedate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	// Wednesday 17 December 2025, late in the day in a zone ahead of UTC
+	wednesday := time.Date(2025, 12, 17, 23, 30, 0, 0, time.FixedZone("UTC+9", 9*60*60))
+	saturday := time.Date(2025, 12, 20, 9, 0, 0, 0, time.UTC)
+	lastOfJanuary := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		deadline string
+		ref      time.Time
+		want     string // empty if Parse should report false
+	}{
+		{"2026-03-04", wednesday, "2026-03-04"},
+		{"by 2026/3/4", wednesday, "2026-03-04"},
+		{"2026-02-30", wednesday, ""},
+		{"2026-13-01", wednesday, ""},
+
+		{"by March 3", wednesday, "2026-03-03"},
+		{"Dec. 20th", wednesday, "2025-12-20"},
+		{"Dec 17", wednesday, "2025-12-17"},
+		{"Dec 16", wednesday, "2026-12-16"},
+		{"March 3, 2027", wednesday, "2027-03-03"},
+		{"Feb 29, 2028", wednesday, "2028-02-29"},
+		{"Feb 30", wednesday, ""},
+		{"Feb 29", wednesday, ""},
+		{"3rd of March", wednesday, "2026-03-03"},
+		{"20 December 2025", wednesday, "2025-12-20"},
+		{"30th of February", wednesday, ""},
+		{"31 April", wednesday, ""},
+
+		{"in 3 days", wednesday, "2025-12-20"},
+		{"within two weeks", wednesday, "2025-12-31"},
+		{"in a month", wednesday, "2026-01-17"},
+		{"in 2 months", wednesday, "2026-02-17"},
+
+		{"end of next week", wednesday, "2025-12-26"},
+		{"end of next month", wednesday, "2026-01-31"},
+		{"next week", wednesday, "2025-12-26"},
+		{"next month", wednesday, "2026-01-31"},
+		{"next month", lastOfJanuary, "2026-02-28"},
+		{"end of the week", wednesday, "2025-12-19"},
+		{"EOW", wednesday, "2025-12-19"},
+		{"this week", saturday, "2025-12-20"},
+		{"end of month", wednesday, "2025-12-31"},
+		{"EOM", lastOfJanuary, "2026-01-31"},
+		{"this month", wednesday, "2025-12-31"},
+		{"tomorrow", wednesday, "2025-12-18"},
+
+		{"Friday", wednesday, "2025-12-19"},
+		{"wed", wednesday, "2025-12-24"},
+		{"this Wednesday", wednesday, "2025-12-17"},
+		{"next Friday", wednesday, "2025-12-26"},
+		{"next Monday", wednesday, "2025-12-22"},
+		{"next Sunday", wednesday, "2025-12-28"},
+		{"next Monday", saturday, "2025-12-22"},
+		{"Friday EOD", wednesday, "2025-12-19"},
+
+		{"today", wednesday, "2025-12-17"},
+		{"by end of day", wednesday, "2025-12-17"},
+		{"EOD", wednesday, "2025-12-17"},
+
+		{"ASAP", wednesday, ""},
+		{"Q3", wednesday, ""},
+		{"  ", wednesday, ""},
+	}
+	for _, tt := range tests {
+		got, ok := Parse(tt.deadline, tt.ref)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("Parse(%q, %s) = %s, want false", tt.deadline, tt.ref.Format("Mon Jan 2"), got.Format(time.DateOnly))
+			}
+			continue
+		}
+		if !ok || got.Format(time.DateOnly) != tt.want || got.Location() != time.UTC || !got.Equal(got.Truncate(24*time.Hour)) {
+			t.Errorf("Parse(%q, %s) = %v, %t; want %s", tt.deadline, tt.ref.Format("Mon Jan 2"), got, ok, tt.want)
+		}
+	}
+}
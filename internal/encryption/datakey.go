@@ -0,0 +1,134 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// Cipher is the common interface of Service and DataKeyCipher.
+type Cipher interface {
+	Encrypt(plaintext string) (string, error)
+	Decrypt(ciphertext string) (string, error)
+	// NeedsUpgrade reports whether a stored value should be re-encrypted.
+	NeedsUpgrade(ciphertext string) bool
+}
+
+const dataKeySize = 32
+
+// GenerateDataKey returns a new random AES-256 data key.
+func GenerateDataKey() ([]byte, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapKey encrypts a data key with the active keyring key for storage.
+// Rotating the keyring only needs the wrapped keys rewritten, not the data
+// they protect.
+func (s *Service) WrapKey(dataKey []byte) (string, error) {
+	return s.Encrypt(string(dataKey))
+}
+
+// UnwrapKey recovers a data key stored by WrapKey.
+func (s *Service) UnwrapKey(wrapped string) ([]byte, error) {
+	key, err := s.Decrypt(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != dataKeySize {
+		return nil, errors.New("unwrapped data key has the wrong length")
+	}
+	return []byte(key), nil
+}
+
+// DataKeyCipher encrypts with a single user's data key.
+//
+// Values written before the user had a data key are encrypted with the
+// keyring; DataKeyCipher still reads those through the master Service and
+// reports them as needing an upgrade, so the re-encryption job can move them
+// over. Until it has, deleting the data key does not make them unreadable.
+type DataKeyCipher struct {
+	key    []byte
+	master *Service
+}
+
+// NewDataKeyCipher returns a cipher for dataKey that falls back to master for
+// values not yet encrypted with it.
+func NewDataKeyCipher(dataKey []byte, master *Service) (*DataKeyCipher, error) {
+	if len(dataKey) != dataKeySize {
+		return nil, errors.New("data key must be 32 bytes")
+	}
+	return &DataKeyCipher{key: dataKey, master: master}, nil
+}
+
+func (c *DataKeyCipher) Encrypt(plaintext string) (string, error) {
+	gcm, err := newGCM(c.key)
+	if err != nil {
+		return "", err
+	}
+
+	header := append(append(make([]byte, 0, headerLen), magic...), VersionDataKey)
+
+	out := make([]byte, headerLen+gcm.NonceSize(), headerLen+gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	copy(out, header)
+	nonce := out[headerLen:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	out = gcm.Seal(out, nonce, []byte(plaintext), header)
+	return base64.URLEncoding.EncodeToString(out), nil
+}
+
+func (c *DataKeyCipher) Decrypt(ciphertext string) (string, error) {
+	decoded, err := base64.URLEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if !isDataKeyCiphertext(decoded) {
+		return c.master.Decrypt(ciphertext)
+	}
+	// The data key header always parses, so unlike in Service.Decrypt a
+	// failure here is never retried as legacy.
+	return decryptGCM(c.key, decoded, headerLen)
+}
+
+// NeedsUpgrade reports whether the value is still encrypted with the keyring
+// rather than the data key.
+func (c *DataKeyCipher) NeedsUpgrade(ciphertext string) bool {
+	decoded, err := base64.URLEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return false
+	}
+	if isDataKeyCiphertext(decoded) {
+		return false
+	}
+	if c.master.isLegacyCFB(decoded) {
+		return len(decoded) >= aes.BlockSize
+	}
+	if !bytes.HasPrefix(decoded, magic) {
+		return false
+	}
+	_, _, _, err = parseHeader(decoded)
+	return err == nil
+}
+
+func isDataKeyCiphertext(decoded []byte) bool {
+	return len(decoded) >= headerLen && bytes.HasPrefix(decoded, magic) && decoded[len(magic)] == VersionDataKey
+}
+
+// DeriveKey returns a key for another purpose, such as a blind index,
+// derived from the data key so that it is shredded along with it.
+func (c *DataKeyCipher) DeriveKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
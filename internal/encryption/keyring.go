@@ -0,0 +1,127 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LegacyKeyID names the key used for ciphertexts written before key IDs
+// were embedded. ENCRYPTION_KEY is registered under this ID.
+const LegacyKeyID = "default"
+
+var keyIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Keyring holds every key that may still be needed to decrypt stored data
+// and says which one new data is encrypted with.
+type Keyring struct {
+	keys   map[string][]byte
+	active string
+}
+
+// NewKeyring returns an empty keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: map[string][]byte{}}
+}
+
+// Add registers a base64-encoded 32-byte AES-256 key under id.
+func (k *Keyring) Add(id, encodedKey string) error {
+	if !keyIDRe.MatchString(id) {
+		return fmt.Errorf("invalid key ID %q: use 1-32 letters, digits, '-' or '_'", id)
+	}
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("duplicate key ID %q", id)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if err != nil {
+		return fmt.Errorf("key %q: %w", id, err)
+	}
+	if len(key) != 32 { // AES-256 requires 32-byte key
+		return fmt.Errorf("key %q must be 32 bytes when decoded", id)
+	}
+	k.keys[id] = key
+	return nil
+}
+
+// SetActive chooses the key that new ciphertexts are written with.
+func (k *Keyring) SetActive(id string) error {
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("active key %q is not in the keyring", id)
+	}
+	k.active = id
+	return nil
+}
+
+// ActiveID returns the ID of the key used for encryption.
+func (k *Keyring) ActiveID() string {
+	return k.active
+}
+
+// IDs returns the registered key IDs in sorted order.
+func (k *Keyring) IDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (k *Keyring) key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// ParseKeyring builds a keyring from configuration.
+//
+// keys is a comma-separated list of id:base64key pairs (ENCRYPTION_KEYS).
+// legacyKey is the single ENCRYPTION_KEY from before keyrings existed; if set
+// it is added under LegacyKeyID. activeID picks the encryption key and may be
+// left empty when the keyring holds exactly one key.
+func ParseKeyring(keys, activeID, legacyKey string) (*Keyring, error) {
+	k := NewKeyring()
+
+	for _, entry := range strings.Split(keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid keyring entry %q: expected id:base64key", entry)
+		}
+		if err := k.Add(strings.TrimSpace(id), encoded); err != nil {
+			return nil, err
+		}
+	}
+
+	if legacyKey != "" {
+		if _, ok := k.keys[LegacyKeyID]; ok {
+			return nil, fmt.Errorf("key ID %q is set by both ENCRYPTION_KEY and ENCRYPTION_KEYS", LegacyKeyID)
+		}
+		if err := k.Add(LegacyKeyID, legacyKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(k.keys) == 0 {
+		return nil, errors.New("no encryption keys configured")
+	}
+
+	if activeID == "" {
+		if len(k.keys) != 1 {
+			return nil, errors.New("several encryption keys configured: set ENCRYPTION_ACTIVE_KEY_ID")
+		}
+		activeID = k.IDs()[0]
+	}
+	if err := k.SetActive(activeID); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
@@ -1,60 +1,267 @@
 package encryption
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"sync/atomic"
+)
+
+// Ciphertexts written by this package start with a fixed magic value and a
+// format version byte:
+//
+//	magic (3 bytes) | version (1 byte) | payload
+//
+// The magic is three bytes so it always encodes to the same four base64
+// characters. Values without it are legacy AES-CFB ciphertexts, which are
+// still readable but never written.
+var magic = []byte{0xD1, 0xA5, 0xE0}
+
+const (
+	// VersionGCM is AES-256-GCM under the legacy key:
+	// nonce (12 bytes) | sealed data and tag.
+	VersionGCM byte = 1
+	// VersionGCMKeyID is AES-256-GCM with the key named in the header:
+	// key ID length (1 byte) | key ID | nonce (12 bytes) | sealed data and tag.
+	VersionGCMKeyID byte = 2
+	// VersionDataKey is AES-256-GCM under a per-user data key rather than a
+	// keyring key: nonce (12 bytes) | sealed data and tag. See DataKeyCipher.
+	VersionDataKey byte = 3
+
+	currentVersion = VersionGCMKeyID
+	headerLen      = 4
+)
+
+// In every GCM version the whole header, including any key ID, is
+// authenticated as additional data.
+
+var (
+	ErrCiphertextTooShort = errors.New("ciphertext too short")
+	ErrUnsupportedVersion = errors.New("unsupported ciphertext version")
+	ErrUnknownKey         = errors.New("unknown encryption key")
+	// ErrDataKeyRequired is returned by Service.Decrypt for values written by
+	// a DataKeyCipher.
+	ErrDataKeyRequired = errors.New("ciphertext is encrypted with a user data key")
+	// ErrAuthentication means the ciphertext was modified or encrypted
+	// under a different key.
+	ErrAuthentication = errors.New("ciphertext failed authentication")
+	// ErrLegacyDisabled is returned for legacy AES-CFB values once
+	// DisableLegacyCFB has been called.
+	ErrLegacyDisabled = errors.New("legacy AES-CFB ciphertexts are no longer read")
 )
 
 type Service struct {
-	key []byte
+	keyring     *Keyring
+	cfbDisabled atomic.Bool
+}
+
+func NewService(keyring *Keyring) (*Service, error) {
+	if keyring == nil || keyring.ActiveID() == "" {
+		return nil, errors.New("keyring has no active key")
+	}
+	return &Service{keyring: keyring}, nil
 }
 
-func NewService(encryptionKey string) (*Service, error) {
-	key, err := base64.StdEncoding.DecodeString(encryptionKey)
+// ActiveKeyID returns the ID of the key new ciphertexts are written with.
+func (s *Service) ActiveKeyID() string {
+	return s.keyring.ActiveID()
+}
+
+func (s *Service) Encrypt(plaintext string) (string, error) {
+	keyID := s.keyring.ActiveID()
+	key, err := s.keyring.key(keyID)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	if len(key) != 32 { // AES-256 requires 32-byte key
-		return nil, errors.New("encryption key must be 32 bytes when decoded")
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
 	}
-	return &Service{key: key}, nil
+
+	header := make([]byte, 0, headerLen+1+len(keyID))
+	header = append(header, magic...)
+	header = append(header, currentVersion, byte(len(keyID)))
+	header = append(header, keyID...)
+
+	out := make([]byte, len(header)+gcm.NonceSize(), len(header)+gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	copy(out, header)
+	nonce := out[len(header):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	out = gcm.Seal(out, nonce, []byte(plaintext), header)
+	return base64.URLEncoding.EncodeToString(out), nil
 }
 
-func (s *Service) Encrypt(plaintext string) (string, error) {
-	block, err := aes.NewCipher(s.key)
+// Decrypt reads any format this package has written. A legacy ciphertext
+// starts with a random IV, which begins with the magic about once in 2^24
+// values, so a magic-prefixed value whose header doesn't parse is read as
+// legacy. One that parses is never retried as legacy: an unauthenticated
+// read would turn a tampered GCM value into garbage instead of an error.
+func (s *Service) Decrypt(ciphertext string) (string, error) {
+	decoded, err := base64.URLEncoding.DecodeString(ciphertext)
 	if err != nil {
 		return "", err
 	}
+	if s.isLegacyCFB(decoded) {
+		return s.decryptLegacy(decoded)
+	}
+	return s.decryptGCM(decoded)
+}
+
+// DisableLegacyCFB stops Decrypt from reading the legacy AES-CFB format. The
+// re-encryption job calls it once no such values remain.
+func (s *Service) DisableLegacyCFB() {
+	s.cfbDisabled.Store(true)
+}
+
+// isLegacyCFB reports whether decoded is to be read as legacy AES-CFB: it
+// lacks the magic, or has it but no header that parses.
+func (s *Service) isLegacyCFB(decoded []byte) bool {
+	if s.cfbDisabled.Load() {
+		return false
+	}
+	if !bytes.HasPrefix(decoded, magic) {
+		return true
+	}
+	_, _, _, err := parseHeader(decoded)
+	return err != nil
+}
 
-	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+func (s *Service) decryptGCM(decoded []byte) (string, error) {
+	if !bytes.HasPrefix(decoded, magic) {
+		return "", ErrLegacyDisabled
+	}
+	version, keyID, headerEnd, err := parseHeader(decoded)
+	if err != nil {
 		return "", err
 	}
+	if version == VersionDataKey {
+		return "", ErrDataKeyRequired
+	}
+	key, err := s.keyring.key(keyID)
+	if err != nil {
+		return "", err
+	}
+	return decryptGCM(key, decoded, headerEnd)
+}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(ciphertext[aes.BlockSize:], []byte(plaintext))
+// decryptLegacy reads a value with the legacy key, which is only present
+// when ENCRYPTION_KEY is configured.
+func (s *Service) decryptLegacy(decoded []byte) (string, error) {
+	key, err := s.keyring.key(LegacyKeyID)
+	if err != nil {
+		return "", err
+	}
+	return decryptLegacy(key, decoded)
+}
 
-	return base64.URLEncoding.EncodeToString(ciphertext), nil
+// KeyID reports which key a stored ciphertext was encrypted with.
+func (s *Service) KeyID(ciphertext string) (string, error) {
+	decoded, err := base64.URLEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if s.isLegacyCFB(decoded) {
+		return LegacyKeyID, nil
+	}
+	if !bytes.HasPrefix(decoded, magic) {
+		return "", ErrLegacyDisabled
+	}
+	_, keyID, _, err := parseHeader(decoded)
+	return keyID, err
 }
 
-func (s *Service) Decrypt(ciphertext string) (string, error) {
+// NeedsUpgrade reports whether a stored ciphertext is in an older format or
+// under a key other than the active one, and should be re-encrypted. Values
+// that cannot be parsed at all report false; Decrypt will surface their error.
+func (s *Service) NeedsUpgrade(ciphertext string) bool {
 	decoded, err := base64.URLEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return false
+	}
+	if s.isLegacyCFB(decoded) {
+		return len(decoded) >= aes.BlockSize
+	}
+	if !bytes.HasPrefix(decoded, magic) {
+		return false
+	}
+	version, keyID, _, err := parseHeader(decoded)
+	if err != nil || version == VersionDataKey {
+		return false
+	}
+	return version != currentVersion || keyID != s.keyring.ActiveID()
+}
+
+// parseHeader returns the format version, the key ID and the offset at which
+// the nonce starts. Data key ciphertexts have no key ID.
+func parseHeader(decoded []byte) (version byte, keyID string, headerEnd int, err error) {
+	if len(decoded) < headerLen {
+		return 0, "", 0, ErrCiphertextTooShort
+	}
+
+	version = decoded[len(magic)]
+	switch version {
+	case VersionGCM:
+		return version, LegacyKeyID, headerLen, nil
+	case VersionDataKey:
+		return version, "", headerLen, nil
+	case VersionGCMKeyID:
+		if len(decoded) < headerLen+1 {
+			return 0, "", 0, ErrCiphertextTooShort
+		}
+		idLen := int(decoded[headerLen])
+		headerEnd = headerLen + 1 + idLen
+		if len(decoded) < headerEnd {
+			return 0, "", 0, ErrCiphertextTooShort
+		}
+		return version, string(decoded[headerLen+1 : headerEnd]), headerEnd, nil
+	default:
+		return 0, "", 0, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func decryptGCM(key, decoded []byte, headerEnd int) (string, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return "", err
 	}
+	if len(decoded) < headerEnd+gcm.NonceSize()+gcm.Overhead() {
+		return "", ErrCiphertextTooShort
+	}
+
+	header := decoded[:headerEnd]
+	nonce := decoded[headerEnd : headerEnd+gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, decoded[headerEnd+gcm.NonceSize():], header)
+	if err != nil {
+		return "", ErrAuthentication
+	}
+	return string(plaintext), nil
+}
 
-	block, err := aes.NewCipher(s.key)
+// decryptLegacy reads the original unauthenticated format: IV | AES-CFB data.
+func decryptLegacy(key, decoded []byte) (string, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
 
 	if len(decoded) < aes.BlockSize {
-		return "", errors.New("ciphertext too short")
+		return "", ErrCiphertextTooShort
 	}
 
 	iv := decoded[:aes.BlockSize]
@@ -0,0 +1,142 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newService(t *testing.T, keys, activeID string, legacyKey []byte) *Service {
+	t.Helper()
+	var legacy string
+	if legacyKey != nil {
+		legacy = base64.StdEncoding.EncodeToString(legacyKey)
+	}
+	keyring, err := ParseKeyring(keys, activeID, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+// encryptLegacy writes the original AES-CFB format with the given IV.
+func encryptLegacy(t *testing.T, key, iv []byte, plaintext string) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := append(append([]byte(nil), iv...), plaintext...)
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(out[aes.BlockSize:], out[aes.BlockSize:])
+	return base64.URLEncoding.EncodeToString(out)
+}
+
+func TestDecryptRoundTrip(t *testing.T) {
+	legacyKey := newKey(t)
+	svc := newService(t, "k1:"+base64.StdEncoding.EncodeToString(newKey(t)), "k1", legacyKey)
+
+	sealed, err := svc.Encrypt("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := svc.Decrypt(sealed); err != nil || got != "hello" {
+		t.Errorf("Decrypt = %q, %v", got, err)
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	rand.Read(iv)
+	iv[0] = 0 // anything but the magic
+	if got, err := svc.Decrypt(encryptLegacy(t, legacyKey, iv, "old")); err != nil || got != "old" {
+		t.Errorf("legacy Decrypt = %q, %v", got, err)
+	}
+}
+
+func TestDecryptLegacyWithMagicIV(t *testing.T) {
+	legacyKey := newKey(t)
+	svc := newService(t, "k1:"+base64.StdEncoding.EncodeToString(newKey(t)), "k1", legacyKey)
+	dataKey, err := NewDataKeyCipher(newKey(t), svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An IV that starts with the magic but no known version has no header
+	// that parses, so it can only be legacy
+	iv := make([]byte, aes.BlockSize)
+	rand.Read(iv)
+	copy(iv, magic)
+	iv[len(magic)] = 0x7F
+	sealed := encryptLegacy(t, legacyKey, iv, "a legacy note")
+
+	for name, c := range map[string]Cipher{"service": svc, "data key": dataKey} {
+		if got, err := c.Decrypt(sealed); err != nil || got != "a legacy note" {
+			t.Errorf("%s: Decrypt = %q, %v", name, got, err)
+		}
+		if !c.NeedsUpgrade(sealed) {
+			t.Errorf("%s: NeedsUpgrade = false", name)
+		}
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	for _, legacyKey := range [][]byte{nil, newKey(t)} {
+		svc := newService(t, "k1:"+base64.StdEncoding.EncodeToString(newKey(t)), "k1", legacyKey)
+		dataKey, err := NewDataKeyCipher(newKey(t), svc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, c := range map[string]Cipher{"service": svc, "data key": dataKey} {
+			sealed, err := c.Encrypt("hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, _ := base64.URLEncoding.DecodeString(sealed)
+			decoded[len(decoded)-1] ^= 1
+			// With the legacy key set, this must not be read as AES-CFB
+			// instead, which would return garbage rather than an error
+			if got, err := c.Decrypt(base64.URLEncoding.EncodeToString(decoded)); !errors.Is(err, ErrAuthentication) {
+				t.Errorf("%s, legacy key %t: got %q, %v, want ErrAuthentication", name, legacyKey != nil, got, err)
+			}
+		}
+	}
+}
+
+func TestDisableLegacyCFB(t *testing.T) {
+	legacyKey := newKey(t)
+	svc := newService(t, "k1:"+base64.StdEncoding.EncodeToString(newKey(t)), "k1", legacyKey)
+	iv := make([]byte, aes.BlockSize)
+	rand.Read(iv)
+	iv[0] = 0
+	legacy := encryptLegacy(t, legacyKey, iv, "old")
+	current, err := svc.Encrypt("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc.DisableLegacyCFB()
+	if _, err := svc.Decrypt(legacy); !errors.Is(err, ErrLegacyDisabled) {
+		t.Errorf("legacy Decrypt: got %v, want ErrLegacyDisabled", err)
+	}
+	if svc.NeedsUpgrade(legacy) {
+		t.Error("legacy NeedsUpgrade = true")
+	}
+	if got, err := svc.Decrypt(current); err != nil || got != "new" {
+		t.Errorf("Decrypt = %q, %v", got, err)
+	}
+}
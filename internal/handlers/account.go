@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/keystore"
+	"github.com/ahsanfayaz52/diaryservice/internal/stripe"
+)
+
+// DeleteAccountHandler deletes the user's account after they re-enter
+// their password, and a second-factor code if 2FA is on. A paid
+// subscription is cancelled first. The data key is shredded before the
+// rows are deleted, so anything the deletion leaves behind is unreadable.
+func DeleteAccountHandler(db *sql.DB, stripeSvc *stripe.Service, twoFactor *auth.TwoFactor, keys *keystore.Store, sessions *auth.Sessions, throttle *auth.LoginThrottle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		enabled, err := twoFactor.Enabled(r.Context(), userID)
+		if err != nil {
+			log.Println("Two-factor status error:", err)
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+		if !reauthenticate(w, r, db, twoFactor, sessions, throttle, userID, enabled) {
+			return
+		}
+
+		var subscriptionID sql.NullString
+		if err := db.QueryRowContext(r.Context(), "SELECT subscription_id FROM users WHERE id = ?", userID).Scan(&subscriptionID); err != nil {
+			log.Println("Load subscription error:", err)
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+		if subscriptionID.String != "" {
+			if err := stripeSvc.CancelSubscription(subscriptionID.String); err != nil {
+				log.Println("Cancel subscription error:", err)
+				renderSecurityPage(w, r, twoFactor, userID, http.StatusBadGateway,
+					"We couldn't cancel your subscription, so your account was not deleted. Please try again.")
+				return
+			}
+		}
+
+		if err := keys.Shred(r.Context(), userID); err != nil {
+			log.Println("Shred data key error:", err)
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+		// Everything else the user owns goes with the row
+		if _, err := db.ExecContext(r.Context(), "DELETE FROM users WHERE id = ?", userID); err != nil {
+			log.Println("Delete account error:", err)
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+
+		if err := sessions.End(w, r); err != nil {
+			log.Println("Session end error:", err)
+		}
+		http.Redirect(w, r, "/login?deleted=1", http.StatusSeeOther)
+	}
+}
@@ -1,13 +1,22 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/ahsanfayaz52/diaryservice/internal/config"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
-	openai "github.com/sashabaranov/go-openai"
+	"github.com/ahsanfayaz52/diaryservice/internal/ai"
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
+	"github.com/ahsanfayaz52/diaryservice/internal/stripe"
 )
 
 type AIRequest struct {
@@ -25,6 +34,9 @@ type MeetingSummaryRequest struct {
 }
 
 type MeetingSummaryResponse struct {
+	// MeetingID identifies the stored summary, to send with the note form
+	// as meeting_ids when it is inserted into the note
+	MeetingID    int          `json:"MeetingID,omitempty"`
 	Summary      string       `json:"Summary"`
 	KeyPoints    []string     `json:"KeyPoints"`
 	ActionItems  []ActionItem `json:"ActionItems"`
@@ -33,6 +45,17 @@ type MeetingSummaryResponse struct {
 	FollowUps    []FollowUp   `json:"FollowUps"`
 }
 
+// meetingSummaryReply is the JSON the model is asked for, with the
+// snake_case keys of the prompt.
+type meetingSummaryReply struct {
+	Summary      string       `json:"summary"`
+	KeyPoints    []string     `json:"key_points"`
+	ActionItems  []ActionItem `json:"action_items"`
+	Participants []string     `json:"participants"`
+	Decisions    []Decision   `json:"decisions"`
+	FollowUps    []FollowUp   `json:"follow_ups"`
+}
+
 type ActionItem struct {
 	Task         string   `json:"Task"`
 	Owner        string   `json:"Owner"`
@@ -52,27 +75,64 @@ type FollowUp struct {
 	Timeline    string `json:"Timeline"`
 }
 
-func AIProcessHandler(w http.ResponseWriter, r *http.Request) {
-	cfg := config.LoadConfig()
+func AIProcessHandler(db *sql.DB, stripeSvc *stripe.Service, quota *ai.Quota, provider ai.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
 
-	// Parse request
-	var req AIRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
+		// Parse request
+		var req AIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
 
-	if req.Text == "" {
-		http.Error(w, "Text is required", http.StatusBadRequest)
-		return
-	}
+		if req.Text == "" {
+			http.Error(w, "Text is required", http.StatusBadRequest)
+			return
+		}
+
+		prompt, ok := processPrompt(req.Action, req.Text)
+		if !ok {
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+
+		quotaDay, ok := reserveAI(w, r, db, stripeSvc, quota, userID)
+		if !ok {
+			return
+		}
+
+		resp, err := provider.Chat(r.Context(), []ai.Message{{Role: ai.RoleUser, Content: prompt}})
+		if err != nil {
+			if err := quota.Release(r.Context(), userID, quotaDay); err != nil {
+				log.Println("AI quota error:", err)
+			}
+			http.Error(w, "AI processing failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := quota.Record(r.Context(), userID, quotaDay, resp.Tokens); err != nil {
+			log.Println("AI quota error:", err)
+		}
 
-	// Initialize OpenAI client
-	client := openai.NewClient(cfg.OpenAIKey)
+		// Prepare response
+		response := AIResponse{
+			Text: processedHTML(resp.Content),
+		}
 
-	// Create prompt with explicit formatting instructions
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// processPrompt creates the prompt for an editor action, with explicit
+// formatting instructions. It reports false for an unknown action.
+func processPrompt(action, text string) (string, bool) {
 	var prompt string
-	switch req.Action {
+	switch action {
 	case "enhance":
 		prompt = `Please enhance this text with beautiful formatting:
 1. Use proper paragraphs and line breaks
@@ -84,7 +144,7 @@ func AIProcessHandler(w http.ResponseWriter, r *http.Request) {
 7. remove any extra whitespaces and empty bullet points
 
 Text to enhance:
-` + req.Text
+` + text
 
 	case "summarize":
 		prompt = `Create a well-formatted summary:
@@ -95,7 +155,7 @@ Text to enhance:
 5. Return as HTML with proper tags
 
 Text to summarize:
-` + req.Text
+` + text
 
 	case "fix":
 		prompt = `Correct grammar and spelling while:
@@ -105,68 +165,46 @@ Text to summarize:
 4. Returning as HTML with proper <p> tags
 
 Text to correct:
-` + req.Text
+` + text
 
 	default:
-		http.Error(w, "Invalid action", http.StatusBadRequest)
-		return
+		return "", false
 	}
+	return prompt, true
+}
 
-	// Call OpenAI API with better parameters
-	resp, err := client.CreateChatCompletion(r.Context(), openai.ChatCompletionRequest{
-		Model: openai.GPT3Dot5Turbo,
-		Messages: []openai.ChatCompletionMessage{
-			{
-				Role:    openai.ChatMessageRoleUser,
-				Content: prompt,
-			},
-		},
-		Temperature: 0.3, // Lower for more consistent formatting
-		TopP:        0.9,
-	})
-	if err != nil {
-		http.Error(w, "AI processing failed: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Process response to ensure proper HTML
-	responseText := resp.Choices[0].Message.Content
-
+// processedHTML ensures a reply to an editor action is HTML.
+func processedHTML(text string) string {
 	// Basic cleanup if needed
-	if !strings.Contains(responseText, "<p>") {
+	if !strings.Contains(text, "<p>") {
 		// Add basic paragraph formatting if missing
-		responseText = "<p>" + strings.ReplaceAll(responseText, "\n\n", "</p><p>") + "</p>"
-	}
-
-	// Prepare response
-	response := AIResponse{
-		Text: responseText,
+		text = "<p>" + strings.ReplaceAll(text, "\n\n", "</p><p>") + "</p>"
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	return text
 }
 
-func SummarizeMeetingHandler(w http.ResponseWriter, r *http.Request) {
-	cfg := config.LoadConfig()
-
-	// Parse request
-	var req MeetingSummaryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
+func SummarizeMeetingHandler(db *sql.DB, stripeSvc *stripe.Service, quota *ai.Quota, provider ai.Provider, meetingRepo repository.MeetingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
 
-	if req.Transcript == "" {
-		http.Error(w, "Transcript is required", http.StatusBadRequest)
-		return
-	}
+		// Parse request
+		var req MeetingSummaryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
 
-	// Initialize OpenAI client
-	client := openai.NewClient(cfg.OpenAIKey)
+		if req.Transcript == "" {
+			http.Error(w, "Transcript is required", http.StatusBadRequest)
+			return
+		}
 
-	// Create structured prompt with correct backticks
-	prompt := fmt.Sprintf(`Analyze this meeting transcript thoroughly and provide a detailed breakdown in JSON format. Follow these instructions carefully:
+		// Create structured prompt with correct backticks
+		prompt := fmt.Sprintf(`Analyze this meeting transcript thoroughly and provide a detailed breakdown in JSON format. Follow these instructions carefully:
 
 1. SUMMARY (3-4 paragraphs):
    - Capture the main themes, decisions, and conclusions
@@ -230,71 +268,167 @@ Rules:
 Meeting transcript:
 %s`, req.Transcript)
 
-	// Call OpenAI API
-	resp, err := client.CreateChatCompletion(r.Context(), openai.ChatCompletionRequest{
-		Model: openai.GPT3Dot5Turbo,
-		Messages: []openai.ChatCompletionMessage{
-			{
-				Role:    openai.ChatMessageRoleUser,
-				Content: prompt,
-			},
-		},
-		Temperature: 0.2,
-		TopP:        0.8,
-		ResponseFormat: &openai.ChatCompletionResponseFormat{
-			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
-		},
-	})
-	if err != nil {
-		http.Error(w, "AI processing failed: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Parse the JSON response
-	var summaryResponse MeetingSummaryResponse
-	responseText := resp.Choices[0].Message.Content
-
-	if !strings.Contains(responseText, "{") {
-		// Handle cases where the response isn't properly formatted JSON
-		summaryResponse = MeetingSummaryResponse{
-			Summary:      "Could not parse summary",
-			KeyPoints:    []string{"Error parsing key points"},
-			ActionItems:  []ActionItem{{Task: "Error parsing action items"}},
-			Participants: []string{"Error parsing participants"},
-			Decisions:    []Decision{{Description: "Error parsing decisions"}},
-			FollowUps:    []FollowUp{{Action: "Error parsing follow ups"}},
+		quotaDay, ok := reserveAI(w, r, db, stripeSvc, quota, userID)
+		if !ok {
+			return
 		}
-	} else {
-		// Try to parse the JSON
-		if err := json.Unmarshal([]byte(responseText), &summaryResponse); err != nil {
-			fmt.Println("Parsing error:", err.Error())
-			http.Error(w, "Failed to parse AI response: "+err.Error(), http.StatusInternalServerError)
+
+		resp, err := provider.ChatJSON(r.Context(), []ai.Message{{Role: ai.RoleUser, Content: prompt}})
+		if err != nil {
+			if err := quota.Release(r.Context(), userID, quotaDay); err != nil {
+				log.Println("AI quota error:", err)
+			}
+			http.Error(w, "AI processing failed: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-	}
+		if err := quota.Record(r.Context(), userID, quotaDay, resp.Tokens); err != nil {
+			log.Println("AI quota error:", err)
+		}
+
+		// Parse the JSON response
+		var summaryResponse MeetingSummaryResponse
+		responseText := resp.Content
+
+		if !strings.Contains(responseText, "{") {
+			// Handle cases where the response isn't properly formatted JSON
+			summaryResponse = MeetingSummaryResponse{
+				Summary:      "Could not parse summary",
+				KeyPoints:    []string{"Error parsing key points"},
+				ActionItems:  []ActionItem{{Task: "Error parsing action items"}},
+				Participants: []string{"Error parsing participants"},
+				Decisions:    []Decision{{Description: "Error parsing decisions"}},
+				FollowUps:    []FollowUp{{Action: "Error parsing follow ups"}},
+			}
+		} else {
+			// Try to parse the JSON
+			var reply meetingSummaryReply
+			if err := json.Unmarshal([]byte(responseText), &reply); err != nil {
+				fmt.Println("Parsing error:", err.Error())
+				http.Error(w, "Failed to parse AI response: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			summaryResponse = MeetingSummaryResponse{
+				Summary:      reply.Summary,
+				KeyPoints:    reply.KeyPoints,
+				ActionItems:  reply.ActionItems,
+				Participants: reply.Participants,
+				Decisions:    reply.Decisions,
+				FollowUps:    reply.FollowUps,
+			}
+
+			// Keep the structure so it can be queried and rendered again
+			// later; the summary is still useful if this fails
+			meeting := summaryResponse.meeting(userID)
+			if err := meetingRepo.Create(r.Context(), meeting); err != nil {
+				log.Println("Create meeting error:", err)
+			} else {
+				summaryResponse.MeetingID = meeting.ID
+			}
+		}
 
-	// Ensure we have at least minimal content
-	if summaryResponse.Summary == "" {
-		summaryResponse.Summary = "No summary generated"
+		// Ensure we have at least minimal content
+		if summaryResponse.Summary == "" {
+			summaryResponse.Summary = "No summary generated"
+		}
+		if len(summaryResponse.KeyPoints) == 0 {
+			summaryResponse.KeyPoints = []string{"No key points identified"}
+		}
+		if len(summaryResponse.ActionItems) == 0 {
+			summaryResponse.ActionItems = []ActionItem{{Task: "No action items identified"}}
+		}
+		if len(summaryResponse.Participants) == 0 {
+			summaryResponse.Participants = []string{"No participants identified"}
+		}
+		if len(summaryResponse.Decisions) == 0 {
+			summaryResponse.Decisions = []Decision{{Description: "No decisions identified"}}
+		}
+		if len(summaryResponse.FollowUps) == 0 {
+			summaryResponse.FollowUps = []FollowUp{{Action: "No follow ups identified"}}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(summaryResponse); err != nil {
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		}
 	}
-	if len(summaryResponse.KeyPoints) == 0 {
-		summaryResponse.KeyPoints = []string{"No key points identified"}
+}
+
+// meeting converts a parsed summary into the stored form.
+func (s MeetingSummaryResponse) meeting(userID int) *models.Meeting {
+	m := &models.Meeting{
+		UserID:       userID,
+		Summary:      s.Summary,
+		KeyPoints:    s.KeyPoints,
+		Participants: s.Participants,
 	}
-	if len(summaryResponse.ActionItems) == 0 {
-		summaryResponse.ActionItems = []ActionItem{{Task: "No action items identified"}}
+	for _, a := range s.ActionItems {
+		// Each action item becomes a task, which is nothing without one
+		if strings.TrimSpace(a.Task) == "" {
+			continue
+		}
+		m.ActionItems = append(m.ActionItems, models.MeetingActionItem{
+			Task:         a.Task,
+			Owner:        a.Owner,
+			Deadline:     a.Deadline,
+			Dependencies: a.Dependencies,
+		})
 	}
-	if len(summaryResponse.Participants) == 0 {
-		summaryResponse.Participants = []string{"No participants identified"}
+	for _, d := range s.Decisions {
+		m.Decisions = append(m.Decisions, models.MeetingDecision{
+			Description:  d.Description,
+			Rationale:    d.Rationale,
+			Alternatives: d.Alternatives,
+		})
 	}
-	if len(summaryResponse.Decisions) == 0 {
-		summaryResponse.Decisions = []Decision{{Description: "No decisions identified"}}
+	for _, f := range s.FollowUps {
+		m.FollowUps = append(m.FollowUps, models.MeetingFollowUp{
+			Action:      f.Action,
+			Responsible: f.Responsible,
+			Timeline:    f.Timeline,
+		})
 	}
-	if len(summaryResponse.FollowUps) == 0 {
-		summaryResponse.FollowUps = []FollowUp{{Action: "No follow ups identified"}}
+	return m
+}
+
+// reserveAI counts a request against the user's daily AI quota and returns
+// the day it was counted against, for recording or releasing it. If the
+// quota is used up it writes a 429 with the details as JSON and returns
+// false.
+func reserveAI(w http.ResponseWriter, r *http.Request, db *sql.DB, stripeSvc *stripe.Service, quota *ai.Quota, userID int) (string, bool) {
+	_, _, isSubscribed, err := stripeSvc.CheckUserLimits(db, userID)
+	if err != nil {
+		log.Println("Check user limits error:", err)
+		http.Error(w, "Failed to check subscription", http.StatusInternalServerError)
+		return "", false
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(summaryResponse); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	day, err := quota.Reserve(r.Context(), userID, isSubscribed)
+	var exceeded *ai.QuotaExceededError
+	if errors.As(err, &exceeded) {
+		message := fmt.Sprintf("You've reached today's limit of %d AI %s. It resets at midnight UTC.", exceeded.Limit, exceeded.Resource)
+		if !isSubscribed {
+			message += " Subscribe for a higher daily quota."
+		}
+		retryAfter := int(math.Ceil(time.Until(exceeded.ResetAt).Seconds()))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":      "quota_exceeded",
+			"message":    message,
+			"resource":   exceeded.Resource,
+			"used":       exceeded.Used,
+			"limit":      exceeded.Limit,
+			"reset_at":   exceeded.ResetAt,
+			"subscribed": isSubscribed,
+		})
+		return "", false
+	}
+	if err != nil {
+		log.Println("AI quota error:", err)
+		http.Error(w, "Failed to check AI quota", http.StatusInternalServerError)
+		return "", false
 	}
+	return day, true
 }
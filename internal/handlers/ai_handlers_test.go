@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/ai"
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+	"github.com/ahsanfayaz52/diaryservice/internal/stripe"
+)
+
+type aiTest struct {
+	db        *sql.DB
+	userID    int
+	stripeSvc *stripe.Service
+	quota     *ai.Quota
+	provider  *ai.Fake
+}
+
+func newAITest(t *testing.T, requests int) *aiTest {
+	t.Helper()
+	conn := dbtest.New(t)
+	limits := ai.Limits{Requests: requests, Tokens: 100000}
+	return &aiTest{
+		db:        conn,
+		userID:    dbtest.CreateUser(t, conn, "a@example.com", true),
+		stripeSvc: stripe.NewService(stripe.Config{FreeNoteLimit: 10}),
+		quota:     ai.NewQuota(conn, limits, limits),
+		provider:  ai.NewFake(),
+	}
+}
+
+func (at *aiTest) post(handler http.HandlerFunc, action, text string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(AIRequest{Text: text, Action: action})
+	req := httptest.NewRequest(http.MethodPost, "/ai/process", strings.NewReader(string(body)))
+	req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, at.userID))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// requestsUsed returns the AI requests counted against the user today.
+func (at *aiTest) requestsUsed(t *testing.T) int {
+	t.Helper()
+	var n int
+	err := at.db.QueryRow("SELECT requests FROM ai_usage WHERE user_id = ? AND day = ?", at.userID, time.Now().UTC().Format(time.DateOnly)).Scan(&n)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAIProcessHandler(t *testing.T) {
+	at := newAITest(t, 5)
+	handler := AIProcessHandler(at.db, at.stripeSvc, at.quota, at.provider)
+	at.provider.Reply("Much better text")
+
+	rec := at.post(handler, "enhance", "some text")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp AIResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "<p>Much better text</p>" {
+		t.Errorf("text = %q", resp.Text)
+	}
+	calls := at.provider.Calls()
+	if len(calls) != 1 || !strings.Contains(calls[0][0].Content, "some text") {
+		t.Errorf("provider calls = %v", calls)
+	}
+	if n := at.requestsUsed(t); n != 1 {
+		t.Errorf("%d requests used, want 1", n)
+	}
+}
+
+func TestAIProcessHandlerUnknownAction(t *testing.T) {
+	at := newAITest(t, 5)
+	handler := AIProcessHandler(at.db, at.stripeSvc, at.quota, at.provider)
+
+	if rec := at.post(handler, "translate", "some text"); rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+	if len(at.provider.Calls()) != 0 {
+		t.Error("provider was called")
+	}
+	if n := at.requestsUsed(t); n != 0 {
+		t.Errorf("%d requests used, want 0", n)
+	}
+}
+
+func TestAIProcessHandlerProviderFailure(t *testing.T) {
+	at := newAITest(t, 5)
+	handler := AIProcessHandler(at.db, at.stripeSvc, at.quota, at.provider)
+	at.provider.Fail(errors.New("model unavailable"))
+
+	if rec := at.post(handler, "fix", "some text"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", rec.Code)
+	}
+	if n := at.requestsUsed(t); n != 0 {
+		t.Errorf("%d requests used after a failure, want 0", n)
+	}
+}
+
+func TestAIProcessHandlerQuotaExceeded(t *testing.T) {
+	at := newAITest(t, 1)
+	handler := AIProcessHandler(at.db, at.stripeSvc, at.quota, at.provider)
+
+	if rec := at.post(handler, "fix", "one"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", rec.Code)
+	}
+	rec := at.post(handler, "fix", "two")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", rec.Code)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] != "quota_exceeded" || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("got %v, Retry-After %q", body, rec.Header().Get("Retry-After"))
+	}
+	if len(at.provider.Calls()) != 1 {
+		t.Errorf("provider called %d times, want 1", len(at.provider.Calls()))
+	}
+}
+
+type sseEvent struct {
+	name string
+	data map[string]string
+}
+
+func readEvents(t *testing.T, rec *httptest.ResponseRecorder) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &current.data); err != nil {
+				t.Fatalf("event data %q: %v", line, err)
+			}
+		case line == "":
+			events = append(events, current)
+			current = sseEvent{}
+		}
+	}
+	return events
+}
+
+func TestAIProcessStreamHandler(t *testing.T) {
+	at := newAITest(t, 5)
+	handler := AIProcessStreamHandler(at.db, at.stripeSvc, at.quota, at.provider)
+	at.provider.Reply("Hello there world")
+
+	rec := at.post(handler, "summarize", "some text")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	events := readEvents(t, rec)
+	var deltas []string
+	for _, e := range events[:len(events)-1] {
+		if e.name != "delta" {
+			t.Fatalf("got %q event before the end", e.name)
+		}
+		deltas = append(deltas, e.data["text"])
+	}
+	if got := strings.Join(deltas, ""); got != "Hello there world" || len(deltas) != 3 {
+		t.Errorf("deltas = %q", deltas)
+	}
+	if last := events[len(events)-1]; last.name != "done" || last.data["text"] != "<p>Hello there world</p>" {
+		t.Errorf("last event = %+v", last)
+	}
+	if n := at.requestsUsed(t); n != 1 {
+		t.Errorf("%d requests used, want 1", n)
+	}
+}
+
+func TestAIProcessStreamHandlerUnknownAction(t *testing.T) {
+	at := newAITest(t, 5)
+	handler := AIProcessStreamHandler(at.db, at.stripeSvc, at.quota, at.provider)
+
+	if rec := at.post(handler, "translate", "some text"); rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+	if len(at.provider.Calls()) != 0 {
+		t.Error("provider was called")
+	}
+}
+
+func TestAIProcessStreamHandlerProviderFailure(t *testing.T) {
+	at := newAITest(t, 5)
+	handler := AIProcessStreamHandler(at.db, at.stripeSvc, at.quota, at.provider)
+	at.provider.Fail(errors.New("model unavailable"))
+
+	rec := at.post(handler, "enhance", "some text")
+	events := readEvents(t, rec)
+	if len(events) != 1 || events[0].name != "error" || events[0].data["message"] == "" {
+		t.Fatalf("events = %+v", events)
+	}
+	if strings.Contains(events[0].data["message"], "model unavailable") {
+		t.Error("error event leaks the provider's error")
+	}
+	if n := at.requestsUsed(t); n != 0 {
+		t.Errorf("%d requests used after a failure, want 0", n)
+	}
+}
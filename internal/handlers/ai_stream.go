@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/ai"
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/stripe"
+)
+
+// AIProcessStreamHandler is AIProcessHandler sending the reply as
+// Server-Sent Events while it is generated: a "delta" event with each piece
+// of text, then a "done" event with the whole reply formatted as HTML, or an
+// "error" event with a message. Closing the connection cancels the request.
+func AIProcessStreamHandler(db *sql.DB, stripeSvc *stripe.Service, quota *ai.Quota, provider ai.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req AIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "Text is required", http.StatusBadRequest)
+			return
+		}
+		prompt, ok := processPrompt(req.Action, req.Text)
+		if !ok {
+			http.Error(w, "Invalid action", http.StatusBadRequest)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		// Quota errors are still plain JSON responses, before the stream starts
+		quotaDay, ok := reserveAI(w, r, db, stripeSvc, quota, userID)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Stop proxies such as nginx from buffering the stream
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		resp, err := provider.Stream(r.Context(), []ai.Message{{Role: ai.RoleUser, Content: prompt}}, func(delta string) error {
+			if err := writeEvent(w, "delta", AIResponse{Text: delta}); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		})
+
+		// Usage is recorded even when the user cancels, as the model has
+		// done the work
+		quotaCtx := context.WithoutCancel(r.Context())
+		if err != nil {
+			if resp == nil {
+				if err := quota.Release(quotaCtx, userID, quotaDay); err != nil {
+					log.Println("AI quota error:", err)
+				}
+			} else if err := quota.Record(quotaCtx, userID, quotaDay, resp.Tokens); err != nil {
+				log.Println("AI quota error:", err)
+			}
+
+			if r.Context().Err() != nil {
+				// The user cancelled or went away
+				return
+			}
+			log.Println("AI stream error:", err)
+			writeEvent(w, "error", map[string]string{"message": "AI processing failed. Please try again."})
+			flusher.Flush()
+			return
+		}
+		if err := quota.Record(quotaCtx, userID, quotaDay, resp.Tokens); err != nil {
+			log.Println("AI quota error:", err)
+		}
+
+		writeEvent(w, "done", AIResponse{Text: processedHTML(resp.Content)})
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes one Server-Sent Event with data encoded as JSON, which
+// keeps newlines in the text from ending the event early.
+func writeEvent(w http.ResponseWriter, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/gorilla/mux"
+)
+
+// apiTokenExpiries are the lifetimes offered when creating a token, in
+// days; 0 means it never expires.
+var apiTokenExpiries = []int{30, 90, 365, 0}
+
+// APITokensHandler lists the user's personal access tokens.
+func APITokensHandler(tokens *auth.APITokens) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		renderAPITokensPage(w, r, tokens, userID, http.StatusOK, map[string]interface{}{
+			"Revoked": r.URL.Query().Get("revoked") == "1",
+		})
+	}
+}
+
+// CreateAPITokenHandler issues a token and shows it to the user, once.
+func CreateAPITokenHandler(tokens *auth.APITokens) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form", http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(r.FormValue("name"))
+		scopes := r.Form["scopes"]
+		days, err := strconv.Atoi(r.FormValue("expires"))
+		if err != nil || !validExpiry(days) {
+			days = apiTokenExpiries[0]
+		}
+
+		if name == "" || len(scopes) == 0 {
+			renderAPITokensPage(w, r, tokens, userID, http.StatusBadRequest, map[string]interface{}{
+				"Error": "Give the token a name and at least one scope.",
+			})
+			return
+		}
+
+		token, err := tokens.Create(r.Context(), userID, name, scopes, time.Duration(days)*24*time.Hour)
+		if errors.Is(err, auth.ErrUnknownScope) {
+			renderAPITokensPage(w, r, tokens, userID, http.StatusBadRequest, map[string]interface{}{
+				"Error": "Unknown scope selected.",
+			})
+			return
+		}
+		if err != nil {
+			log.Println("Create API token error:", err)
+			http.Error(w, "Failed to create token", http.StatusInternalServerError)
+			return
+		}
+
+		renderAPITokensPage(w, r, tokens, userID, http.StatusCreated, map[string]interface{}{
+			"NewToken": token,
+		})
+	}
+}
+
+// RevokeAPITokenHandler revokes one of the user's tokens.
+func RevokeAPITokenHandler(tokens *auth.APITokens) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err := tokens.Revoke(r.Context(), userID, id); err != nil {
+			if errors.Is(err, auth.ErrAPITokenNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Println("Revoke API token error:", err)
+			http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/tokens?revoked=1", http.StatusSeeOther)
+	}
+}
+
+func renderAPITokensPage(w http.ResponseWriter, r *http.Request, tokens *auth.APITokens, userID, status int, data map[string]interface{}) {
+	list, err := tokens.List(r.Context(), userID)
+	if err != nil {
+		log.Println("List API tokens error:", err)
+		http.Error(w, "Failed to load tokens", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl := parsePage(r, nil, "templates/api_tokens.html", "templates/base.html")
+
+	data["Tokens"] = list
+	data["Scopes"] = auth.Scopes
+	data["Expiries"] = apiTokenExpiries
+	data["CurrentPage"] = "security"
+	data["IsAuthenticated"] = true
+
+	w.WriteHeader(status)
+	if err := tmpl.ExecuteTemplate(w, "base.html", data); err != nil {
+		log.Println("Template render error:", err)
+	}
+}
+
+func validExpiry(days int) bool {
+	for _, d := range apiTokenExpiries {
+		if d == days {
+			return true
+		}
+	}
+	return false
+}
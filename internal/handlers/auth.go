@@ -1,9 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"log"
+	"math"
 	"net/http"
-	"text/template"
+	netmail "net/mail"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ahsanfayaz52/diaryservice/internal/auth"
@@ -11,18 +17,27 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-func RegisterHandler(db *sql.DB) http.HandlerFunc {
+func RegisterHandler(db *sql.DB, verifier *auth.EmailVerifier) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tmpl := template.Must(template.ParseFiles("templates/register.html", "templates/base.html"))
+		tmpl := parsePage(r, nil, "templates/register.html", "templates/base.html")
 
 		if r.Method == http.MethodGet {
 			tmpl.ExecuteTemplate(w, "base.html", nil)
 			return
 		}
 
-		email := r.FormValue("email")
+		email := strings.TrimSpace(r.FormValue("email"))
 		password := r.FormValue("password")
 
+		if !validEmail(email) {
+			w.WriteHeader(http.StatusBadRequest)
+			tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+				"Error":      "Please check the form and try again",
+				"EmailError": "Enter a valid email address",
+			})
+			return
+		}
+
 		// hash password
 		hashedPass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		if err != nil {
@@ -30,79 +45,221 @@ func RegisterHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		_, err = db.Exec("INSERT INTO users (email, password) VALUES (?, ?)", email, string(hashedPass))
+		res, err := db.Exec("INSERT INTO users (email, password) VALUES (?, ?)", email, string(hashedPass))
 		if err != nil {
 			http.Error(w, "Email already registered", http.StatusBadRequest)
 			return
 		}
 
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		if userID, err := res.LastInsertId(); err != nil {
+			log.Println("Registered user ID error:", err)
+		} else {
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				if err := verifier.Send(ctx, int(userID), email); err != nil {
+					log.Println("Send verification email error:", err)
+				}
+			}()
+		}
+
+		http.Redirect(w, r, "/login?registered=1", http.StatusSeeOther)
 	}
 }
 
-func LoginHandler(db *sql.DB, jwtService *auth.JWTService) http.HandlerFunc {
+// LoginHandler checks the email and password. Users with two-factor
+// authentication continue to the second step before a session starts.
+// Repeated failures are slowed down by throttle. sso is nil unless single
+// sign-on is configured.
+func LoginHandler(db *sql.DB, sessions *auth.Sessions, twoFactor *auth.TwoFactor, throttle *auth.LoginThrottle, sso *auth.SSO) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tmpl := template.Must(template.ParseFiles("templates/login.html", "templates/base.html"))
+		tmpl := parsePage(r, nil, "templates/login.html", "templates/base.html")
+
+		data := map[string]interface{}{}
+		if sso != nil {
+			data["SSOName"] = sso.Name()
+		}
 
 		if r.Method == http.MethodGet {
-			tmpl.ExecuteTemplate(w, "base.html", nil)
+			switch {
+			case r.URL.Query().Get("reset") == "1":
+				data["Success"] = "Your password has been reset. Log in with your new password."
+			case r.URL.Query().Get("registered") == "1":
+				data["Success"] = "Account created. We've emailed you a link to confirm your address."
+			case r.URL.Query().Get("deleted") == "1":
+				data["Success"] = "Your account and all of its notes have been deleted."
+			case r.URL.Query().Get("unlocked") == "1":
+				data["Success"] = "Your account has been unlocked. You can log in again."
+			case r.URL.Query().Get("sso") == "unverified":
+				data["Error"] = "Your identity provider did not confirm your email address, so we can't sign you in with it."
+			case r.URL.Query().Get("sso") == "unclaimed":
+				data["Error"] = "An account with your email address exists but was never confirmed. Reset its password to claim it, then sign in again."
+			case r.URL.Query().Get("sso") == "cancelled":
+				data["Error"] = "Single sign-on was cancelled."
+			case r.URL.Query().Get("sso") == "failed":
+				data["Error"] = "Single sign-on failed. Please try again."
+			}
+			tmpl.ExecuteTemplate(w, "base.html", data)
 			return
 		}
 
 		email := r.FormValue("email")
 		password := r.FormValue("password")
+		ip := sessions.ClientIP(r)
+
+		// Preserve the email so user doesn't have to retype
+		data["Email"] = email
+
+		if err := throttle.Check(r.Context(), email, ip); err != nil {
+			if renderThrottled(w, err) {
+				data["Error"] = throttledMessage(err)
+				tmpl.ExecuteTemplate(w, "base.html", data)
+				return
+			}
+			log.Println("Login throttle error:", err)
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+
+		// Pass error message to template
+		data["Error"] = "Invalid email or password"
 
 		var user models.User
 		row := db.QueryRow("SELECT id, password FROM users WHERE email=?", email)
 		err := row.Scan(&user.ID, &user.Password)
+		if err == nil {
+			err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+		}
 		if err != nil {
-			// Pass error message to template
-			tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
-				"Error": "Invalid email or password",
-				"Email": email, // Preserve the email so user doesn't have to retype
-			})
+			if err := throttle.Fail(r.Context(), email, ip); err != nil {
+				log.Println("Login throttle error:", err)
+			}
+			tmpl.ExecuteTemplate(w, "base.html", data)
 			return
 		}
 
-		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-		if err != nil {
-			// Pass error message to template
+		if err := throttle.Reset(r.Context(), email); err != nil {
+			log.Println("Login throttle error:", err)
+		}
+		completeLogin(w, r, sessions, twoFactor, user.ID)
+	}
+}
+
+// completeLogin signs in a user whose first factor has been checked: it
+// starts a session, or the two-factor step if the user has it enabled.
+func completeLogin(w http.ResponseWriter, r *http.Request, sessions *auth.Sessions, twoFactor *auth.TwoFactor, userID int) {
+	enabled, err := twoFactor.Enabled(r.Context(), userID)
+	if err != nil {
+		log.Println("Two-factor status error:", err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		if err := twoFactor.StartChallenge(w, userID); err != nil {
+			log.Println("Two-factor challenge error:", err)
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	if err := sessions.Start(w, r, userID); err != nil {
+		log.Println("Session start error:", err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// UnlockAccountHandler lifts a lockout using the link from the email sent
+// when the account was locked.
+func UnlockAccountHandler(throttle *auth.LoginThrottle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := throttle.Unlock(r.Context(), r.URL.Query().Get("token"))
+		if errors.Is(err, auth.ErrInvalidToken) {
+			tmpl := parsePage(r, nil, "templates/login.html", "templates/base.html")
+			w.WriteHeader(http.StatusBadRequest)
 			tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
-				"Error": "Invalid email or password",
-				"Email": email, // Preserve the email so user doesn't have to retype
+				"Error": "This unlock link is invalid, has expired or has already been used.",
 			})
 			return
 		}
-
-		token, err := jwtService.GenerateToken(user.ID)
 		if err != nil {
-			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			log.Println("Unlock account error:", err)
+			http.Error(w, "Failed to unlock account", http.StatusInternalServerError)
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     "token",
-			Value:    token,
-			HttpOnly: true,
-			Path:     "/",
-			Expires:  time.Now().Add(72 * time.Hour),
-		})
+		http.Redirect(w, r, "/login?unlocked=1", http.StatusSeeOther)
+	}
+}
+
+// renderThrottled sets the status and Retry-After header for a throttled
+// login and reports whether err was a *auth.ThrottledError.
+func renderThrottled(w http.ResponseWriter, err error) bool {
+	if !setRetryAfter(w, err) {
+		return false
+	}
+	w.WriteHeader(http.StatusTooManyRequests)
+	return true
+}
+
+// setRetryAfter is renderThrottled for pages that write their own status.
+func setRetryAfter(w http.ResponseWriter, err error) bool {
+	var throttled *auth.ThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	return true
+}
 
-		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+// throttledMessage tells the user how long to wait after too many failed
+// logins.
+func throttledMessage(err error) string {
+	var throttled *auth.ThrottledError
+	errors.As(err, &throttled)
+
+	wait := "a few seconds"
+	if minutes := int(math.Ceil(throttled.RetryAfter.Minutes())); throttled.RetryAfter > time.Minute {
+		wait = strconv.Itoa(minutes) + " minutes"
+	} else if seconds := int(math.Ceil(throttled.RetryAfter.Seconds())); seconds > 1 {
+		wait = strconv.Itoa(seconds) + " seconds"
+	}
+	if throttled.Locked {
+		return "This account is temporarily locked after too many failed login attempts. Try again in " + wait +
+			", or use the link we've emailed to the account's owner to unlock it now."
 	}
+	return "Too many failed login attempts. Please wait " + wait + " before trying again."
 }
 
-func LogoutHandler() http.HandlerFunc {
+func LogoutHandler(sessions *auth.Sessions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Clear cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "token",
-			Value:    "",
-			HttpOnly: true,
-			Path:     "/",
-			MaxAge:   -1,
-		})
+		if err := sessions.End(w, r); err != nil {
+			log.Println("Session end error:", err)
+		}
 
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 	}
 }
+
+// checkPassword reports whether password is the user's current password.
+func checkPassword(r *http.Request, db *sql.DB, userID int, password string) (bool, error) {
+	var hashed string
+	if err := db.QueryRowContext(r.Context(), "SELECT password FROM users WHERE id = ?", userID).Scan(&hashed); err != nil {
+		return false, err
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) == nil, nil
+}
+
+// validEmail reports whether s is a bare email address such as
+// "user@example.com", without a display name or angle brackets.
+func validEmail(s string) bool {
+	if len(s) > 255 {
+		return false
+	}
+	addr, err := netmail.ParseAddress(s)
+	return err == nil && addr.Address == s && strings.Contains(s[strings.LastIndex(s, "@"):], ".")
+}
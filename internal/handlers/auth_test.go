@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+)
+
+func TestLoginHandlerRetryAfter(t *testing.T) {
+	conn := dbtest.New(t)
+	dbtest.CreateUser(t, conn, "a@example.com", true)
+	jwtService := auth.NewJWTService("test-secret", time.Minute)
+	sessions := auth.NewSessions(conn, jwtService, time.Hour, false)
+	throttle := auth.NewLoginThrottle(conn, auth.NewOneTimeTokens(conn), make(mailRecorder, 1), "")
+	handler := LoginHandler(conn, sessions, auth.NewTwoFactor(conn, nil, jwtService), throttle, nil)
+
+	tests := []struct {
+		failures   int
+		retryAfter string
+		message    string
+	}{
+		{5, "1", "Please wait a few seconds"},
+		{6, "2", "Please wait 2 seconds"},
+		{10, "3600", "temporarily locked"},
+	}
+	failed := 0
+	for _, tt := range tests {
+		for ; failed < tt.failures; failed++ {
+			if err := throttle.Fail(context.Background(), "a@example.com", "192.0.2.1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		form := url.Values{"email": {"a@example.com"}, "password": {"x"}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != tt.retryAfter {
+			t.Errorf("after %d failures: status %d, Retry-After %q; want 429, %q",
+				tt.failures, rec.Code, rec.Header().Get("Retry-After"), tt.retryAfter)
+		}
+		if !strings.Contains(rec.Body.String(), tt.message) {
+			t.Errorf("after %d failures: page does not say %q", tt.failures, tt.message)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain runs the tests from the repository root, where the server runs
+// and page templates are found.
+func TestMain(m *testing.M) {
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/diff"
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
+	"github.com/ahsanfayaz52/diaryservice/internal/textutil"
+	"github.com/gorilla/mux"
+)
+
+func NoteHistoryHandler(noteRepo repository.NoteRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		noteID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		note, err := noteRepo.Get(r.Context(), userID, noteID)
+		if err != nil {
+			writeNoteError(w, r, err)
+			return
+		}
+
+		revisions, err := noteRepo.Revisions(r.Context(), userID, noteID)
+		if err != nil {
+			writeNoteError(w, r, err)
+			return
+		}
+
+		tmpl := parsePage(r, template.FuncMap{
+			"add": func(a, b int) int { return a + b },
+		}, "templates/history.html", "templates/base.html")
+
+		err = tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+			"Note":            note,
+			"Revisions":       revisions,
+			"IsAuthenticated": true,
+		})
+		if err != nil {
+			log.Println("Template render error:", err)
+			http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		}
+	}
+}
+
+// NoteDiffHandler shows a word-level diff between two revisions of a note.
+// Without "from" it compares against the revision before "to"; without "to"
+// it uses the latest revision.
+func NoteDiffHandler(noteRepo repository.NoteRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		noteID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		revisions, err := noteRepo.Revisions(r.Context(), userID, noteID)
+		if err != nil {
+			writeNoteError(w, r, err)
+			return
+		}
+
+		// revisions are newest first
+		toID := revisions[0].ID
+		if v, err := strconv.Atoi(r.URL.Query().Get("to")); err == nil {
+			toID = v
+		}
+		fromID := 0
+		if v, err := strconv.Atoi(r.URL.Query().Get("from")); err == nil {
+			fromID = v
+		} else {
+			for i, rev := range revisions {
+				if rev.ID == toID && i+1 < len(revisions) {
+					fromID = revisions[i+1].ID
+				}
+			}
+		}
+
+		to, err := noteRepo.Revision(r.Context(), userID, noteID, toID)
+		if err != nil {
+			writeNoteError(w, r, err)
+			return
+		}
+		from := &models.NoteRevision{}
+		if fromID != 0 {
+			if from, err = noteRepo.Revision(r.Context(), userID, noteID, fromID); err != nil {
+				writeNoteError(w, r, err)
+				return
+			}
+		}
+
+		tmpl := parsePage(r, nil, "templates/note_diff.html", "templates/base.html")
+
+		err = tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+			"NoteID":          noteID,
+			"From":            from,
+			"To":              to,
+			"Revisions":       revisions,
+			"TitleDiff":       diff.Words(from.Title, to.Title),
+			"TagsDiff":        diff.Words(strings.ReplaceAll(from.Tags, ",", ", "), strings.ReplaceAll(to.Tags, ",", ", ")),
+			"ContentDiff":     diff.Words(textutil.PlainText(from.Content), textutil.PlainText(to.Content)),
+			"IsAuthenticated": true,
+		})
+		if err != nil {
+			log.Println("Template render error:", err)
+			http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		}
+	}
+}
+
+func RestoreRevisionHandler(noteRepo repository.NoteRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		vars := mux.Vars(r)
+		noteID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		revisionID, err := strconv.Atoi(vars["rev"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err := noteRepo.Restore(r.Context(), userID, noteID, revisionID); err != nil {
+			writeNoteError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, "/notes/view/"+strconv.Itoa(noteID), http.StatusSeeOther)
+	}
+}
+
+// writeNoteError maps repository errors onto HTTP responses.
+func writeNoteError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, repository.ErrNoteNotFound) || errors.Is(err, repository.ErrRevisionNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	log.Println("Note error:", err)
+	http.Error(w, "Failed to load note", http.StatusInternalServerError)
+}
@@ -3,9 +3,16 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
 	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
 	"github.com/ahsanfayaz52/diaryservice/internal/stripe"
-	"net/http"
+	"github.com/gorilla/mux"
 )
 
 func MeetingLimitsHandler(db *sql.DB, stripeSvc *stripe.Service) http.HandlerFunc {
@@ -31,3 +38,68 @@ func MeetingLimitsHandler(db *sql.DB, stripeSvc *stripe.Service) http.HandlerFun
 		})
 	}
 }
+
+// MeetingHandler returns a stored meeting summary with its action items and
+// decisions, for rendering it in another layout.
+func MeetingHandler(meetingRepo repository.MeetingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		meetingID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		meeting, err := meetingRepo.Get(r.Context(), userID, meetingID)
+		if err != nil {
+			if errors.Is(err, repository.ErrMeetingNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Println("Get meeting error:", err)
+			http.Error(w, "Failed to load meeting", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(meeting)
+	}
+}
+
+// ActionItemsHandler lists action items across the user's meetings,
+// optionally only those with a given owner, status and due date, as in
+// ?owner=Alice&status=open&due=week. due is one of overdue, today, week or
+// none.
+func ActionItemsHandler(meetingRepo repository.MeetingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		filter, err := actionItemFilter(r.URL.Query(), today())
+		if err != nil {
+			http.Error(w, "Invalid filter", http.StatusBadRequest)
+			return
+		}
+
+		items, err := meetingRepo.ActionItems(r.Context(), userID, filter)
+		if err != nil {
+			log.Println("List action items error:", err)
+			http.Error(w, "Failed to load action items", http.StatusInternalServerError)
+			return
+		}
+		if items == nil {
+			items = []models.MeetingActionItem{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"github.com/ahsanfayaz52/diaryservice/internal/auth"
-	"github.com/ahsanfayaz52/diaryservice/internal/encryption"
+	"github.com/ahsanfayaz52/diaryservice/internal/middleware"
 	"github.com/ahsanfayaz52/diaryservice/internal/models"
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
 	"github.com/ahsanfayaz52/diaryservice/internal/stripe"
 	"github.com/gorilla/mux"
 	"html/template"
@@ -14,7 +16,7 @@ import (
 	"strings"
 )
 
-func DashboardHandler(db *sql.DB, encryptionSvc *encryption.Service) http.HandlerFunc {
+func DashboardHandler(noteRepo repository.NoteRepository, meetingRepo repository.MeetingRepository, verifier *auth.EmailVerifier) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var isAuthenticated bool
 		userID := auth.GetUserIDFromContext(r.Context())
@@ -35,121 +37,49 @@ func DashboardHandler(db *sql.DB, encryptionSvc *encryption.Service) http.Handle
 			}
 		}
 		pageSize := 9
-		offset := (page - 1) * pageSize
 
-		where := []string{"user_id = ?"}
-		args := []interface{}{userID}
-
-		if search != "" {
-			where = append(where, "(title LIKE ? OR content LIKE ?)")
-			args = append(args, "%"+search+"%", "%"+search+"%")
-		}
-
-		// Modified tag filtering to use OR condition
-		if len(tags) > 0 {
-			tagConditions := []string{}
-			for _, tag := range tags {
-				tagConditions = append(tagConditions, "tags LIKE ?")
-				args = append(args, "%"+tag+"%")
-			}
-			where = append(where, "("+strings.Join(tagConditions, " OR ")+")")
-		}
-
-		if filterPinned {
-			where = append(where, "is_pinned = 1")
-		}
-		if filterStarred {
-			where = append(where, "is_starred = 1")
-		}
-
-		whereClause := "WHERE " + strings.Join(where, " AND ")
-
-		orderBy := "created_at DESC"
-		switch sortBy {
-		case "created_at_asc":
-			orderBy = "created_at ASC"
-		case "title_asc":
-			orderBy = "title ASC"
-		case "title_desc":
-			orderBy = "title DESC"
-		}
-
-		// Get total notes count
-		var totalNotes int
-		err := db.QueryRow("SELECT COUNT(*) FROM notes WHERE user_id = ?", userID).Scan(&totalNotes)
+		notes, totalCount, err := noteRepo.List(r.Context(), userID, repository.NoteFilter{
+			Search:   search,
+			Tags:     tags,
+			Pinned:   filterPinned,
+			Starred:  filterStarred,
+			SortBy:   sortBy,
+			Page:     page,
+			PageSize: pageSize,
+		})
 		if err != nil {
-			http.Error(w, "Failed to count notes", http.StatusInternalServerError)
-			log.Println("Count error:", err)
+			http.Error(w, "Failed to fetch notes", http.StatusInternalServerError)
+			log.Println("List notes error:", err)
 			return
 		}
 
-		// Get pinned count
-		var pinnedCount int
-		err = db.QueryRow("SELECT COUNT(*) FROM notes WHERE user_id = ? AND is_pinned = 1", userID).Scan(&pinnedCount)
+		counts, err := noteRepo.Counts(r.Context(), userID)
 		if err != nil {
-			http.Error(w, "Failed to count pinned notes", http.StatusInternalServerError)
+			http.Error(w, "Failed to count notes", http.StatusInternalServerError)
 			log.Println("Count error:", err)
 			return
 		}
 
-		// Get starred count
-		var starredCount int
-		err = db.QueryRow("SELECT COUNT(*) FROM notes WHERE user_id = ? AND is_starred = 1", userID).Scan(&starredCount)
+		tagMap, err := noteRepo.TagCounts(r.Context(), userID)
 		if err != nil {
-			http.Error(w, "Failed to count starred notes", http.StatusInternalServerError)
-			log.Println("Count error:", err)
+			http.Error(w, "Failed to load tags", http.StatusInternalServerError)
+			log.Println("Tag count error:", err)
 			return
 		}
 
-		// Get filtered count
-		var totalCount int
-		err = db.QueryRow("SELECT COUNT(*) FROM notes "+whereClause, args...).Scan(&totalCount)
+		verified, err := verifier.Verified(r.Context(), userID)
 		if err != nil {
-			http.Error(w, "Failed to count notes", http.StatusInternalServerError)
-			log.Println("Count error:", err)
-			return
+			log.Println("Verification status error:", err)
+			verified = true // only hides the reminder banner
 		}
 
-		argsWithLimit := append(args, pageSize, offset)
-		rows, err := db.Query(`
-			SELECT id, user_id, title, content, tags, is_pinned, is_starred, created_at, updated_at
-			FROM notes `+whereClause+`
-			ORDER BY `+orderBy+` LIMIT ? OFFSET ?`, argsWithLimit...)
+		overdue, err := meetingRepo.ActionItems(r.Context(), userID, repository.ActionItemFilter{
+			Status:    models.ActionItemOpen,
+			DueBefore: today(),
+		})
 		if err != nil {
-			http.Error(w, "Failed to fetch notes", http.StatusInternalServerError)
-			log.Println("Query error:", err)
-			return
-		}
-		defer rows.Close()
-
-		var notes []models.Note
-		for rows.Next() {
-			var n models.Note
-
-			if err := rows.Scan(&n.ID, &n.UserID, &n.Title, &n.Content, &n.Tags, &n.IsPinned, &n.IsStarred, &n.CreatedAt, &n.UpdatedAt); err == nil {
-				n.Content, err = encryptionSvc.Decrypt(n.Content)
-				if err != nil {
-					http.Error(w, "Failed to decrypt note", http.StatusInternalServerError)
-					return
-				}
-
-				notes = append(notes, n)
-			}
-		}
-
-		tagMap := map[string]int{}
-		tagRows, _ := db.Query("SELECT tags FROM notes WHERE user_id = ?", userID)
-		defer tagRows.Close()
-		for tagRows.Next() {
-			var tagStr string
-			if err := tagRows.Scan(&tagStr); err == nil {
-				for _, t := range strings.Split(tagStr, ",") {
-					t = strings.TrimSpace(t)
-					if t != "" {
-						tagMap[t]++
-					}
-				}
-			}
+			log.Println("Overdue tasks error:", err)
+			overdue = nil // only hides the overdue banner
 		}
 
 		// Template functions
@@ -206,13 +136,13 @@ func DashboardHandler(db *sql.DB, encryptionSvc *encryption.Service) http.Handle
 			},
 		}
 
-		tmpl := template.Must(template.New("dashboard.html").Funcs(funcMap).ParseFiles("templates/dashboard.html", "templates/base.html"))
+		tmpl := parsePage(r, funcMap, "templates/dashboard.html", "templates/base.html")
 
 		err = tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
 			"Notes":           notes,
-			"TotalNotes":      totalNotes,
-			"PinnedCount":     pinnedCount,
-			"StarredCount":    starredCount,
+			"TotalNotes":      counts.Total,
+			"PinnedCount":     counts.Pinned,
+			"StarredCount":    counts.Starred,
 			"Search":          search,
 			"SelectedTags":    tags,
 			"TagCloud":        tagMap,
@@ -221,6 +151,9 @@ func DashboardHandler(db *sql.DB, encryptionSvc *encryption.Service) http.Handle
 			"SortBy":          sortBy,
 			"Page":            page,
 			"TotalPages":      (totalCount + pageSize - 1) / pageSize,
+			"EmailUnverified": !verified,
+			"OverdueTasks":    overdue,
+			"OverdueCount":    len(overdue),
 			"IsAuthenticated": isAuthenticated,
 		})
 		if err != nil {
@@ -229,7 +162,9 @@ func DashboardHandler(db *sql.DB, encryptionSvc *encryption.Service) http.Handle
 	}
 }
 
-func NewNoteHandler(db *sql.DB, stripeSvc *stripe.Service, encryptionSvc *encryption.Service) http.HandlerFunc {
+// NewNoteHandler creates notes. Users who haven't confirmed their email
+// address can only create unverifiedLimit notes.
+func NewNoteHandler(db *sql.DB, stripeSvc *stripe.Service, noteRepo repository.NoteRepository, meetingRepo repository.MeetingRepository, recordingRepo repository.RecordingRepository, verifier *auth.EmailVerifier, unverifiedLimit int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var isAuthenticated bool
 
@@ -247,9 +182,28 @@ func NewNoteHandler(db *sql.DB, stripeSvc *stripe.Service, encryptionSvc *encryp
 			return
 		}
 
-		tmpl := template.Must(template.New("note_form.html").Funcs(template.FuncMap{
+		verified, err := verifier.Verified(r.Context(), userID)
+		if err != nil {
+			log.Println("Verification status error:", err)
+			http.Error(w, "Failed to check account status", http.StatusInternalServerError)
+			return
+		}
+		if !verified {
+			counts, err := noteRepo.Counts(r.Context(), userID)
+			if err != nil {
+				log.Println("Count error:", err)
+				http.Error(w, "Failed to count notes", http.StatusInternalServerError)
+				return
+			}
+			if counts.Total >= unverifiedLimit {
+				http.Redirect(w, r, "/settings/verify-email?reason=notes", http.StatusSeeOther)
+				return
+			}
+		}
+
+		tmpl := parsePage(r, template.FuncMap{
 			"safeHTML": func(s string) template.HTML { return template.HTML(s) },
-		}).ParseFiles("templates/note_form.html", "templates/base.html"))
+		}, "templates/note_form.html", "templates/base.html")
 
 		if r.Method == http.MethodGet {
 			err := tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
@@ -271,50 +225,27 @@ func NewNoteHandler(db *sql.DB, stripeSvc *stripe.Service, encryptionSvc *encryp
 		isPinned := r.FormValue("is_pinned") == "on"
 		isStarred := r.FormValue("is_starred") == "on"
 
-		encryptedContent, err := encryptionSvc.Encrypt(content)
-		if err != nil {
-			http.Error(w, "Failed to encrypt note", http.StatusInternalServerError)
-			return
+		note := &models.Note{
+			UserID:    userID,
+			Title:     title,
+			Content:   content,
+			Tags:      tags,
+			IsPinned:  isPinned,
+			IsStarred: isStarred,
 		}
-
-		// Start transaction
-		tx, err := db.Begin()
-		if err != nil {
-			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
-			return
-		}
-		defer tx.Rollback()
-
-		// Insert note
-		_, err = tx.Exec(`INSERT INTO notes (user_id, title, content, tags, is_pinned, is_starred, created_at, updated_at)
-			VALUES (?, ?, ?, ?, ?, ?, NOW(), NOW())`,
-			userID, title, encryptedContent, tags, isPinned, isStarred)
-		if err != nil {
+		if err := noteRepo.Create(r.Context(), note); err != nil {
+			log.Println("Create note error:", err)
 			http.Error(w, "Failed to save note", http.StatusInternalServerError)
 			return
 		}
-
-		// Update note count
-		_, err = tx.Exec(`INSERT INTO user_limits (user_id, note_count)
-						VALUES (?, 1)
-						ON DUPLICATE KEY UPDATE note_count = note_count + 1`,
-			userID)
-		if err != nil {
-			http.Error(w, "Failed to update note count", http.StatusInternalServerError)
-			return
-		}
-
-		// Commit transaction
-		if err := tx.Commit(); err != nil {
-			http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
-			return
-		}
+		attachMeetings(r, meetingRepo, userID, note.ID)
+		attachRecordings(r, recordingRepo, userID, note.ID)
 
 		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 	}
 }
 
-func EditNoteHandler(db *sql.DB, stripeSvc *stripe.Service, encryptionSvc *encryption.Service) http.HandlerFunc {
+func EditNoteHandler(db *sql.DB, stripeSvc *stripe.Service, noteRepo repository.NoteRepository, meetingRepo repository.MeetingRepository, recordingRepo repository.RecordingRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var isAuthenticated bool
 
@@ -326,7 +257,7 @@ func EditNoteHandler(db *sql.DB, stripeSvc *stripe.Service, encryptionSvc *encry
 			isAuthenticated = true
 		}
 
-		tmpl, err := template.New("base.html").Funcs(template.FuncMap{
+		tmpl, err := template.New("base.html").Funcs(middleware.TemplateFuncs(r)).Funcs(template.FuncMap{
 			"split":    strings.Split,
 			"safeHTML": func(s string) template.HTML { return template.HTML(s) },
 			"safeJS":   func(s string) template.JS { return template.JS(s) },
@@ -349,30 +280,17 @@ func EditNoteHandler(db *sql.DB, stripeSvc *stripe.Service, encryptionSvc *encry
 		}
 
 		if r.Method == http.MethodGet {
-			var note models.Note
-
-			err := db.QueryRow(`
-                SELECT title, content, tags, is_pinned, is_starred 
-                FROM notes 
-                WHERE id = ? AND user_id = ?`,
-				noteID, userID,
-			).Scan(&note.Title, &note.Content, &note.Tags, &note.IsPinned, &note.IsStarred)
-
+			note, err := noteRepo.Get(r.Context(), userID, noteID)
 			if err != nil {
-				if err == sql.ErrNoRows {
+				if errors.Is(err, repository.ErrNoteNotFound) {
 					http.NotFound(w, r)
 				} else {
+					log.Println("Edit note error:", err)
 					http.Error(w, "Failed to fetch note", http.StatusInternalServerError)
 				}
 				return
 			}
 
-			note.Content, err = encryptionSvc.Decrypt(note.Content)
-			if err != nil {
-				http.Error(w, "Failed to decrypt note", http.StatusInternalServerError)
-				return
-			}
-
 			err = tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
 				"Title":            note.Title,
 				"Content":          template.HTML(note.Content),
@@ -401,34 +319,31 @@ func EditNoteHandler(db *sql.DB, stripeSvc *stripe.Service, encryptionSvc *encry
 			isPinned := r.FormValue("is_pinned") == "on"
 			isStarred := r.FormValue("is_starred") == "on"
 
-			encryptedContent, err := encryptionSvc.Encrypt(content)
-			if err != nil {
-				http.Error(w, "Failed to encrypt note", http.StatusInternalServerError)
-				return
-			}
-
 			if title == "" || content == "" {
 				http.Error(w, "Title and content are required", http.StatusBadRequest)
 				return
 			}
 
-			_, err = db.Exec(`
-                UPDATE notes 
-                SET 
-                    title = ?,
-                    content = ?,
-                    tags = ?,
-                    is_pinned = ?,
-                    is_starred = ?,
-                    updated_at = CURRENT_TIMESTAMP
-                WHERE id = ? AND user_id = ?`,
-				title, encryptedContent, tags, isPinned, isStarred, noteID, userID,
-			)
-
+			err := noteRepo.Update(r.Context(), &models.Note{
+				ID:        noteID,
+				UserID:    userID,
+				Title:     title,
+				Content:   content,
+				Tags:      tags,
+				IsPinned:  isPinned,
+				IsStarred: isStarred,
+			})
 			if err != nil {
+				if errors.Is(err, repository.ErrNoteNotFound) {
+					http.NotFound(w, r)
+					return
+				}
+				log.Println("Update note error:", err)
 				http.Error(w, "Failed to update note", http.StatusInternalServerError)
 				return
 			}
+			attachMeetings(r, meetingRepo, userID, noteID)
+			attachRecordings(r, recordingRepo, userID, noteID)
 
 			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 			return
@@ -438,7 +353,22 @@ func EditNoteHandler(db *sql.DB, stripeSvc *stripe.Service, encryptionSvc *encry
 	}
 }
 
-func DeleteNoteHandler(db *sql.DB) http.HandlerFunc {
+// attachMeetings links the meeting summaries inserted into a note, sent as
+// meeting_ids with the form, to the note once it has been saved. The note
+// is saved either way, so a failure is only logged.
+func attachMeetings(r *http.Request, meetingRepo repository.MeetingRepository, userID, noteID int) {
+	var ids []int
+	for _, v := range r.Form["meeting_ids"] {
+		if id, err := strconv.Atoi(v); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if err := meetingRepo.Attach(r.Context(), userID, noteID, ids); err != nil {
+		log.Println("Attach meetings error:", err)
+	}
+}
+
+func DeleteNoteHandler(noteRepo repository.NoteRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := auth.GetUserIDFromContext(r.Context())
 		if userID == 0 {
@@ -453,9 +383,13 @@ func DeleteNoteHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		_, err = db.Exec("DELETE FROM notes WHERE id=? AND user_id=?", noteID, userID)
-		if err != nil {
-			http.Error(w, "Failed to delete note", http.StatusInternalServerError)
+		if err := noteRepo.Delete(r.Context(), userID, noteID); err != nil {
+			if errors.Is(err, repository.ErrNoteNotFound) {
+				http.NotFound(w, r)
+			} else {
+				log.Println("Delete note error:", err)
+				http.Error(w, "Failed to delete note", http.StatusInternalServerError)
+			}
 			return
 		}
 
@@ -463,7 +397,7 @@ func DeleteNoteHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func ViewNoteHandler(db *sql.DB, encryptionSvc *encryption.Service) http.HandlerFunc {
+func ViewNoteHandler(noteRepo repository.NoteRepository, recordingRepo repository.RecordingRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var isAuthenticated bool
 
@@ -481,16 +415,9 @@ func ViewNoteHandler(db *sql.DB, encryptionSvc *encryption.Service) http.Handler
 			return
 		}
 
-		var note models.Note
-		err = db.QueryRow(`
-            SELECT id, user_id, title, content, tags, is_pinned, is_starred, created_at, updated_at
-            FROM notes 
-            WHERE id = ? AND user_id = ?`,
-			noteID, userID,
-		).Scan(&note.ID, &note.UserID, &note.Title, &note.Content, &note.Tags, &note.IsPinned, &note.IsStarred, &note.CreatedAt, &note.UpdatedAt)
-
+		note, err := noteRepo.Get(r.Context(), userID, noteID)
 		if err != nil {
-			if err == sql.ErrNoRows {
+			if errors.Is(err, repository.ErrNoteNotFound) {
 				http.NotFound(w, r)
 			} else {
 				http.Error(w, "Failed to fetch note", http.StatusInternalServerError)
@@ -499,19 +426,20 @@ func ViewNoteHandler(db *sql.DB, encryptionSvc *encryption.Service) http.Handler
 			return
 		}
 
-		note.Content, err = encryptionSvc.Decrypt(note.Content)
+		recordings, err := recordingRepo.ForNote(r.Context(), userID, noteID)
 		if err != nil {
-			http.Error(w, "Failed to decrypt note", http.StatusInternalServerError)
-			return
+			log.Println("List recordings error:", err)
+			recordings = nil // the note is still worth showing
 		}
 
-		tmpl := template.Must(template.New("view.html").Funcs(template.FuncMap{
+		tmpl := parsePage(r, template.FuncMap{
 			"split":    strings.Split,
 			"safeHTML": func(s string) template.HTML { return template.HTML(s) },
-		}).ParseFiles("templates/view.html", "templates/base.html"))
+		}, "templates/view.html", "templates/base.html")
 
 		err = tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
 			"Note":            note,
+			"Recordings":      recordings,
 			"IsAuthenticated": isAuthenticated,
 		})
 		if err != nil {
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
+	"github.com/gorilla/mux"
+)
+
+func TestDeleteNoteHandler(t *testing.T) {
+	conn := dbtest.New(t)
+	noteRepo := repository.NewNoteRepository(conn, dbtest.Keys(t, conn))
+	owner := dbtest.CreateUser(t, conn, "a@example.com", true)
+	other := dbtest.CreateUser(t, conn, "b@example.com", true)
+	note := &models.Note{UserID: owner, Title: "Mine"}
+	if err := noteRepo.Create(context.Background(), note); err != nil {
+		t.Fatal(err)
+	}
+	handler := DeleteNoteHandler(noteRepo)
+
+	del := func(userID int, id string) int {
+		req := httptest.NewRequest(http.MethodPost, "/notes/"+id+"/delete", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	id := strconv.Itoa(note.ID)
+	if code := del(other, id); code != http.StatusNotFound {
+		t.Errorf("another user's note: status %d, want 404", code)
+	}
+	if code := del(owner, "999"); code != http.StatusNotFound {
+		t.Errorf("missing note: status %d, want 404", code)
+	}
+	if code := del(owner, id); code != http.StatusSeeOther {
+		t.Fatalf("status %d, want 303", code)
+	}
+	if code := del(owner, id); code != http.StatusNotFound {
+		t.Errorf("note already in the trash: status %d, want 404", code)
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/gorilla/mux"
+)
+
+// PasskeysHandler lists the user's registered passkeys.
+func PasskeysHandler(passkeys *auth.Passkeys) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		list, err := passkeys.List(r.Context(), userID)
+		if err != nil {
+			log.Println("List passkeys error:", err)
+			http.Error(w, "Failed to load passkeys", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl := parsePage(r, nil, "templates/passkeys.html", "templates/base.html")
+
+		err = tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+			"Passkeys":        list,
+			"Removed":         r.URL.Query().Get("removed") == "1",
+			"CurrentPage":     "security",
+			"IsAuthenticated": true,
+		})
+		if err != nil {
+			log.Println("Template render error:", err)
+			http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		}
+	}
+}
+
+// BeginPasskeyRegistrationHandler returns the options for creating a new
+// passkey in the browser.
+func BeginPasskeyRegistrationHandler(passkeys *auth.Passkeys) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		options, err := passkeys.BeginRegistration(w, r, userID)
+		if err != nil {
+			log.Println("Begin passkey registration error:", err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to start passkey registration")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(options)
+	}
+}
+
+// FinishPasskeyRegistrationHandler stores the passkey the browser created.
+// The name shown in the list comes from the "name" query parameter.
+func FinishPasskeyRegistrationHandler(passkeys *auth.Passkeys) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := passkeys.FinishRegistration(w, r, userID, r.URL.Query().Get("name")); err != nil {
+			if errors.Is(err, auth.ErrCeremony) {
+				log.Println("Passkey registration rejected:", err)
+				writeJSONError(w, http.StatusBadRequest, "The passkey could not be verified. Please try again.")
+				return
+			}
+			log.Println("Finish passkey registration error:", err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to save passkey")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	}
+}
+
+// DeletePasskeyHandler removes one of the user's passkeys.
+func DeletePasskeyHandler(passkeys *auth.Passkeys) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err := passkeys.Delete(r.Context(), userID, id); err != nil {
+			if errors.Is(err, auth.ErrPasskeyNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Println("Delete passkey error:", err)
+			http.Error(w, "Failed to remove passkey", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/passkeys?removed=1", http.StatusSeeOther)
+	}
+}
+
+// BeginPasskeyLoginHandler returns the options for signing in with any
+// passkey registered for this site.
+func BeginPasskeyLoginHandler(passkeys *auth.Passkeys) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		options, err := passkeys.BeginLogin(w)
+		if err != nil {
+			log.Println("Begin passkey login error:", err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to start passkey login")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(options)
+	}
+}
+
+// FinishPasskeyLoginHandler verifies the passkey assertion and starts a
+// session. Passkeys require user verification on the device, so they
+// replace both the password and the TOTP step.
+func FinishPasskeyLoginHandler(passkeys *auth.Passkeys, sessions *auth.Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := passkeys.FinishLogin(w, r)
+		if err != nil {
+			if errors.Is(err, auth.ErrPasskeyCloned) {
+				log.Println("Passkey sign count did not increase; possible cloned authenticator:", err)
+			} else if !errors.Is(err, auth.ErrCeremony) {
+				log.Println("Finish passkey login error:", err)
+			}
+			writeJSONError(w, http.StatusUnauthorized, "Passkey sign-in failed")
+			return
+		}
+
+		if err := sessions.Start(w, r, userID); err != nil {
+			log.Println("Session start error:", err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to sign in")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"redirect": "/dashboard"})
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
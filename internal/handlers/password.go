@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/mail"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	resetTokenTTL     = time.Hour
+	minPasswordLength = 8
+
+	// As with verification emails, an account gets a reset link at most
+	// once a minute and five times a day.
+	resetInterval = time.Minute
+	resetWindow   = 24 * time.Hour
+	resetMax      = 5
+)
+
+// ForgotPasswordHandler emails a password reset link. The response is the
+// same whether or not the address belongs to an account, and whether or not
+// a link was sent, so requests over the limit are dropped silently.
+func ForgotPasswordHandler(db *sql.DB, tokens *auth.OneTimeTokens, mailer mail.Mailer, baseURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tmpl := parsePage(r, nil, "templates/forgot_password.html", "templates/auth_card.html", "templates/base.html")
+
+		if r.Method == http.MethodGet {
+			tmpl.ExecuteTemplate(w, "base.html", nil)
+			return
+		}
+
+		email := strings.TrimSpace(r.FormValue("email"))
+
+		var (
+			userID  int
+			address string
+		)
+		err := db.QueryRowContext(r.Context(), "SELECT id, email FROM users WHERE LOWER(email) = LOWER(?)", email).Scan(&userID, &address)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			log.Println("Forgot password lookup error:", err)
+		default:
+			now := time.Now()
+			count, latest, err := tokens.IssuedSince(r.Context(), userID, auth.PurposePasswordReset, now.Add(-resetWindow))
+			if err != nil {
+				log.Println("Count reset tokens error:", err)
+				break
+			}
+			if count > 0 && now.Sub(latest) < resetInterval || count >= resetMax {
+				break
+			}
+
+			token, err := tokens.Issue(r.Context(), userID, auth.PurposePasswordReset, resetTokenTTL)
+			if err != nil {
+				log.Println("Issue reset token error:", err)
+				break
+			}
+			// Send in the background so response time doesn't reveal
+			// whether the account exists
+			go func() {
+				msg := mail.Message{
+					To:      address,
+					Subject: "Reset your AI Note Assistant password",
+					Body: fmt.Sprintf("Someone asked to reset the password for your AI Note Assistant account.\n\n"+
+						"To choose a new password, open this link within the next hour:\n\n%s/reset-password?token=%s\n\n"+
+						"If you didn't ask for this, you can ignore this email; your password won't change.\n",
+						baseURL, url.QueryEscape(token)),
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				if err := mailer.Send(ctx, msg); err != nil {
+					log.Println("Send reset email error:", err)
+				}
+			}()
+		}
+
+		tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+			"Sent":  true,
+			"Email": email,
+		})
+	}
+}
+
+// ResetPasswordHandler sets a new password using a reset link. A successful
+// reset uses up the token and signs the user out of every session.
+func ResetPasswordHandler(db *sql.DB, tokens *auth.OneTimeTokens, sessions *auth.Sessions, verifier *auth.EmailVerifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tmpl := parsePage(r, nil, "templates/reset_password.html", "templates/auth_card.html", "templates/base.html")
+
+		token := r.FormValue("token")
+		render := func(status int, errMsg string) {
+			w.WriteHeader(status)
+			tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+				"Token": token,
+				"Error": errMsg,
+			})
+		}
+		// An unusable token hides the form and only offers a new link
+		invalid := func() {
+			token = ""
+			render(http.StatusBadRequest, "This reset link is invalid or has expired.")
+		}
+
+		if _, err := tokens.Lookup(r.Context(), token, auth.PurposePasswordReset); err != nil {
+			if !errors.Is(err, auth.ErrInvalidToken) {
+				log.Println("Reset token lookup error:", err)
+			}
+			invalid()
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			render(http.StatusOK, "")
+			return
+		}
+
+		password := r.FormValue("password")
+		if len(password) < minPasswordLength {
+			render(http.StatusBadRequest, fmt.Sprintf("Password must be at least %d characters.", minPasswordLength))
+			return
+		}
+		if password != r.FormValue("confirm_password") {
+			render(http.StatusBadRequest, "Passwords do not match.")
+			return
+		}
+
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "Error resetting password", http.StatusInternalServerError)
+			return
+		}
+
+		userID, err := tokens.Consume(r.Context(), token, auth.PurposePasswordReset)
+		if err != nil {
+			if !errors.Is(err, auth.ErrInvalidToken) {
+				log.Println("Reset token consume error:", err)
+			}
+			invalid()
+			return
+		}
+
+		if _, err := db.ExecContext(r.Context(), "UPDATE users SET password = ? WHERE id = ?", string(hashed), userID); err != nil {
+			log.Println("Reset password error:", err)
+			http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+			return
+		}
+		// Following the emailed link proves the user owns the address
+		if err := verifier.MarkVerified(r.Context(), userID); err != nil {
+			log.Println("Mark verified after reset error:", err)
+		}
+		if _, err := sessions.RevokeAll(r.Context(), userID, 0); err != nil {
+			log.Println("Revoke sessions after reset error:", err)
+		}
+
+		auth.ClearCookies(w)
+		http.Redirect(w, r, "/login?reset=1", http.StatusSeeOther)
+	}
+}
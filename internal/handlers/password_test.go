@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+	"github.com/ahsanfayaz52/diaryservice/internal/mail"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type resetTest struct {
+	db       *sql.DB
+	userID   int
+	token    string
+	sessions *auth.Sessions
+	handler  http.HandlerFunc
+}
+
+func newResetTest(t *testing.T) *resetTest {
+	t.Helper()
+	conn := dbtest.New(t)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", false)
+	tokens := auth.NewOneTimeTokens(conn)
+	token, err := tokens.Issue(context.Background(), userID, auth.PurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := auth.NewSessions(conn, auth.NewJWTService("test-secret", time.Minute), time.Hour, false)
+
+	// Signed in on two devices
+	for i := 0; i < 2; i++ {
+		if err := sessions.Start(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/login", nil), userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &resetTest{
+		db:       conn,
+		userID:   userID,
+		token:    token,
+		sessions: sessions,
+		handler:  ResetPasswordHandler(conn, tokens, sessions, auth.NewEmailVerifier(conn, tokens, nil, "")),
+	}
+}
+
+func (rt *resetTest) post(password, confirm string) *httptest.ResponseRecorder {
+	form := url.Values{"token": {rt.token}, "password": {password}, "confirm_password": {confirm}}
+	req := httptest.NewRequest(http.MethodPost, "/reset-password", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	rt.handler(rec, req)
+	return rec
+}
+
+func (rt *resetTest) activeSessions(t *testing.T) int {
+	t.Helper()
+	list, err := rt.sessions.List(context.Background(), rt.userID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(list)
+}
+
+func TestResetPasswordHandler(t *testing.T) {
+	rt := newResetTest(t)
+
+	rec := rt.post("new password 1", "new password 1")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login?reset=1" {
+		t.Fatalf("status %d, location %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	var hashed string
+	var verifiedAt sql.NullTime
+	if err := rt.db.QueryRow("SELECT password, email_verified_at FROM users WHERE id = ?", rt.userID).Scan(&hashed, &verifiedAt); err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hashed), []byte("new password 1")) != nil {
+		t.Error("password was not changed")
+	}
+	if !verifiedAt.Valid {
+		t.Error("email address was not marked verified")
+	}
+	if n := rt.activeSessions(t); n != 0 {
+		t.Errorf("%d sessions still active, want 0", n)
+	}
+
+	// The link only works once
+	if rec := rt.post("another password", "another password"); rec.Code != http.StatusBadRequest {
+		t.Errorf("second reset: status %d, want 400", rec.Code)
+	}
+}
+
+func TestResetPasswordHandlerRejectedPassword(t *testing.T) {
+	rt := newResetTest(t)
+
+	for _, tt := range []struct{ password, confirm string }{
+		{"short", "short"},
+		{"new password 1", "new password 2"},
+	} {
+		if rec := rt.post(tt.password, tt.confirm); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q/%q: status %d, want 400", tt.password, tt.confirm, rec.Code)
+		}
+	}
+
+	// Nothing changed, and the link still works
+	if n := rt.activeSessions(t); n != 2 {
+		t.Errorf("%d sessions active, want 2", n)
+	}
+	if rec := rt.post("new password 1", "new password 1"); rec.Code != http.StatusSeeOther {
+		t.Errorf("valid reset: status %d, want 303", rec.Code)
+	}
+}
+
+type mailRecorder chan mail.Message
+
+func (m mailRecorder) Send(ctx context.Context, msg mail.Message) error {
+	m <- msg
+	return nil
+}
+
+func TestForgotPasswordHandler(t *testing.T) {
+	conn := dbtest.New(t)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+	sent := make(mailRecorder, 10)
+	handler := ForgotPasswordHandler(conn, auth.NewOneTimeTokens(conn), sent, "http://localhost")
+
+	post := func() *httptest.ResponseRecorder {
+		form := url.Values{"email": {" A@Example.com "}}
+		req := httptest.NewRequest(http.MethodPost, "/forgot-password", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	issued := func() int {
+		t.Helper()
+		var n int
+		if err := conn.QueryRow("SELECT COUNT(*) FROM user_tokens WHERE user_id = ? AND purpose = ?", userID, auth.PurposePasswordReset).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	first := post()
+	if first.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", first.Code)
+	}
+	select {
+	case msg := <-sent:
+		if msg.To != "a@example.com" {
+			t.Errorf("sent to %q, want the stored address", msg.To)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reset email sent")
+	}
+
+	// A second request within the minute looks the same but sends nothing
+	if rec := post(); rec.Code != http.StatusOK || rec.Body.String() != first.Body.String() {
+		t.Errorf("repeat request: status %d, body differs %t", rec.Code, rec.Body.String() != first.Body.String())
+	}
+	if n := issued(); n != 1 {
+		t.Errorf("%d tokens issued, want 1", n)
+	}
+
+	// Spread out over the day, five links are allowed and no more
+	for i := 0; i < 6; i++ {
+		if _, err := conn.Exec("UPDATE user_tokens SET created_at = ? WHERE user_id = ?", time.Now().UTC().Add(-2*time.Minute), userID); err != nil {
+			t.Fatal(err)
+		}
+		post()
+	}
+	if n := issued(); n != 5 {
+		t.Errorf("%d tokens issued, want 5", n)
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/ai"
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
+	"github.com/ahsanfayaz52/diaryservice/internal/stripe"
+	"github.com/ahsanfayaz52/diaryservice/internal/transcribe"
+	"github.com/gorilla/mux"
+)
+
+// maxChunkSize caps a single uploaded piece of a recording. Browsers send
+// a few seconds of compressed audio at a time, far less than this.
+const maxChunkSize = 5 << 20
+
+// RecordingResponse is a recording's status and, once it is done, its
+// transcript. Times are in seconds from the start of the recording.
+type RecordingResponse struct {
+	ID         int                `json:"id"`
+	Status     string             `json:"status"`
+	Error      string             `json:"error,omitempty"`
+	Duration   float64            `json:"duration"`
+	Transcript string             `json:"transcript,omitempty"`
+	Segments   []TranscriptResult `json:"segments,omitempty"`
+}
+
+// TranscriptResult is one timed segment of a transcript.
+type TranscriptResult struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+func recordingResponse(rec *models.Recording) RecordingResponse {
+	resp := RecordingResponse{
+		ID:       rec.ID,
+		Status:   rec.Status,
+		Error:    rec.Error,
+		Duration: rec.Duration.Seconds(),
+	}
+	if rec.Status == models.RecordingDone {
+		resp.Transcript = rec.Transcript()
+		for _, s := range rec.Segments {
+			resp.Segments = append(resp.Segments, TranscriptResult{Start: s.Start.Seconds(), End: s.End.Seconds(), Text: s.Text})
+		}
+	}
+	return resp
+}
+
+// CreateRecordingHandler starts a recording of the given audio type, sent
+// as {"mime_type": "audio/webm;codecs=opus"}. Each transcription counts as
+// one request against the user's AI quota, checked now so that nobody
+// records a meeting they can't get transcribed.
+func CreateRecordingHandler(db *sql.DB, stripeSvc *stripe.Service, quota *ai.Quota, recordingRepo repository.RecordingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			MimeType string `json:"mime_type"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if !transcribe.Supported(req.MimeType) || len(req.MimeType) > 100 {
+			http.Error(w, "Unsupported audio format", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		quotaDay, ok := reserveAI(w, r, db, stripeSvc, quota, userID)
+		if !ok {
+			return
+		}
+
+		recording := &models.Recording{UserID: userID, MimeType: req.MimeType, QuotaDay: quotaDay}
+		if err := recordingRepo.Create(r.Context(), recording); err != nil {
+			if err := quota.Release(r.Context(), userID, quotaDay); err != nil {
+				log.Println("AI quota error:", err)
+			}
+			log.Println("Create recording error:", err)
+			http.Error(w, "Failed to start recording", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(recordingResponse(recording))
+	}
+}
+
+// UploadRecordingChunkHandler stores the next piece of a recording, sent
+// as the raw request body to /recordings/{id}/chunks/{seq} with seq
+// counting from 0. A recording may not grow beyond maxSize bytes.
+func UploadRecordingChunkHandler(recordingRepo repository.RecordingRepository, maxSize int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		vars := mux.Vars(r)
+		recordingID, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		seq, err := strconv.Atoi(vars["seq"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxChunkSize))
+		if err != nil {
+			http.Error(w, "Chunk too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if len(data) == 0 {
+			http.Error(w, "Empty chunk", http.StatusBadRequest)
+			return
+		}
+
+		if err := recordingRepo.AppendChunk(r.Context(), userID, recordingID, seq, data, maxSize); err != nil {
+			writeRecordingError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// FinishRecordingHandler ends the upload of a recording and queues it for
+// transcription. Poll RecordingHandler for the transcript.
+func FinishRecordingHandler(recordingRepo repository.RecordingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		recordingID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err := recordingRepo.Finish(r.Context(), userID, recordingID); err != nil {
+			writeRecordingError(w, r, err)
+			return
+		}
+		recording, err := recordingRepo.Get(r.Context(), userID, recordingID)
+		if err != nil {
+			writeRecordingError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(recordingResponse(recording))
+	}
+}
+
+// RecordingHandler returns a recording's status and its transcript once
+// it has been transcribed.
+func RecordingHandler(recordingRepo repository.RecordingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		recordingID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		recording, err := recordingRepo.Get(r.Context(), userID, recordingID)
+		if err != nil {
+			writeRecordingError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recordingResponse(recording))
+	}
+}
+
+// RecordingAudioHandler plays back a recording's audio.
+func RecordingAudioHandler(recordingRepo repository.RecordingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		recordingID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		recording, err := recordingRepo.Get(r.Context(), userID, recordingID)
+		if err != nil {
+			writeRecordingError(w, r, err)
+			return
+		}
+		audio, err := recordingRepo.Audio(r.Context(), userID, recordingID)
+		if err != nil {
+			writeRecordingError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", recording.MimeType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(audio)))
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.Write(audio)
+	}
+}
+
+// attachRecordings links the recordings whose transcripts went into a
+// note, sent as recording_ids with the form, to the note once it has been
+// saved. The note is saved either way, so a failure is only logged.
+func attachRecordings(r *http.Request, recordingRepo repository.RecordingRepository, userID, noteID int) {
+	var ids []int
+	for _, v := range r.Form["recording_ids"] {
+		if id, err := strconv.Atoi(v); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if err := recordingRepo.Attach(r.Context(), userID, noteID, ids); err != nil {
+		log.Println("Attach recordings error:", err)
+	}
+}
+
+func writeRecordingError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, repository.ErrRecordingNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, repository.ErrRecordingTooLarge):
+		http.Error(w, "Recording too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, repository.ErrRecordingClosed), errors.Is(err, repository.ErrChunkOutOfOrder):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, repository.ErrRecordingEmpty):
+		http.Error(w, "Recording has no audio", http.StatusBadRequest)
+	default:
+		log.Println("Recording error:", err)
+		http.Error(w, "Failed to process recording", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/gorilla/mux"
+)
+
+// SessionsHandler lists the devices and browsers the user is signed in on.
+func SessionsHandler(sessions *auth.Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		list, err := sessions.List(r.Context(), userID, auth.GetSessionIDFromContext(r.Context()))
+		if err != nil {
+			log.Println("List sessions error:", err)
+			http.Error(w, "Failed to load sessions", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl := parsePage(r, template.FuncMap{
+			"device": deviceName,
+		}, "templates/sessions.html", "templates/base.html")
+
+		err = tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+			"Sessions":        list,
+			"Revoked":         r.URL.Query().Get("revoked"),
+			"CurrentPage":     "sessions",
+			"IsAuthenticated": true,
+		})
+		if err != nil {
+			log.Println("Template render error:", err)
+			http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		}
+	}
+}
+
+// RevokeSessionHandler signs one session out. Revoking the current session
+// is the same as logging out.
+func RevokeSessionHandler(sessions *auth.Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		sessionID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err := sessions.Revoke(r.Context(), userID, sessionID); err != nil {
+			if errors.Is(err, auth.ErrSessionNotFound) {
+				http.NotFound(w, r)
+				return
+			}
+			log.Println("Revoke session error:", err)
+			http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+			return
+		}
+
+		if sessionID == auth.GetSessionIDFromContext(r.Context()) {
+			auth.ClearCookies(w)
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/settings/sessions?revoked=1", http.StatusSeeOther)
+	}
+}
+
+// RevokeOtherSessionsHandler signs out every session except the current one.
+func RevokeOtherSessionsHandler(sessions *auth.Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		n, err := sessions.RevokeAll(r.Context(), userID, auth.GetSessionIDFromContext(r.Context()))
+		if err != nil {
+			log.Println("Revoke sessions error:", err)
+			http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/settings/sessions?revoked="+strconv.FormatInt(n, 10), http.StatusSeeOther)
+	}
+}
+
+// deviceName turns a User-Agent header into a short description such as
+// "Chrome on macOS".
+func deviceName(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+		browser = "Opera"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome/") || strings.Contains(ua, "crios/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"):
+		browser = "curl"
+	}
+
+	os := ""
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad"):
+		os = "iOS"
+	case strings.Contains(ua, "android"):
+		os = "Android"
+	case strings.Contains(ua, "windows"):
+		os = "Windows"
+	case strings.Contains(ua, "mac os"):
+		os = "macOS"
+	case strings.Contains(ua, "linux"):
+		os = "Linux"
+	}
+
+	if os == "" {
+		return browser
+	}
+	return browser + " on " + os
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+)
+
+// BeginSSOHandler sends the browser to the identity provider to sign in.
+func BeginSSOHandler(sso *auth.SSO) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authURL, err := sso.Begin(w, r)
+		if err != nil {
+			log.Println("Begin single sign-on error:", err)
+			http.Redirect(w, r, "/login?sso=failed", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// SSOCallbackHandler finishes a single sign-on login when the identity
+// provider redirects back. Two-factor authentication still applies to
+// accounts that have it enabled.
+func SSOCallbackHandler(sso *auth.SSO, sessions *auth.Sessions, twoFactor *auth.TwoFactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if e := r.URL.Query().Get("error"); e == "access_denied" {
+			http.Redirect(w, r, "/login?sso=cancelled", http.StatusSeeOther)
+			return
+		} else if e != "" {
+			log.Printf("Identity provider error: %s: %s", e, r.URL.Query().Get("error_description"))
+			http.Redirect(w, r, "/login?sso=failed", http.StatusSeeOther)
+			return
+		}
+
+		userID, err := sso.Finish(w, r)
+		if errors.Is(err, auth.ErrSSOEmailUnverified) {
+			http.Redirect(w, r, "/login?sso=unverified", http.StatusSeeOther)
+			return
+		}
+		if errors.Is(err, auth.ErrSSOAccountUnverified) {
+			http.Redirect(w, r, "/login?sso=unclaimed", http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			log.Println("Single sign-on error:", err)
+			http.Redirect(w, r, "/login?sso=failed", http.StatusSeeOther)
+			return
+		}
+
+		completeLogin(w, r, sessions, twoFactor, userID)
+	}
+}
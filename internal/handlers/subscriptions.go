@@ -7,10 +7,10 @@ import (
 	"fmt"
 	_ "fmt"
 	"github.com/ahsanfayaz52/diaryservice/internal/config"
+	"github.com/ahsanfayaz52/diaryservice/internal/db"
 	"github.com/ahsanfayaz52/diaryservice/internal/stripe"
 	"github.com/stripe/stripe-go/v76/product"
 	"github.com/stripe/stripe-go/v76/webhook"
-	"html/template"
 	"io"
 	"log"
 	"net/http"
@@ -26,10 +26,11 @@ type SubscriptionHandler struct {
 	db        *sql.DB
 	stripeSvc *stripe.Service
 	cfg       *config.Config
+	verifier  *auth.EmailVerifier
 }
 
-func NewSubscriptionHandler(db *sql.DB, stripeSvc *stripe.Service, cfg *config.Config) *SubscriptionHandler {
-	return &SubscriptionHandler{db: db, stripeSvc: stripeSvc, cfg: cfg}
+func NewSubscriptionHandler(db *sql.DB, stripeSvc *stripe.Service, cfg *config.Config, verifier *auth.EmailVerifier) *SubscriptionHandler {
+	return &SubscriptionHandler{db: db, stripeSvc: stripeSvc, cfg: cfg, verifier: verifier}
 }
 
 func (h *SubscriptionHandler) CreateCheckoutSession(w http.ResponseWriter, r *http.Request) {
@@ -39,6 +40,25 @@ func (h *SubscriptionHandler) CreateCheckoutSession(w http.ResponseWriter, r *ht
 		return
 	}
 
+	// Stripe customers are created from the account's email address, so it
+	// has to be confirmed first
+	verified, err := h.verifier.Verified(r.Context(), userID)
+	if err != nil {
+		log.Println("Verification status error:", err)
+		http.Error(w, "Failed to check account status", http.StatusInternalServerError)
+		return
+	}
+	if !verified {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":    "email_unverified",
+			"message":  "Please confirm your email address before subscribing.",
+			"redirect": "/settings/verify-email?reason=checkout",
+		})
+		return
+	}
+
 	var req struct {
 		ProductType string `json:"product_type"` // "monthly" or "annual"
 	}
@@ -49,7 +69,7 @@ func (h *SubscriptionHandler) CreateCheckoutSession(w http.ResponseWriter, r *ht
 
 	// Get user email
 	var email string
-	err := h.db.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email)
+	err = h.db.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email)
 	if err != nil {
 		http.Error(w, "Failed to get user email", http.StatusInternalServerError)
 		return
@@ -294,7 +314,8 @@ func (h *SubscriptionHandler) GetSubscriptionStatus(w http.ResponseWriter, r *ht
 	}
 
 	err := h.db.QueryRow(`SELECT u.is_active, u.plan_id, u.current_period_end, 
-		COALESCE(ul.note_count, 0), COALESCE(ul.meeting_seconds_used, 0)
+		(SELECT COUNT(*) FROM notes n WHERE n.user_id = u.id AND n.deleted_at IS NULL),
+		COALESCE(ul.meeting_seconds_used, 0)
 		FROM users u
 		LEFT JOIN user_limits ul ON u.id = ul.user_id
 		WHERE u.id = ?`, userID).Scan(
@@ -322,10 +343,12 @@ func (h *SubscriptionHandler) MeetingStart(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	_, err := h.db.Exec(`INSERT INTO user_limits (user_id, last_meeting_start) 
-					VALUES (?, NOW())
-					ON DUPLICATE KEY UPDATE last_meeting_start = NOW()`,
-		userID)
+	if err := db.EnsureUserLimits(r.Context(), h.db, userID); err != nil {
+		http.Error(w, "Failed to record meeting start", http.StatusInternalServerError)
+		return
+	}
+
+	_, err := h.db.Exec("UPDATE user_limits SET last_meeting_start = CURRENT_TIMESTAMP WHERE user_id = ?", userID)
 	if err != nil {
 		http.Error(w, "Failed to record meeting start", http.StatusInternalServerError)
 		return
@@ -415,14 +438,14 @@ func (h *SubscriptionHandler) SubscriptionPageHandler(w http.ResponseWriter, r *
 		IsActive       bool
 		PlanID         sql.NullString
 		NoteCount      int
-		MeetingMinutes int
+		MeetingSeconds int
 		CurrentEndTime sql.NullTime
 	}
 
 	err := h.db.QueryRow(`
     SELECT u.is_active, u.plan_id, 
-           COALESCE(ul.note_count, 0), 
-           FLOOR(COALESCE(ul.meeting_seconds_used, 0)/60),
+           (SELECT COUNT(*) FROM notes n WHERE n.user_id = u.id AND n.deleted_at IS NULL), 
+           COALESCE(ul.meeting_seconds_used, 0),
            u.current_period_end
     FROM users u
     LEFT JOIN user_limits ul ON u.id = ul.user_id
@@ -430,7 +453,7 @@ func (h *SubscriptionHandler) SubscriptionPageHandler(w http.ResponseWriter, r *
 		&status.IsActive,
 		&status.PlanID,
 		&status.NoteCount,
-		&status.MeetingMinutes,
+		&status.MeetingSeconds,
 		&status.CurrentEndTime,
 	)
 
@@ -450,17 +473,17 @@ func (h *SubscriptionHandler) SubscriptionPageHandler(w http.ResponseWriter, r *
 		planName = getPlanName(status.PlanID.String, h.cfg)
 	}
 
-	tmpl := template.Must(template.ParseFiles(
+	tmpl := parsePage(r, nil,
 		"templates/base.html",
 		"templates/subscription.html",
-	))
+	)
 
 	tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
 		"IsActive":               status.IsActive,
 		"PlanName":               planName,
 		"CurrentPeriodEnd":       status.CurrentEndTime.Time, // Will be zero time if NULL
 		"NoteCount":              status.NoteCount,
-		"MeetingMinutes":         status.MeetingMinutes,
+		"MeetingMinutes":         status.MeetingSeconds / 60,
 		"StripePublishableKey":   h.cfg.StripePublishableKey,
 		"StripeMonthlyProductID": h.cfg.StripeMonthlyPlanID,
 		"StripeAnnualProductID":  h.cfg.StripeAnnualPlanID,
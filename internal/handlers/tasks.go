@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
+	"github.com/gorilla/mux"
+)
+
+// Due date filters for action items
+const (
+	dueOverdue = "overdue"
+	dueToday   = "today"
+	dueWeek    = "week" // within the next seven days
+	dueNone    = "none"
+)
+
+// today returns midnight UTC of the current day, the form due dates are
+// stored in.
+func today() time.Time {
+	y, m, d := time.Now().UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// actionItemFilter reads the owner, status and due filters from a query
+// string, as in ?owner=Alice&status=open&due=week.
+func actionItemFilter(query url.Values, today time.Time) (repository.ActionItemFilter, error) {
+	filter := repository.ActionItemFilter{
+		Owner:  strings.TrimSpace(query.Get("owner")),
+		Status: query.Get("status"),
+	}
+	if filter.Status != "" && filter.Status != models.ActionItemOpen && filter.Status != models.ActionItemDone {
+		return filter, errors.New("invalid status")
+	}
+
+	switch query.Get("due") {
+	case "":
+	case dueOverdue:
+		// Done items are never overdue
+		filter.Status = models.ActionItemOpen
+		filter.DueBefore = today
+	case dueToday:
+		filter.DueFrom = today
+		filter.DueBefore = today.AddDate(0, 0, 1)
+	case dueWeek:
+		filter.DueFrom = today
+		filter.DueBefore = today.AddDate(0, 0, 7)
+	case dueNone:
+		filter.NoDueDate = true
+	default:
+		return filter, errors.New("invalid due filter")
+	}
+	return filter, nil
+}
+
+// Task is an action item with the title of the note its meeting is in.
+type Task struct {
+	models.MeetingActionItem
+	NoteTitle string
+}
+
+// TasksHandler lists the action items from the user's meeting summaries as
+// tasks, filtered like ActionItemsHandler. It shows open tasks unless asked
+// for another status.
+func TasksHandler(meetingRepo repository.MeetingRepository, noteRepo repository.NoteRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		query := r.URL.Query()
+		if _, ok := query["status"]; !ok {
+			query.Set("status", models.ActionItemOpen)
+		}
+		now := today()
+		filter, err := actionItemFilter(query, now)
+		if err != nil {
+			http.Error(w, "Invalid filter", http.StatusBadRequest)
+			return
+		}
+
+		items, err := meetingRepo.ActionItems(r.Context(), userID, filter)
+		if err != nil {
+			log.Println("List action items error:", err)
+			http.Error(w, "Failed to load tasks", http.StatusInternalServerError)
+			return
+		}
+
+		titles := make(map[int]string)
+		tasks := make([]Task, 0, len(items))
+		for _, item := range items {
+			title, ok := titles[item.NoteID]
+			if !ok {
+				note, err := noteRepo.Get(r.Context(), userID, item.NoteID)
+				if err != nil {
+					log.Println("Get note error:", err)
+				} else {
+					title = note.Title
+				}
+				titles[item.NoteID] = title
+			}
+			tasks = append(tasks, Task{MeetingActionItem: item, NoteTitle: title})
+		}
+
+		tmpl := parsePage(r, template.FuncMap{
+			// An empty value is kept, as ?status= shows tasks of any status
+			"filterLink": func(param, value string) string {
+				q := r.URL.Query()
+				q.Set(param, value)
+				return "?" + q.Encode()
+			},
+		}, "templates/tasks.html", "templates/base.html")
+
+		err = tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+			"Tasks":           tasks,
+			"Status":          query.Get("status"),
+			"Owner":           filter.Owner,
+			"Due":             query.Get("due"),
+			"Today":           now,
+			"Query":           r.URL.RawQuery,
+			"CurrentPage":     "tasks",
+			"IsAuthenticated": true,
+		})
+		if err != nil {
+			log.Println("Template render error:", err)
+			http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		}
+	}
+}
+
+// UpdateTaskStatusHandler marks a task open or done.
+func UpdateTaskStatusHandler(meetingRepo repository.MeetingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		status := r.FormValue("status")
+		if status != models.ActionItemOpen && status != models.ActionItemDone {
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+
+		if err := meetingRepo.SetActionItemStatus(r.Context(), userID, itemID, status); err != nil {
+			writeTaskError(w, r, err)
+			return
+		}
+		redirectToTasks(w, r)
+	}
+}
+
+// UpdateTaskHandler assigns a task to an owner and sets its due date, given
+// as YYYY-MM-DD; an empty date clears it.
+func UpdateTaskHandler(meetingRepo repository.MeetingRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		var due *time.Time
+		if v := r.FormValue("due_date"); v != "" {
+			t, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				http.Error(w, "Invalid due date", http.StatusBadRequest)
+				return
+			}
+			due = &t
+		}
+
+		owner := strings.TrimSpace(r.FormValue("owner"))
+		if err := meetingRepo.UpdateActionItem(r.Context(), userID, itemID, owner, due); err != nil {
+			writeTaskError(w, r, err)
+			return
+		}
+		redirectToTasks(w, r)
+	}
+}
+
+// redirectToTasks goes back to the tasks page with the filters the form was
+// sent from.
+func redirectToTasks(w http.ResponseWriter, r *http.Request) {
+	target := "/tasks"
+	if q, err := url.ParseQuery(r.FormValue("filters")); err == nil && len(q) > 0 {
+		target += "?" + q.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+func writeTaskError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, repository.ErrActionItemNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	log.Println("Task error:", err)
+	http.Error(w, "Failed to update task", http.StatusInternalServerError)
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"path/filepath"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/middleware"
+)
+
+// parsePage parses a page's templates, which include base.html, with the
+// request's CSRF token functions and any extra functions the page uses.
+// funcs may be nil.
+func parsePage(r *http.Request, funcs template.FuncMap, files ...string) *template.Template {
+	tmpl := template.New(filepath.Base(files[0])).Funcs(middleware.TemplateFuncs(r))
+	if funcs != nil {
+		tmpl = tmpl.Funcs(funcs)
+	}
+	return template.Must(tmpl.ParseFiles(files...))
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
+	"github.com/ahsanfayaz52/diaryservice/internal/stripe"
+	"github.com/gorilla/mux"
+)
+
+func TrashHandler(noteRepo repository.NoteRepository, retentionDays int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		notes, err := noteRepo.Trash(r.Context(), userID)
+		if err != nil {
+			log.Println("Trash error:", err)
+			http.Error(w, "Failed to load trash", http.StatusInternalServerError)
+			return
+		}
+
+		retention := time.Duration(retentionDays) * 24 * time.Hour
+		tmpl := parsePage(r, template.FuncMap{
+			"purgeDate": func(deletedAt time.Time) time.Time { return deletedAt.Add(retention) },
+		}, "templates/trash.html", "templates/base.html")
+
+		err = tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+			"Notes":           notes,
+			"RetentionDays":   retentionDays,
+			"CurrentPage":     "trash",
+			"IsAuthenticated": true,
+		})
+		if err != nil {
+			log.Println("Template render error:", err)
+			http.Error(w, "Failed to render template", http.StatusInternalServerError)
+		}
+	}
+}
+
+func RestoreFromTrashHandler(db *sql.DB, stripeSvc *stripe.Service, noteRepo repository.NoteRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		noteID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		// A restored note counts against the free plan limit again.
+		noteLimitExceeded, _, _, err := stripeSvc.CheckUserLimits(db, userID)
+		if err != nil {
+			http.Error(w, "Failed to check subscription status", http.StatusInternalServerError)
+			return
+		}
+		if noteLimitExceeded {
+			http.Redirect(w, r, "/subscription?limit=notes", http.StatusSeeOther)
+			return
+		}
+
+		if err := noteRepo.RestoreFromTrash(r.Context(), userID, noteID); err != nil {
+			writeNoteError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, "/notes/view/"+strconv.Itoa(noteID), http.StatusSeeOther)
+	}
+}
+
+func PurgeNoteHandler(noteRepo repository.NoteRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		noteID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err := noteRepo.Purge(r.Context(), userID, noteID); err != nil {
+			writeNoteError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, "/trash", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/totp"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// SecurityHandler shows the user's two-factor settings.
+func SecurityHandler(twoFactor *auth.TwoFactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		renderSecurityPage(w, r, twoFactor, userID, http.StatusOK, "")
+	}
+}
+
+// SetupTwoFactorHandler starts a TOTP enrollment on POST and shows the QR
+// code for the pending enrollment on GET.
+func SetupTwoFactorHandler(db *sql.DB, twoFactor *auth.TwoFactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		enabled, err := twoFactor.Enabled(r.Context(), userID)
+		if err != nil {
+			log.Println("Two-factor status error:", err)
+			http.Error(w, "Failed to load two-factor status", http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			http.Redirect(w, r, "/settings/security", http.StatusSeeOther)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if _, err := twoFactor.BeginEnrollment(r.Context(), userID); err != nil {
+				log.Println("Begin two-factor enrollment error:", err)
+				http.Error(w, "Failed to start two-factor setup", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/settings/2fa/setup", http.StatusSeeOther)
+			return
+		}
+
+		renderTwoFactorSetup(w, r, db, twoFactor, userID, http.StatusOK, "")
+	}
+}
+
+// EnableTwoFactorHandler confirms the pending enrollment with a code from
+// the authenticator and shows the recovery codes once.
+func EnableTwoFactorHandler(db *sql.DB, twoFactor *auth.TwoFactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		codes, err := twoFactor.Enable(r.Context(), userID, r.FormValue("code"))
+		switch {
+		case errors.Is(err, auth.ErrInvalidCode):
+			renderTwoFactorSetup(w, r, db, twoFactor, userID, http.StatusBadRequest, "That code didn't match. Check the time on your device and try again.")
+			return
+		case errors.Is(err, auth.ErrNoEnrollment):
+			http.Redirect(w, r, "/settings/security", http.StatusSeeOther)
+			return
+		case err != nil:
+			log.Println("Enable two-factor error:", err)
+			http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+
+		renderRecoveryCodes(w, r, codes)
+	}
+}
+
+// DisableTwoFactorHandler turns 2FA off. The user must enter their password
+// and a current code again.
+func DisableTwoFactorHandler(db *sql.DB, twoFactor *auth.TwoFactor, sessions *auth.Sessions, throttle *auth.LoginThrottle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !reauthenticate(w, r, db, twoFactor, sessions, throttle, userID, true) {
+			return
+		}
+
+		if err := twoFactor.Disable(r.Context(), userID); err != nil {
+			log.Println("Disable two-factor error:", err)
+			http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/settings/security?disabled=1", http.StatusSeeOther)
+	}
+}
+
+// RegenerateRecoveryCodesHandler replaces the recovery codes after the user
+// re-enters their password and a current code.
+func RegenerateRecoveryCodesHandler(db *sql.DB, twoFactor *auth.TwoFactor, sessions *auth.Sessions, throttle *auth.LoginThrottle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !reauthenticate(w, r, db, twoFactor, sessions, throttle, userID, true) {
+			return
+		}
+
+		codes, err := twoFactor.RegenerateRecoveryCodes(r.Context(), userID)
+		if err != nil {
+			log.Println("Regenerate recovery codes error:", err)
+			http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+			return
+		}
+		renderRecoveryCodes(w, r, codes)
+	}
+}
+
+// LoginTwoFactorHandler is the second login step for users with 2FA. The
+// session only starts once a valid code is entered.
+func LoginTwoFactorHandler(twoFactor *auth.TwoFactor, sessions *auth.Sessions, throttle *auth.LoginThrottle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := twoFactor.Challenge(r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		tmpl := parsePage(r, nil, "templates/login_2fa.html", "templates/auth_card.html", "templates/base.html")
+
+		if r.Method == http.MethodGet {
+			tmpl.ExecuteTemplate(w, "base.html", nil)
+			return
+		}
+
+		// Codes are guessed as easily as passwords, so they count towards
+		// the same limit
+		ip := sessions.ClientIP(r)
+		if err := throttle.CheckUser(r.Context(), userID, ip); err != nil {
+			if renderThrottled(w, err) {
+				tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+					"Error": throttledMessage(err),
+				})
+				return
+			}
+			log.Println("Login throttle error:", err)
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+
+		if err := twoFactor.Verify(r.Context(), userID, r.FormValue("code")); err != nil {
+			if !errors.Is(err, auth.ErrInvalidCode) {
+				log.Println("Two-factor verify error:", err)
+			}
+			if err := throttle.FailUser(r.Context(), userID, ip); err != nil {
+				log.Println("Login throttle error:", err)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+				"Error": "Invalid or already used code",
+			})
+			return
+		}
+
+		if err := throttle.ResetUser(r.Context(), userID); err != nil {
+			log.Println("Login throttle error:", err)
+		}
+		twoFactor.EndChallenge(w)
+		if err := sessions.Start(w, r, userID); err != nil {
+			log.Println("Session start error:", err)
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	}
+}
+
+// reauthenticate checks the password, and the second-factor code if
+// needCode is set, submitted with a sensitive change. Wrong guesses count
+// towards the same limit as failed logins. If the change must not go ahead
+// it renders the security page with the reason and returns false.
+func reauthenticate(w http.ResponseWriter, r *http.Request, db *sql.DB, twoFactor *auth.TwoFactor, sessions *auth.Sessions, throttle *auth.LoginThrottle, userID int, needCode bool) bool {
+	ip := sessions.ClientIP(r)
+	if err := throttle.CheckUser(r.Context(), userID, ip); err != nil {
+		if setRetryAfter(w, err) {
+			renderSecurityPage(w, r, twoFactor, userID, http.StatusTooManyRequests, throttledMessage(err))
+			return false
+		}
+		log.Println("Login throttle error:", err)
+		http.Error(w, "Failed to verify your identity", http.StatusInternalServerError)
+		return false
+	}
+
+	ok, err := checkPassword(r, db, userID, r.FormValue("password"))
+	if err != nil {
+		log.Println("Password check error:", err)
+		renderSecurityPage(w, r, twoFactor, userID, http.StatusForbidden, "Could not verify your password. Please try again.")
+		return false
+	}
+	msg := ""
+	if !ok {
+		msg = "Incorrect password."
+	} else if needCode {
+		if err := twoFactor.Verify(r.Context(), userID, r.FormValue("code")); err != nil {
+			if !errors.Is(err, auth.ErrInvalidCode) {
+				log.Println("Two-factor verify error:", err)
+			}
+			msg = "Invalid or already used code."
+		}
+	}
+	if msg != "" {
+		if err := throttle.FailUser(r.Context(), userID, ip); err != nil {
+			log.Println("Login throttle error:", err)
+		}
+		renderSecurityPage(w, r, twoFactor, userID, http.StatusForbidden, msg)
+		return false
+	}
+
+	if err := throttle.ResetUser(r.Context(), userID); err != nil {
+		log.Println("Login throttle error:", err)
+	}
+	return true
+}
+
+func renderSecurityPage(w http.ResponseWriter, r *http.Request, twoFactor *auth.TwoFactor, userID, status int, errMsg string) {
+	enabled, err := twoFactor.Enabled(r.Context(), userID)
+	if err != nil {
+		log.Println("Two-factor status error:", err)
+		http.Error(w, "Failed to load two-factor status", http.StatusInternalServerError)
+		return
+	}
+	codesLeft := 0
+	if enabled {
+		if codesLeft, err = twoFactor.RecoveryCodesLeft(r.Context(), userID); err != nil {
+			log.Println("Recovery code count error:", err)
+		}
+	}
+
+	tmpl := parsePage(r, nil, "templates/security.html", "templates/base.html")
+
+	w.WriteHeader(status)
+	err = tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+		"TwoFactorEnabled": enabled,
+		"RecoveryCodes":    codesLeft,
+		"Disabled":         r.URL.Query().Get("disabled") == "1",
+		"Error":            errMsg,
+		"CurrentPage":      "security",
+		"IsAuthenticated":  true,
+	})
+	if err != nil {
+		log.Println("Template render error:", err)
+	}
+}
+
+func renderTwoFactorSetup(w http.ResponseWriter, r *http.Request, db *sql.DB, twoFactor *auth.TwoFactor, userID, status int, errMsg string) {
+	secret, err := twoFactor.PendingSecret(r.Context(), userID)
+	if errors.Is(err, auth.ErrNoEnrollment) {
+		http.Redirect(w, r, "/settings/security", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println("Load enrollment error:", err)
+		http.Error(w, "Failed to load two-factor setup", http.StatusInternalServerError)
+		return
+	}
+
+	var email string
+	if err := db.QueryRowContext(r.Context(), "SELECT email FROM users WHERE id = ?", userID).Scan(&email); err != nil {
+		log.Println("Load user email error:", err)
+	}
+	uri := totp.URI(auth.TOTPIssuer, email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		log.Println("QR code error:", err)
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl := parsePage(r, nil, "templates/two_factor_setup.html", "templates/base.html")
+
+	w.WriteHeader(status)
+	err = tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+		"QRCode":          template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+		"Secret":          secret,
+		"URI":             uri,
+		"Error":           errMsg,
+		"CurrentPage":     "security",
+		"IsAuthenticated": true,
+	})
+	if err != nil {
+		log.Println("Template render error:", err)
+	}
+}
+
+func renderRecoveryCodes(w http.ResponseWriter, r *http.Request, codes []string) {
+	tmpl := parsePage(r, nil, "templates/recovery_codes.html", "templates/base.html")
+
+	w.Header().Set("Cache-Control", "no-store")
+	err := tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+		"Codes":           codes,
+		"CurrentPage":     "security",
+		"IsAuthenticated": true,
+	})
+	if err != nil {
+		log.Println("Template render error:", err)
+	}
+}
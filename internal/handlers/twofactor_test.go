@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestReauthenticateIsThrottled(t *testing.T) {
+	conn := dbtest.New(t)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+	hashed, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("UPDATE users SET password = ? WHERE id = ?", hashed, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	jwtService := auth.NewJWTService("test-secret", time.Minute)
+	sessions := auth.NewSessions(conn, jwtService, time.Hour, false)
+	throttle := auth.NewLoginThrottle(conn, auth.NewOneTimeTokens(conn), nil, "")
+	handler := DisableTwoFactorHandler(conn, auth.NewTwoFactor(conn, nil, jwtService), sessions, throttle)
+
+	post := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"password": {password}, "code": {"000000"}}
+		req := httptest.NewRequest(http.MethodPost, "/settings/2fa/disable", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithValue(req.Context(), auth.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// A few mistakes are free, as at sign-in
+	for i := 0; i < 5; i++ {
+		if rec := post("wrong"); rec.Code != http.StatusForbidden {
+			t.Fatalf("attempt %d: status %d, want 403", i+1, rec.Code)
+		}
+	}
+
+	// After that even the right password has to wait
+	rec := post("correct horse")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	if !strings.Contains(rec.Body.String(), "Too many failed login attempts") {
+		t.Error("page does not explain the wait")
+	}
+}
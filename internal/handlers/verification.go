@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+)
+
+// VerifyEmailHandler confirms an email address from the link sent on
+// registration. It works without being logged in.
+func VerifyEmailHandler(verifier *auth.EmailVerifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tmpl := parsePage(r, nil, "templates/verify_email.html", "templates/auth_card.html", "templates/base.html")
+
+		_, err := verifier.Confirm(r.Context(), r.URL.Query().Get("token"))
+		if err != nil {
+			if !errors.Is(err, auth.ErrInvalidToken) {
+				log.Println("Confirm email error:", err)
+			}
+			w.WriteHeader(http.StatusBadRequest)
+		}
+
+		tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+			"Verified": err == nil,
+		})
+	}
+}
+
+// VerificationPageHandler shows whether the user's address is confirmed and
+// lets them ask for another link.
+func VerificationPageHandler(verifier *auth.EmailVerifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		renderVerificationPage(w, r, verifier, userID, http.StatusOK, "")
+	}
+}
+
+// ResendVerificationHandler emails a new verification link, at most once a
+// minute and five times a day.
+func ResendVerificationHandler(verifier *auth.EmailVerifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := auth.GetUserIDFromContext(r.Context())
+		if userID == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		err := verifier.Resend(r.Context(), userID)
+		var limited *auth.RateLimitError
+		switch {
+		case err == nil, errors.Is(err, auth.ErrAlreadyVerified):
+			http.Redirect(w, r, "/settings/verify-email?sent=1", http.StatusSeeOther)
+		case errors.As(err, &limited):
+			seconds := int(math.Ceil(limited.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			msg := "You've asked for several links already. Please try again tomorrow."
+			if seconds <= 60 {
+				msg = "We've just sent you a link. Please wait " + strconv.Itoa(seconds) + " seconds before asking for another."
+			}
+			renderVerificationPage(w, r, verifier, userID, http.StatusTooManyRequests, msg)
+		default:
+			log.Println("Resend verification error:", err)
+			http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		}
+	}
+}
+
+func renderVerificationPage(w http.ResponseWriter, r *http.Request, verifier *auth.EmailVerifier, userID, status int, errMsg string) {
+	verified, err := verifier.Verified(r.Context(), userID)
+	if err != nil {
+		log.Println("Verification status error:", err)
+		http.Error(w, "Failed to load verification status", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl := parsePage(r, nil, "templates/verify_pending.html", "templates/base.html")
+
+	w.WriteHeader(status)
+	err = tmpl.ExecuteTemplate(w, "base.html", map[string]interface{}{
+		"Verified":        verified,
+		"Sent":            r.URL.Query().Get("sent") == "1",
+		"Reason":          r.URL.Query().Get("reason"),
+		"Error":           errMsg,
+		"CurrentPage":     "verify-email",
+		"IsAuthenticated": true,
+	})
+	if err != nil {
+		log.Println("Template render error:", err)
+	}
+}
@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
+)
+
+// RunIndexBackfill builds search terms for notes saved before the blind
+// index existed, or indexed under an older scheme, batch by batch until none
+// are left. It is meant to be started once at server startup.
+func RunIndexBackfill(ctx context.Context, noteRepo repository.NoteRepository, batchSize int, pause time.Duration) {
+	total := 0
+	for {
+		n, err := noteRepo.IndexPending(ctx, batchSize)
+		total += n
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Search index backfill stopped: %v", err)
+			}
+			return
+		}
+		if n == 0 {
+			if total > 0 {
+				log.Printf("Indexed %d notes for search", total)
+			}
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pause):
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
+)
+
+// RunMeetingCleanup deletes meeting summaries that were never attached to a
+// note within retention of being generated, checking once per interval
+// until ctx is cancelled.
+func RunMeetingCleanup(ctx context.Context, meetingRepo repository.MeetingRepository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := meetingRepo.PurgeUnattached(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Meeting cleanup failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d unattached meeting summaries", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
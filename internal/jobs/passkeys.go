@@ -0,0 +1,31 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+)
+
+// RunPasskeyCleanup forgets answered passkey challenges once they can no
+// longer be replayed, checking once per interval until ctx is cancelled.
+func RunPasskeyCleanup(ctx context.Context, passkeys *auth.Passkeys, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := passkeys.DeleteStale(ctx)
+		if err != nil {
+			log.Printf("Passkey challenge cleanup failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d stale passkey challenges", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/ai"
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
+	"github.com/ahsanfayaz52/diaryservice/internal/transcribe"
+)
+
+// claimGrace is added to the transcription timeout before a claimed
+// recording is taken to be abandoned, for loading the audio and saving the
+// transcript.
+const claimGrace = time.Minute
+
+// RunTranscription transcribes finished recordings one at a time, checking
+// for new ones once per interval until ctx is cancelled. Each gets up to
+// timeout; recordings claimed longer ago than that, whose server must have
+// stopped, are transcribed again. The AI request reserved for a recording
+// when it was started is given back to quota if transcription fails.
+func RunTranscription(ctx context.Context, recordingRepo repository.RecordingRepository, transcriber transcribe.Transcriber, quota *ai.Quota, timeout, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := recordingRepo.RequeueInterrupted(ctx, time.Now().Add(-timeout-claimGrace)); err != nil {
+			log.Printf("Requeue recordings failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Requeued %d interrupted transcriptions", n)
+		}
+
+		for ctx.Err() == nil {
+			recording, err := recordingRepo.ClaimPending(ctx)
+			if err != nil {
+				log.Printf("Claim recording failed: %v", err)
+				break
+			}
+			if recording == nil {
+				break
+			}
+			transcribeRecording(ctx, recordingRepo, transcriber, quota, timeout, recording)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func transcribeRecording(ctx context.Context, recordingRepo repository.RecordingRepository, transcriber transcribe.Transcriber, quota *ai.Quota, timeout time.Duration, recording *models.Recording) {
+	fail := func(err error) {
+		log.Printf("Transcribe recording %d failed: %v", recording.ID, err)
+		if err := recordingRepo.Fail(ctx, recording.ID, "Transcription failed. Please try recording again."); err != nil {
+			log.Printf("Transcribe recording %d failed: %v", recording.ID, err)
+		}
+		if err := quota.Release(ctx, recording.UserID, recording.QuotaDay); err != nil {
+			log.Printf("Transcribe recording %d: %v", recording.ID, err)
+		}
+	}
+
+	// The parent context outlives the timeout, so saving the outcome of a
+	// transcription that ran out of time still works
+	transcribeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	audio, err := recordingRepo.Audio(transcribeCtx, recording.UserID, recording.ID)
+	if err != nil {
+		fail(err)
+		return
+	}
+	transcript, err := transcriber.Transcribe(transcribeCtx, bytes.NewReader(audio), transcribe.FileName(recording.MimeType))
+	if err != nil {
+		if ctx.Err() != nil {
+			// Shutting down; it is requeued once its claim is stale
+			return
+		}
+		fail(err)
+		return
+	}
+
+	recording.Duration = transcript.Duration
+	recording.Segments = nil
+	for _, s := range transcript.Segments {
+		recording.Segments = append(recording.Segments, models.TranscriptSegment{Start: s.Start, End: s.End, Text: s.Text})
+	}
+	if err := recordingRepo.SaveTranscript(ctx, recording); err != nil {
+		fail(err)
+	}
+}
+
+// RunRecordingCleanup deletes recordings that were never attached to a
+// note within retention of being started, checking once per interval until
+// ctx is cancelled.
+func RunRecordingCleanup(ctx context.Context, recordingRepo repository.RecordingRepository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := recordingRepo.PurgeUnattached(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Recording cleanup failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d unattached recordings", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/encryption"
+	"github.com/ahsanfayaz52/diaryservice/internal/keystore"
+)
+
+// EncryptedColumn names a table column holding encrypted values.
+type EncryptedColumn struct {
+	Table  string
+	Column string
+	// IDColumn is the table's integer primary key; "id" if empty.
+	IDColumn string
+	// UserColumn, if set, names the column holding each row's owner, whose
+	// data key the value belongs under. Otherwise the master key is used.
+	UserColumn string
+}
+
+// EncryptedColumns lists every column the re-encryption job upgrades.
+var EncryptedColumns = []EncryptedColumn{
+	{Table: "user_keys", Column: "wrapped_key", IDColumn: "user_id"},
+	{Table: "user_totp", Column: "secret", IDColumn: "user_id"},
+	{Table: "notes", Column: "content", UserColumn: "user_id"},
+	{Table: "note_revisions", Column: "content", UserColumn: "user_id"},
+}
+
+// NoteMetadataTables lists the tables whose title and tags were stored in
+// plaintext before the meta_encrypted flag was added.
+var NoteMetadataTables = []string{"notes", "note_revisions"}
+
+// ReencryptProgress is reported after every batch.
+type ReencryptProgress struct {
+	Table     string
+	Column    string
+	Total     int // non-NULL values in the column when the run started
+	Scanned   int
+	Upgraded  int
+	Failed    int
+	LastRowID int
+}
+
+// Reencryptor rewrites ciphertexts in older formats, or under a key other
+// than the one they belong under, in the current format: user-owned values
+// under the owner's data key and everything else under the active master key.
+// It works through each table in primary key order, one small batch at a
+// time, and only replaces a value if it has not changed since it was read,
+// so it can run while the server keeps serving writes.
+type Reencryptor struct {
+	DB            *sql.DB
+	EncryptionSvc *encryption.Service
+	Keys          *keystore.Store
+	Columns       []EncryptedColumn
+	// MetadataTables are checked for plaintext titles and tags, which are
+	// encrypted under the owner's data key before Columns are processed.
+	MetadataTables []string
+	BatchSize      int
+	// Pause between batches keeps the job from crowding out live traffic.
+	Pause time.Duration
+	// Progress, if set, is called after every batch.
+	Progress func(ReencryptProgress)
+}
+
+// NewReencryptor returns a Reencryptor over EncryptedColumns with defaults
+// suited to running in the background of a live server.
+func NewReencryptor(db *sql.DB, encryptionSvc *encryption.Service, keys *keystore.Store) *Reencryptor {
+	return &Reencryptor{
+		DB:             db,
+		EncryptionSvc:  encryptionSvc,
+		Keys:           keys,
+		Columns:        EncryptedColumns,
+		MetadataTables: NoteMetadataTables,
+		BatchSize:      100,
+		Pause:          200 * time.Millisecond,
+	}
+}
+
+// Run upgrades every configured column and returns the totals per column.
+// Legacy AES-CFB values are among those upgraded, so once every column has
+// been worked through without a failure none remain, and Run stops the
+// service from reading that format at all.
+func (r *Reencryptor) Run(ctx context.Context) ([]ReencryptProgress, error) {
+	var results []ReencryptProgress
+	var failed int
+	for _, table := range r.MetadataTables {
+		p, err := r.runMetadata(ctx, table)
+		results = append(results, p)
+		if err != nil {
+			return results, err
+		}
+	}
+	for _, col := range r.Columns {
+		p, err := r.runColumn(ctx, col)
+		results = append(results, p)
+		if err != nil {
+			return results, err
+		}
+		failed += p.Failed
+	}
+	if failed == 0 {
+		r.EncryptionSvc.DisableLegacyCFB()
+	}
+	return results, nil
+}
+
+type encryptedRow struct {
+	id     int
+	userID int
+	value  string
+}
+
+func (r *Reencryptor) runColumn(ctx context.Context, col EncryptedColumn) (ReencryptProgress, error) {
+	p := ReencryptProgress{Table: col.Table, Column: col.Column}
+	idCol, userCol := col.IDColumn, col.UserColumn
+	if idCol == "" {
+		idCol = "id"
+	}
+	if userCol == "" {
+		userCol = "0"
+	}
+	selectBatch := fmt.Sprintf("SELECT %s, %s, %s FROM %s WHERE %s > ? AND %s IS NOT NULL ORDER BY %s LIMIT ?",
+		idCol, userCol, col.Column, col.Table, idCol, col.Column, idCol)
+	update := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ? AND %s = ?", col.Table, col.Column, idCol, col.Column)
+
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s IS NOT NULL", col.Table, col.Column)
+	if err := r.DB.QueryRowContext(ctx, countQuery).Scan(&p.Total); err != nil {
+		return p, fmt.Errorf("count %s.%s: %w", col.Table, col.Column, err)
+	}
+
+	for {
+		batch, err := r.fetch(ctx, selectBatch, p.LastRowID)
+		if err != nil {
+			return p, fmt.Errorf("read %s.%s: %w", col.Table, col.Column, err)
+		}
+		if len(batch) == 0 {
+			return p, nil
+		}
+
+		for _, row := range batch {
+			p.Scanned++
+			p.LastRowID = row.id
+			var cipher encryption.Cipher = r.EncryptionSvc
+			if col.UserColumn != "" {
+				if cipher, err = r.Keys.ForUser(ctx, row.userID); err != nil {
+					return p, fmt.Errorf("%s.%s id=%d: %w", col.Table, col.Column, row.id, err)
+				}
+			}
+			if !cipher.NeedsUpgrade(row.value) {
+				continue
+			}
+
+			plaintext, err := cipher.Decrypt(row.value)
+			if err != nil {
+				p.Failed++
+				log.Printf("Re-encrypt %s.%s id=%d: decrypt: %v", col.Table, col.Column, row.id, err)
+				continue
+			}
+			upgraded, err := cipher.Encrypt(plaintext)
+			if err != nil {
+				return p, fmt.Errorf("encrypt %s.%s id=%d: %w", col.Table, col.Column, row.id, err)
+			}
+
+			res, err := r.DB.ExecContext(ctx, update, upgraded, row.id, row.value)
+			if err != nil {
+				return p, fmt.Errorf("update %s.%s id=%d: %w", col.Table, col.Column, row.id, err)
+			}
+			// Zero rows means the value was rewritten concurrently, which
+			// already used the current format.
+			if n, _ := res.RowsAffected(); n > 0 {
+				p.Upgraded++
+			}
+		}
+
+		if r.Progress != nil {
+			r.Progress(p)
+		}
+
+		select {
+		case <-ctx.Done():
+			return p, ctx.Err()
+		case <-time.After(r.Pause):
+		}
+	}
+}
+
+// runMetadata encrypts plaintext titles and tags in table. Rows the server
+// rewrites in the meantime are already encrypted and flagged, so the update
+// only applies while the flag is still unset.
+func (r *Reencryptor) runMetadata(ctx context.Context, table string) (ReencryptProgress, error) {
+	p := ReencryptProgress{Table: table, Column: "title, tags"}
+	selectBatch := fmt.Sprintf("SELECT id, user_id, title, tags FROM %s WHERE meta_encrypted = 0 AND id > ? ORDER BY id LIMIT ?", table)
+	update := fmt.Sprintf("UPDATE %s SET title = ?, tags = ?, meta_encrypted = ? WHERE id = ? AND meta_encrypted = 0", table)
+
+	if err := r.DB.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE meta_encrypted = 0", table)).Scan(&p.Total); err != nil {
+		return p, fmt.Errorf("count %s: %w", table, err)
+	}
+
+	for {
+		batch, err := r.fetchMetadata(ctx, selectBatch, p.LastRowID)
+		if err != nil {
+			return p, fmt.Errorf("read %s: %w", table, err)
+		}
+		if len(batch) == 0 {
+			return p, nil
+		}
+
+		for _, row := range batch {
+			p.Scanned++
+			p.LastRowID = row.id
+
+			cipher, err := r.Keys.ForUser(ctx, row.userID)
+			if err != nil {
+				return p, fmt.Errorf("%s id=%d: %w", table, row.id, err)
+			}
+			title, err := cipher.Encrypt(row.title)
+			if err != nil {
+				return p, fmt.Errorf("encrypt %s id=%d: %w", table, row.id, err)
+			}
+			tags, err := cipher.Encrypt(row.tags)
+			if err != nil {
+				return p, fmt.Errorf("encrypt %s id=%d: %w", table, row.id, err)
+			}
+
+			res, err := r.DB.ExecContext(ctx, update, title, tags, true, row.id)
+			if err != nil {
+				return p, fmt.Errorf("update %s id=%d: %w", table, row.id, err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				p.Upgraded++
+			}
+		}
+
+		if r.Progress != nil {
+			r.Progress(p)
+		}
+
+		select {
+		case <-ctx.Done():
+			return p, ctx.Err()
+		case <-time.After(r.Pause):
+		}
+	}
+}
+
+type metadataRow struct {
+	id, userID  int
+	title, tags string
+}
+
+func (r *Reencryptor) fetchMetadata(ctx context.Context, query string, afterID int) ([]metadataRow, error) {
+	rows, err := r.DB.QueryContext(ctx, query, afterID, r.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []metadataRow
+	for rows.Next() {
+		var row metadataRow
+		var title, tags sql.NullString
+		if err := rows.Scan(&row.id, &row.userID, &title, &tags); err != nil {
+			return nil, err
+		}
+		row.title, row.tags = title.String, tags.String
+		batch = append(batch, row)
+	}
+	return batch, rows.Err()
+}
+
+func (r *Reencryptor) fetch(ctx context.Context, query string, afterID int) ([]encryptedRow, error) {
+	rows, err := r.DB.QueryContext(ctx, query, afterID, r.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []encryptedRow
+	for rows.Next() {
+		var row encryptedRow
+		if err := rows.Scan(&row.id, &row.userID, &row.value); err != nil {
+			return nil, err
+		}
+		batch = append(batch, row)
+	}
+	return batch, rows.Err()
+}
+
+// RunReencryption upgrades stored ciphertexts in the background and logs the
+// outcome. It is meant to be started once at server startup.
+func RunReencryption(ctx context.Context, r *Reencryptor) {
+	results, err := r.Run(ctx)
+	for _, p := range results {
+		if p.Upgraded > 0 || p.Failed > 0 {
+			log.Printf("Re-encrypted %d of %d values in %s.%s (%d failed)", p.Upgraded, p.Scanned, p.Table, p.Column, p.Failed)
+		}
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Re-encryption stopped: %v", err)
+	}
+}
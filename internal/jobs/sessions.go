@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+)
+
+// RunSessionCleanup deletes sessions that expired or were revoked more than
+// retention ago, checking once per interval until ctx is cancelled. They are
+// kept that long so a replayed refresh token is still recognised.
+func RunSessionCleanup(ctx context.Context, sessions *auth.Sessions, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := sessions.DeleteStale(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Session cleanup failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d stale sessions", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
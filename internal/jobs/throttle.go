@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+)
+
+// RunThrottleCleanup deletes failed-login counters that have not been added
+// to for retention and no longer block anyone, checking once per interval
+// until ctx is cancelled.
+func RunThrottleCleanup(ctx context.Context, throttle *auth.LoginThrottle, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := throttle.DeleteStale(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Login throttle cleanup failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d stale login throttles", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
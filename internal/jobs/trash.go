@@ -0,0 +1,31 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/repository"
+)
+
+// RunTrashPurger permanently deletes notes that have been in the trash for
+// longer than retention, checking once per interval until ctx is cancelled.
+func RunTrashPurger(ctx context.Context, noteRepo repository.NoteRepository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := noteRepo.PurgeExpired(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Trash purge failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d notes from the trash", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Package keystore manages the per-user data keys notes are encrypted with.
+package keystore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/encryption"
+)
+
+// cacheTTL bounds how long an unwrapped key is used without checking that
+// it is still stored, so a key shredded by another server process stops
+// being used within this time.
+const cacheTTL = 5 * time.Minute
+
+// ErrKeyMissing means the user has data encrypted with a data key that is
+// no longer stored, as after Shred. A new key could not read that data, so
+// ForUser refuses to create one.
+var ErrKeyMissing = errors.New("data key missing for user with encrypted data")
+
+// keyedData finds a user's rows that can only have been written under
+// their data key. Notes from before data keys were introduced are still
+// under the master key until re-encrypted, and don't count.
+var keyedData = []string{
+	"SELECT 1 FROM notes WHERE user_id = ? AND meta_encrypted = 1 LIMIT 1",
+	"SELECT 1 FROM note_revisions WHERE user_id = ? AND meta_encrypted = 1 LIMIT 1",
+	"SELECT 1 FROM meetings WHERE user_id = ? LIMIT 1",
+	// A recording holds nothing encrypted until its first chunk
+	"SELECT 1 FROM recordings WHERE user_id = ? AND chunks > 0 LIMIT 1",
+}
+
+// Store hands out each user's data key cipher. Data keys are generated on
+// first use and kept in user_keys wrapped by the master keyring; unwrapped
+// keys are cached in memory for up to cacheTTL.
+type Store struct {
+	db     *sql.DB
+	master *encryption.Service
+
+	mu    sync.Mutex
+	cache map[int]cachedKey
+}
+
+type cachedKey struct {
+	cipher  *encryption.DataKeyCipher
+	expires time.Time
+}
+
+// NewStore returns a Store whose keys are wrapped by master.
+func NewStore(db *sql.DB, master *encryption.Service) *Store {
+	return &Store{db: db, master: master, cache: map[int]cachedKey{}}
+}
+
+// ForUser returns the cipher for the user's data key, creating the key if
+// the user does not have one yet. It returns ErrKeyMissing if the key is
+// gone but data encrypted with it is not.
+func (s *Store) ForUser(ctx context.Context, userID int) (*encryption.DataKeyCipher, error) {
+	s.mu.Lock()
+	cached, ok := s.cache[userID]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.cipher, nil
+	}
+
+	wrapped, err := s.load(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err = s.checkNoKeyedData(ctx, userID); err == nil {
+			wrapped, err = s.create(ctx, userID)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.master.UnwrapKey(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key for user %d: %w", userID, err)
+	}
+	c, err := encryption.NewDataKeyCipher(key, s.master)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.cache[userID] = cachedKey{cipher: c, expires: time.Now().Add(cacheTTL)}
+	s.mu.Unlock()
+	return c, nil
+}
+
+// Shred deletes the user's data key, making everything encrypted with it
+// permanently unreadable. It is meant for account deletion. Other server
+// processes may keep using a cached copy for up to cacheTTL.
+func (s *Store) Shred(ctx context.Context, userID int) error {
+	s.mu.Lock()
+	delete(s.cache, userID)
+	s.mu.Unlock()
+
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM user_keys WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete data key: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) checkNoKeyedData(ctx context.Context, userID int) error {
+	for _, query := range keyedData {
+		var one int
+		err := s.db.QueryRowContext(ctx, query, userID).Scan(&one)
+		if err == nil {
+			return fmt.Errorf("user %d: %w", userID, ErrKeyMissing)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("check for encrypted data: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) load(ctx context.Context, userID int) (string, error) {
+	var wrapped string
+	err := s.db.QueryRowContext(ctx, "SELECT wrapped_key FROM user_keys WHERE user_id = ?", userID).Scan(&wrapped)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("load data key: %w", err)
+	}
+	return wrapped, err
+}
+
+// create stores a new data key unless another request got there first, and
+// returns whichever key ended up stored.
+func (s *Store) create(ctx context.Context, userID int) (string, error) {
+	key, err := encryption.GenerateDataKey()
+	if err != nil {
+		return "", fmt.Errorf("generate data key: %w", err)
+	}
+	wrapped, err := s.master.WrapKey(key)
+	if err != nil {
+		return "", fmt.Errorf("wrap data key: %w", err)
+	}
+
+	_, insertErr := s.db.ExecContext(ctx, `INSERT INTO user_keys (user_id, wrapped_key, created_at)
+		SELECT ?, ?, CURRENT_TIMESTAMP FROM (SELECT 1 AS one) AS seed
+		WHERE NOT EXISTS (SELECT 1 FROM user_keys WHERE user_id = ?)`, userID, wrapped, userID)
+
+	// A concurrent insert can still win the race on MySQL and fail ours on
+	// the primary key; either way the stored row is the one to use.
+	stored, err := s.load(ctx, userID)
+	if err != nil {
+		if insertErr != nil {
+			return "", fmt.Errorf("store data key: %w", insertErr)
+		}
+		return "", err
+	}
+	return stored, nil
+}
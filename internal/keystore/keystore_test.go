@@ -0,0 +1,82 @@
+package keystore_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+	"github.com/ahsanfayaz52/diaryservice/internal/keystore"
+)
+
+func addNote(t *testing.T, conn *sql.DB, userID int, metaEncrypted bool) {
+	t.Helper()
+	if _, err := conn.Exec("INSERT INTO notes (user_id, title, content, tags, meta_encrypted) VALUES (?, 'x', 'x', 'x', ?)", userID, metaEncrypted); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForUserReusesKey(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	keys := dbtest.Keys(t, conn)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+
+	c, err := keys.ForUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("ForUser: %v", err)
+	}
+	sealed, err := c.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addNote(t, conn, userID, true)
+
+	again, err := keys.ForUser(ctx, userID)
+	if err != nil {
+		t.Fatalf("second ForUser: %v", err)
+	}
+	if plain, err := again.Decrypt(sealed); err != nil || plain != "secret" {
+		t.Errorf("Decrypt = %q, %v", plain, err)
+	}
+}
+
+func TestShred(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	keys := dbtest.Keys(t, conn)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+
+	if _, err := keys.ForUser(ctx, userID); err != nil {
+		t.Fatalf("ForUser: %v", err)
+	}
+	addNote(t, conn, userID, true)
+
+	if err := keys.Shred(ctx, userID); err != nil {
+		t.Fatalf("Shred: %v", err)
+	}
+	var n int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM user_keys WHERE user_id = ?", userID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("%d keys left after Shred", n)
+	}
+
+	// A new key could not read the note, so none is made
+	if _, err := keys.ForUser(ctx, userID); !errors.Is(err, keystore.ErrKeyMissing) {
+		t.Errorf("ForUser after Shred: got %v, want ErrKeyMissing", err)
+	}
+}
+
+func TestForUserCreatesKeyForLegacyNotes(t *testing.T) {
+	conn := dbtest.New(t)
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+	// Written under the master key before data keys existed
+	addNote(t, conn, userID, false)
+
+	if _, err := dbtest.Keys(t, conn).ForUser(context.Background(), userID); err != nil {
+		t.Fatalf("ForUser: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Package mail sends transactional email such as password reset links.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+const (
+	DriverSMTP = "smtp"
+	DriverLog  = "log"
+)
+
+// Config selects and configures a Mailer.
+type Config struct {
+	Driver   string // "smtp" or "log"
+	From     string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Dir is where the log mailer also writes each message as a .eml file;
+	// empty means log only.
+	Dir string
+}
+
+// New returns the Mailer for cfg.Driver.
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Driver {
+	case DriverSMTP:
+		if cfg.Host == "" || cfg.From == "" {
+			return nil, fmt.Errorf("smtp mailer needs SMTP_HOST and MAIL_FROM")
+		}
+		return &SMTPMailer{Host: cfg.Host, Port: cfg.Port, Username: cfg.Username, Password: cfg.Password, From: cfg.From}, nil
+	case DriverLog, "":
+		return &LogMailer{From: cfg.From, Dir: cfg.Dir}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// format renders a message as RFC 5322 text.
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// validHeader rejects values that could inject extra headers.
+func validHeader(v string) bool {
+	return !strings.ContainsAny(v, "\r\n")
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/auth"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// CSRFHeader and CSRFField carry the token on fetch calls and forms.
+	CSRFHeader = "X-CSRF-Token"
+	CSRFField  = "csrf_token"
+
+	// apiPrefix is where API routes, which accept personal access tokens,
+	// are mounted.
+	apiPrefix = "/api/"
+)
+
+type csrfKey struct{}
+
+// CSRF rejects state-changing requests that don't carry the token for the
+// browser's session, so other sites can't submit forms or make calls on a
+// signed-in user's behalf. The token is an HMAC of a random secret kept in
+// auth.CSRFCookie, so it can't be forged by a site able to set cookies on
+// the domain either. The secret lasts until the browser is closed or a new
+// session starts.
+//
+// Requests to the exempt paths, such as webhooks authenticated by their own
+// signature, are let through. So are API calls made with a personal access
+// token, which the API authenticates instead of the session cookies.
+func CSRF(key string, exempt ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret := ""
+			if c, err := r.Cookie(auth.CSRFCookie); err == nil && len(c.Value) >= 32 {
+				secret = c.Value
+			} else {
+				b := make([]byte, 32)
+				if _, err := rand.Read(b); err != nil {
+					log.Println("CSRF secret error:", err)
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				secret = base64.RawURLEncoding.EncodeToString(b)
+				http.SetCookie(w, &http.Cookie{
+					Name:     auth.CSRFCookie,
+					Value:    secret,
+					HttpOnly: true,
+					Path:     "/",
+					SameSite: http.SameSiteLaxMode,
+					Secure:   r.TLS != nil,
+				})
+			}
+			token := csrfToken(key, secret)
+
+			if !safeMethod(r.Method) && !csrfExempt(r, exempt) {
+				sent := r.Header.Get(CSRFHeader)
+				if sent == "" {
+					sent = r.PostFormValue(CSRFField)
+				}
+				if !hmac.Equal([]byte(sent), []byte(token)) {
+					csrfFailed(w, r)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, token)))
+		})
+	}
+}
+
+// CSRFToken returns the token for the request's browser session.
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+// TemplateFuncs returns the functions base.html and the pages using it
+// call to include the request's CSRF token: csrfToken for the meta tag
+// read by fetch calls, and csrfField for a hidden form input.
+func TemplateFuncs(r *http.Request) template.FuncMap {
+	token := CSRFToken(r)
+	return template.FuncMap{
+		"csrfToken": func() string { return token },
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + CSRFField + `" value="` + template.HTMLEscapeString(token) + `">`)
+		},
+	}
+}
+
+func csrfToken(key, secret string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("csrf:" + secret))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func csrfExempt(r *http.Request, exempt []string) bool {
+	// An invalid token is turned away by the API before it does anything
+	if strings.HasPrefix(r.URL.Path, apiPrefix) && auth.HasAPIToken(r) {
+		return true
+	}
+	for _, path := range exempt {
+		if r.URL.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// csrfFailed answers fetch calls with JSON and form posts with a message
+// asking the user to reload, which fetches a fresh token.
+func csrfFailed(w http.ResponseWriter, r *http.Request) {
+	const message = "Your session has expired or the request didn't come from this site. Reload the page and try again."
+	if strings.Contains(r.Header.Get("Accept"), "application/json") || strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":   "csrf_failed",
+			"message": message,
+		})
+		return
+	}
+	http.Error(w, message, http.StatusForbidden)
+}
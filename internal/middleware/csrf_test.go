@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFExemptions(t *testing.T) {
+	handler := CSRF("key", "/api/subscription/webhook")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		want          int
+	}{
+		{"webhook", "/api/subscription/webhook", "", http.StatusNoContent},
+		{"api token", "/api/meeting/start", "Bearer dpat_abc", http.StatusNoContent},
+		{"api without token", "/api/meeting/start", "", http.StatusForbidden},
+		{"api other scheme", "/api/meeting/start", "Basic dpat_abc", http.StatusForbidden},
+		{"api other bearer", "/api/meeting/start", "Bearer eyJhbGciOi", http.StatusForbidden},
+		{"page with token", "/notes/new", "Bearer dpat_abc", http.StatusForbidden},
+		{"page with header", "/settings/account/delete", "anything", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	var token string
+	handler := CSRF("key")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = CSRFToken(r)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || token == "" {
+		t.Fatalf("GET set %d cookies, token %q", len(cookies), token)
+	}
+
+	for _, sent := range []string{token, "forged"} {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.AddCookie(cookies[0])
+		req.Header.Set(CSRFHeader, sent)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if want := sent == token; (rec.Code == http.StatusOK) != want {
+			t.Errorf("token %q: status %d", sent, rec.Code)
+		}
+	}
+}
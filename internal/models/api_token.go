@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// APIToken is a personal access token a user created for scripting access
+// to the API. The token itself is only shown once, when it is created.
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	Prefix     string // first characters of the token, to tell tokens apart
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+}
+
+// Expired reports whether the token can no longer be used.
+func (t APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
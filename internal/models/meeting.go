@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Action item statuses
+const (
+	ActionItemOpen = "open"
+	ActionItemDone = "done"
+)
+
+// Meeting is a structured meeting summary. NoteID is 0 until the summary
+// has been inserted into a note and the note saved.
+type Meeting struct {
+	ID           int
+	UserID       int
+	NoteID       int
+	Summary      string
+	KeyPoints    []string
+	Participants []string
+	ActionItems  []MeetingActionItem
+	Decisions    []MeetingDecision
+	FollowUps    []MeetingFollowUp
+	CreatedAt    time.Time
+}
+
+// MeetingActionItem is a task agreed in a meeting.
+type MeetingActionItem struct {
+	ID           int
+	MeetingID    int
+	NoteID       int
+	Task         string
+	Owner        string
+	Deadline     string // as said in the meeting, e.g. "next Friday"
+	DueDate      *time.Time
+	Dependencies []string
+	Status       string
+	CompletedAt  *time.Time
+}
+
+// Overdue reports whether the item is still open after the day it was due.
+// today is midnight UTC of the current day.
+func (i MeetingActionItem) Overdue(today time.Time) bool {
+	return i.Status == ActionItemOpen && i.DueDate != nil && i.DueDate.Before(today)
+}
+
+// MeetingDecision is a decision made in a meeting.
+type MeetingDecision struct {
+	ID           int
+	Description  string
+	Rationale    string
+	Alternatives []string
+}
+
+// MeetingFollowUp is a follow-up agreed at the end of a meeting.
+type MeetingFollowUp struct {
+	Action      string
+	Responsible string
+	Timeline    string
+}
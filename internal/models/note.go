@@ -12,4 +12,25 @@ type Note struct {
 	IsStarred bool
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	DeletedAt time.Time // zero unless the note is in the trash
+}
+
+// Revision sources record why a revision was written.
+const (
+	RevisionCreate  = "create"
+	RevisionEdit    = "edit"
+	RevisionRestore = "restore"
+)
+
+// NoteRevision is one saved version of a note.
+type NoteRevision struct {
+	ID           int
+	NoteID       int
+	UserID       int
+	Title        string
+	Content      string
+	Tags         string
+	Source       string
+	RestoredFrom int // revision ID this one was restored from, 0 if none
+	CreatedAt    time.Time
 }
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Passkey is a WebAuthn credential registered for passwordless login.
+type Passkey struct {
+	ID         int
+	UserID     int
+	Name       string
+	BackedUp   bool // synced to the user's password manager or cloud account
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
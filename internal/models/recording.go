@@ -0,0 +1,64 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Recording statuses, in the order a recording goes through them
+const (
+	RecordingUploading    = "uploading"
+	RecordingPending      = "pending" // uploaded, waiting to be transcribed
+	RecordingTranscribing = "transcribing"
+	RecordingDone         = "done"
+	RecordingFailed       = "failed"
+)
+
+// Recording is meeting audio uploaded from the browser and its transcript.
+// NoteID is 0 until the note the transcript went into has been saved.
+// QuotaDay is the day its transcription was counted against the user's AI
+// quota.
+type Recording struct {
+	ID            int
+	UserID        int
+	NoteID        int
+	MimeType      string
+	QuotaDay      string
+	Status        string
+	Chunks        int
+	Size          int64
+	Segments      []TranscriptSegment
+	Duration      time.Duration
+	Error         string
+	CreatedAt     time.Time
+	TranscribedAt *time.Time
+}
+
+// TranscriptSegment is a stretch of speech in a recording, timed from the
+// start of the recording.
+type TranscriptSegment struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Timestamp formats when the segment starts, as 1:05 or 1:02:05.
+func (s TranscriptSegment) Timestamp() string {
+	total := int(s.Start / time.Second)
+	h, m, sec := total/3600, total/60%60, total%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, sec)
+	}
+	return fmt.Sprintf("%d:%02d", m, sec)
+}
+
+// Transcript returns the recording's transcript as text, one segment a
+// line with the time it starts.
+func (r Recording) Transcript() string {
+	lines := make([]string, 0, len(r.Segments))
+	for _, s := range r.Segments {
+		lines = append(lines, "["+s.Timestamp()+"] "+s.Text)
+	}
+	return strings.Join(lines, "\n")
+}
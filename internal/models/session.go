@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Session is one signed-in device or browser.
+type Session struct {
+	ID         int
+	UserID     int
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	Current    bool // the session making the request
+}
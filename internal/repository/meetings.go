@@ -0,0 +1,496 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/blindindex"
+	"github.com/ahsanfayaz52/diaryservice/internal/duedate"
+	"github.com/ahsanfayaz52/diaryservice/internal/encryption"
+	"github.com/ahsanfayaz52/diaryservice/internal/keystore"
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+)
+
+var (
+	// ErrMeetingNotFound is returned when a meeting does not exist or belongs to another user.
+	ErrMeetingNotFound = errors.New("meeting not found")
+	// ErrActionItemNotFound is returned when an action item does not exist or belongs to another user.
+	ErrActionItemNotFound = errors.New("action item not found")
+)
+
+// ActionItemFilter selects action items across all of a user's meetings.
+type ActionItemFilter struct {
+	Owner  string // matched by name, ignoring case and punctuation
+	Status string // models.ActionItemOpen or models.ActionItemDone; any if empty
+	// Only items due on or after DueFrom and before DueBefore, when set
+	DueFrom   time.Time
+	DueBefore time.Time
+	NoDueDate bool // only items without a due date
+}
+
+// MeetingRepository owns structured meeting summaries, encrypted under the
+// owner's data key like the notes they belong to.
+type MeetingRepository interface {
+	// Create stores a meeting with its action items and decisions. It is
+	// not attached to a note yet.
+	Create(ctx context.Context, meeting *models.Meeting) error
+	Get(ctx context.Context, userID, meetingID int) (*models.Meeting, error)
+	// ForNote lists the meetings attached to a note, oldest first.
+	ForNote(ctx context.Context, userID, noteID int) ([]models.Meeting, error)
+	// Attach links meetings to the note they were inserted into. Meetings
+	// that belong to another user or are already attached are left alone.
+	Attach(ctx context.Context, userID, noteID int, meetingIDs []int) error
+	// ActionItems lists action items from meetings attached to notes that
+	// are not in the trash, soonest due first and those without a due date
+	// last.
+	ActionItems(ctx context.Context, userID int, filter ActionItemFilter) ([]models.MeetingActionItem, error)
+	// SetActionItemStatus marks an action item open or done.
+	SetActionItemStatus(ctx context.Context, userID, itemID int, status string) error
+	// UpdateActionItem assigns an action item to owner and sets when it is
+	// due; a nil due clears the due date.
+	UpdateActionItem(ctx context.Context, userID, itemID int, owner string, due *time.Time) error
+	// PurgeUnattached removes meetings created before cutoff that were never
+	// attached to a note, such as summaries the user discarded.
+	PurgeUnattached(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+type sqlMeetingRepository struct {
+	db   *sql.DB
+	keys *keystore.Store
+}
+
+// NewMeetingRepository returns a MeetingRepository backed by the given
+// database, encrypting with each owner's data key from keys.
+func NewMeetingRepository(db *sql.DB, keys *keystore.Store) MeetingRepository {
+	return &sqlMeetingRepository{db: db, keys: keys}
+}
+
+// sealJSON encrypts v encoded as JSON.
+func sealJSON(cipher encryption.Cipher, v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return cipher.Encrypt(string(b))
+}
+
+// openJSON decrypts a value stored by sealJSON into v.
+func openJSON(cipher encryption.Cipher, sealed string, v interface{}) error {
+	plain, err := cipher.Decrypt(sealed)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(plain), v)
+}
+
+// sealAll encrypts plaintext values in place, stopping at the first error.
+func sealAll(cipher encryption.Cipher, values ...*string) error {
+	for _, v := range values {
+		sealed, err := cipher.Encrypt(*v)
+		if err != nil {
+			return err
+		}
+		*v = sealed
+	}
+	return nil
+}
+
+// openAll decrypts values in place, stopping at the first error.
+func openAll(cipher encryption.Cipher, values ...*string) error {
+	for _, v := range values {
+		plain, err := cipher.Decrypt(*v)
+		if err != nil {
+			return err
+		}
+		*v = plain
+	}
+	return nil
+}
+
+func (r *sqlMeetingRepository) Create(ctx context.Context, meeting *models.Meeting) error {
+	cipher, err := r.keys.ForUser(ctx, meeting.UserID)
+	if err != nil {
+		return err
+	}
+	index := noteIndex(cipher)
+
+	summary := meeting.Summary
+	if err := sealAll(cipher, &summary); err != nil {
+		return fmt.Errorf("encrypt meeting: %w", err)
+	}
+	keyPoints, err := sealJSON(cipher, meeting.KeyPoints)
+	if err != nil {
+		return fmt.Errorf("encrypt meeting: %w", err)
+	}
+	participants, err := sealJSON(cipher, meeting.Participants)
+	if err != nil {
+		return fmt.Errorf("encrypt meeting: %w", err)
+	}
+	followUps, err := sealJSON(cipher, meeting.FollowUps)
+	if err != nil {
+		return fmt.Errorf("encrypt meeting: %w", err)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	res, err := tx.ExecContext(ctx, `INSERT INTO meetings (user_id, summary, key_points, participants, follow_ups, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		meeting.UserID, summary, keyPoints, participants, followUps, now)
+	if err != nil {
+		return fmt.Errorf("insert meeting: %w", err)
+	}
+	meetingID, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("read meeting id: %w", err)
+	}
+	meeting.ID = int(meetingID)
+	meeting.CreatedAt = now
+
+	for i := range meeting.ActionItems {
+		item := &meeting.ActionItems[i]
+		item.MeetingID = meeting.ID
+		if item.Status == "" {
+			item.Status = models.ActionItemOpen
+		}
+
+		task, owner, deadline := item.Task, item.Owner, item.Deadline
+		if err := sealAll(cipher, &task, &owner, &deadline); err != nil {
+			return fmt.Errorf("encrypt action item: %w", err)
+		}
+		dependencies, err := sealJSON(cipher, item.Dependencies)
+		if err != nil {
+			return fmt.Errorf("encrypt action item: %w", err)
+		}
+		if item.DueDate == nil {
+			if due, ok := duedate.Parse(item.Deadline, now); ok {
+				item.DueDate = &due
+			}
+		}
+
+		res, err := tx.ExecContext(ctx, `INSERT INTO meeting_action_items
+			(meeting_id, user_id, position, task, owner, owner_hash, deadline, due_date, dependencies, status)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			meeting.ID, meeting.UserID, i, task, owner, ownerHash(index, item.Owner), deadline, item.DueDate, dependencies, item.Status)
+		if err != nil {
+			return fmt.Errorf("insert action item: %w", err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("read action item id: %w", err)
+		}
+		item.ID = int(id)
+	}
+
+	for i := range meeting.Decisions {
+		decision := &meeting.Decisions[i]
+
+		description, rationale := decision.Description, decision.Rationale
+		if err := sealAll(cipher, &description, &rationale); err != nil {
+			return fmt.Errorf("encrypt decision: %w", err)
+		}
+		alternatives, err := sealJSON(cipher, decision.Alternatives)
+		if err != nil {
+			return fmt.Errorf("encrypt decision: %w", err)
+		}
+
+		res, err := tx.ExecContext(ctx, `INSERT INTO meeting_decisions
+			(meeting_id, user_id, position, description, rationale, alternatives)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			meeting.ID, meeting.UserID, i, description, rationale, alternatives)
+		if err != nil {
+			return fmt.Errorf("insert decision: %w", err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("read decision id: %w", err)
+		}
+		decision.ID = int(id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlMeetingRepository) Get(ctx context.Context, userID, meetingID int) (*models.Meeting, error) {
+	meetings, err := r.load(ctx, userID, "id = ?", meetingID)
+	if err != nil {
+		return nil, err
+	}
+	if len(meetings) == 0 {
+		return nil, ErrMeetingNotFound
+	}
+	return &meetings[0], nil
+}
+
+func (r *sqlMeetingRepository) ForNote(ctx context.Context, userID, noteID int) ([]models.Meeting, error) {
+	return r.load(ctx, userID, "note_id = ?", noteID)
+}
+
+// load reads the user's meetings matching where, with their action items
+// and decisions, oldest first.
+func (r *sqlMeetingRepository) load(ctx context.Context, userID int, where string, args ...interface{}) ([]models.Meeting, error) {
+	cipher, err := r.keys.ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, note_id, summary, key_points, participants, follow_ups, created_at
+		FROM meetings
+		WHERE user_id = ? AND `+where+`
+		ORDER BY created_at, id`, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("query meetings: %w", err)
+	}
+	defer rows.Close()
+
+	var meetings []models.Meeting
+	byID := map[int]int{} // meeting ID to index in meetings
+	for rows.Next() {
+		m := models.Meeting{UserID: userID}
+		var noteID sql.NullInt64
+		var keyPoints, participants, followUps string
+		if err := rows.Scan(&m.ID, &noteID, &m.Summary, &keyPoints, &participants, &followUps, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan meeting: %w", err)
+		}
+		m.NoteID = int(noteID.Int64)
+		if err := openAll(cipher, &m.Summary); err != nil {
+			return nil, fmt.Errorf("decrypt meeting %d: %w", m.ID, err)
+		}
+		if err := openJSON(cipher, keyPoints, &m.KeyPoints); err != nil {
+			return nil, fmt.Errorf("decrypt meeting %d: %w", m.ID, err)
+		}
+		if err := openJSON(cipher, participants, &m.Participants); err != nil {
+			return nil, fmt.Errorf("decrypt meeting %d: %w", m.ID, err)
+		}
+		if err := openJSON(cipher, followUps, &m.FollowUps); err != nil {
+			return nil, fmt.Errorf("decrypt meeting %d: %w", m.ID, err)
+		}
+		byID[m.ID] = len(meetings)
+		meetings = append(meetings, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(meetings) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]interface{}, 0, len(meetings))
+	for _, m := range meetings {
+		ids = append(ids, m.ID)
+	}
+
+	items, err := r.queryActionItems(ctx, cipher, "i.meeting_id IN ("+placeholders(len(ids))+")", "i.position", ids...)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		m := &meetings[byID[item.MeetingID]]
+		m.ActionItems = append(m.ActionItems, item)
+	}
+
+	rows, err = r.db.QueryContext(ctx, `
+		SELECT meeting_id, id, description, rationale, alternatives
+		FROM meeting_decisions
+		WHERE meeting_id IN (`+placeholders(len(ids))+`)
+		ORDER BY meeting_id, position`, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("query decisions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var meetingID int
+		var d models.MeetingDecision
+		var alternatives string
+		if err := rows.Scan(&meetingID, &d.ID, &d.Description, &d.Rationale, &alternatives); err != nil {
+			return nil, fmt.Errorf("scan decision: %w", err)
+		}
+		if err := openAll(cipher, &d.Description, &d.Rationale); err != nil {
+			return nil, fmt.Errorf("decrypt decision %d: %w", d.ID, err)
+		}
+		if err := openJSON(cipher, alternatives, &d.Alternatives); err != nil {
+			return nil, fmt.Errorf("decrypt decision %d: %w", d.ID, err)
+		}
+		m := &meetings[byID[meetingID]]
+		m.Decisions = append(m.Decisions, d)
+	}
+	return meetings, rows.Err()
+}
+
+func (r *sqlMeetingRepository) Attach(ctx context.Context, userID, noteID int, meetingIDs []int) error {
+	if len(meetingIDs) == 0 {
+		return nil
+	}
+	args := []interface{}{noteID, userID}
+	for _, id := range meetingIDs {
+		args = append(args, id)
+	}
+	args = append(args, noteID, userID)
+
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE meetings SET note_id = ?
+		WHERE user_id = ? AND note_id IS NULL AND id IN (`+placeholders(len(meetingIDs))+`)
+		AND EXISTS (SELECT 1 FROM notes WHERE id = ? AND user_id = ?)`, args...)
+	if err != nil {
+		return fmt.Errorf("attach meetings: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlMeetingRepository) ActionItems(ctx context.Context, userID int, filter ActionItemFilter) ([]models.MeetingActionItem, error) {
+	cipher, err := r.keys.ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	where := []string{"i.user_id = ?", "m.note_id IS NOT NULL", "n.deleted_at IS NULL"}
+	args := []interface{}{userID}
+	if filter.Status != "" {
+		where = append(where, "i.status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.Owner != "" {
+		hash := noteIndex(cipher).Name(filter.Owner)
+		if hash == "" {
+			return nil, nil
+		}
+		where = append(where, "i.owner_hash = ?")
+		args = append(args, hash)
+	}
+	if !filter.DueFrom.IsZero() {
+		where = append(where, "i.due_date >= ?")
+		args = append(args, filter.DueFrom.UTC())
+	}
+	if !filter.DueBefore.IsZero() {
+		where = append(where, "i.due_date < ?")
+		args = append(args, filter.DueBefore.UTC())
+	}
+	if filter.NoDueDate {
+		where = append(where, "i.due_date IS NULL")
+	}
+
+	return r.queryActionItems(ctx, cipher, strings.Join(where, " AND "),
+		"CASE WHEN i.due_date IS NULL THEN 1 ELSE 0 END, i.due_date, m.created_at DESC, m.id DESC, i.position", args...)
+}
+
+func (r *sqlMeetingRepository) SetActionItemStatus(ctx context.Context, userID, itemID int, status string) error {
+	var completedAt sql.NullTime
+	if status == models.ActionItemDone {
+		completedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+	// Items already in that status keep the time they were completed
+	res, err := r.db.ExecContext(ctx, "UPDATE meeting_action_items SET status = ?, completed_at = ? WHERE id = ? AND user_id = ? AND status <> ?",
+		status, completedAt, itemID, userID, status)
+	if err != nil {
+		return fmt.Errorf("set action item status: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		var exists int
+		err := r.db.QueryRowContext(ctx, "SELECT 1 FROM meeting_action_items WHERE id = ? AND user_id = ?", itemID, userID).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrActionItemNotFound
+		} else if err != nil {
+			return fmt.Errorf("set action item status: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *sqlMeetingRepository) UpdateActionItem(ctx context.Context, userID, itemID int, owner string, due *time.Time) error {
+	cipher, err := r.keys.ForUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	sealed := owner
+	if err := sealAll(cipher, &sealed); err != nil {
+		return fmt.Errorf("encrypt action item: %w", err)
+	}
+	if due != nil {
+		utc := due.UTC()
+		due = &utc
+	}
+
+	res, err := r.db.ExecContext(ctx, "UPDATE meeting_action_items SET owner = ?, owner_hash = ?, due_date = ? WHERE id = ? AND user_id = ?",
+		sealed, ownerHash(noteIndex(cipher), owner), due, itemID, userID)
+	if err != nil {
+		return fmt.Errorf("update action item: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrActionItemNotFound
+	}
+	return nil
+}
+
+// ownerHash returns the blind index digest stored for an owner's name, or
+// NULL if there is no name.
+func ownerHash(index *blindindex.Index, owner string) sql.NullString {
+	if h := index.Name(owner); h != "" {
+		return sql.NullString{String: h, Valid: true}
+	}
+	return sql.NullString{}
+}
+
+// queryActionItems reads the action items matching where in the given
+// order, both of which may refer to the items as i, their meetings as m
+// and the meetings' notes as n.
+func (r *sqlMeetingRepository) queryActionItems(ctx context.Context, cipher encryption.Cipher, where, order string, args ...interface{}) ([]models.MeetingActionItem, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT i.id, i.meeting_id, m.note_id, i.task, i.owner, i.deadline, i.due_date, i.dependencies, i.status, i.completed_at
+		FROM meeting_action_items i
+		JOIN meetings m ON m.id = i.meeting_id
+		LEFT JOIN notes n ON n.id = m.note_id
+		WHERE `+where+`
+		ORDER BY `+order, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query action items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.MeetingActionItem
+	for rows.Next() {
+		var item models.MeetingActionItem
+		var noteID sql.NullInt64
+		var dependencies string
+		var dueDate, completedAt sql.NullTime
+		if err := rows.Scan(&item.ID, &item.MeetingID, &noteID, &item.Task, &item.Owner, &item.Deadline,
+			&dueDate, &dependencies, &item.Status, &completedAt); err != nil {
+			return nil, fmt.Errorf("scan action item: %w", err)
+		}
+		item.NoteID = int(noteID.Int64)
+		if dueDate.Valid {
+			item.DueDate = &dueDate.Time
+		}
+		if completedAt.Valid {
+			item.CompletedAt = &completedAt.Time
+		}
+		if err := openAll(cipher, &item.Task, &item.Owner, &item.Deadline); err != nil {
+			return nil, fmt.Errorf("decrypt action item %d: %w", item.ID, err)
+		}
+		if err := openJSON(cipher, dependencies, &item.Dependencies); err != nil {
+			return nil, fmt.Errorf("decrypt action item %d: %w", item.ID, err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (r *sqlMeetingRepository) PurgeUnattached(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM meetings WHERE note_id IS NULL AND created_at < ?", cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("purge unattached meetings: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,840 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/blindindex"
+	"github.com/ahsanfayaz52/diaryservice/internal/db"
+	"github.com/ahsanfayaz52/diaryservice/internal/encryption"
+	"github.com/ahsanfayaz52/diaryservice/internal/keystore"
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+	"github.com/ahsanfayaz52/diaryservice/internal/textutil"
+)
+
+var (
+	// ErrNoteNotFound is returned when a note does not exist or belongs to another user.
+	ErrNoteNotFound = errors.New("note not found")
+	// ErrRevisionNotFound is returned when a revision does not belong to the note.
+	ErrRevisionNotFound = errors.New("revision not found")
+)
+
+// NoteFilter describes which notes the dashboard wants and in which order.
+type NoteFilter struct {
+	Search   string   // whole words, all of which must appear; one-letter words are ignored
+	Tags     []string // a note matches if it carries any of these tags
+	Pinned   bool
+	Starred  bool
+	SortBy   string // created_at_asc, title_asc, title_desc; newest first otherwise
+	Page     int    // 1-based
+	PageSize int
+}
+
+// NoteCounts holds the per-user totals shown in the dashboard sidebar.
+type NoteCounts struct {
+	Total   int
+	Pinned  int
+	Starred int
+}
+
+// NoteRepository owns note storage, including encryption of note titles,
+// tags and content under the owner's data key, and the note_count
+// bookkeeping in user_limits.
+type NoteRepository interface {
+	// List returns one page of notes matching the filter together with the
+	// number of notes matching it across all pages.
+	List(ctx context.Context, userID int, filter NoteFilter) ([]models.Note, int, error)
+	Counts(ctx context.Context, userID int) (NoteCounts, error)
+	Get(ctx context.Context, userID, noteID int) (*models.Note, error)
+	Create(ctx context.Context, note *models.Note) error
+	Update(ctx context.Context, note *models.Note) error
+	// Delete moves a note to the trash.
+	Delete(ctx context.Context, userID, noteID int) error
+	// TagCounts returns how many of the user's notes carry each tag.
+	TagCounts(ctx context.Context, userID int) (map[string]int, error)
+
+	// Revisions lists a note's saved versions, newest first, without content.
+	Revisions(ctx context.Context, userID, noteID int) ([]models.NoteRevision, error)
+	Revision(ctx context.Context, userID, noteID, revisionID int) (*models.NoteRevision, error)
+	// Restore copies a revision back onto the note, recording the restore as
+	// a new revision.
+	Restore(ctx context.Context, userID, noteID, revisionID int) error
+
+	// Trash lists the user's deleted notes, most recently deleted first.
+	Trash(ctx context.Context, userID int) ([]models.Note, error)
+	RestoreFromTrash(ctx context.Context, userID, noteID int) error
+	// Purge permanently removes a note that is already in the trash.
+	Purge(ctx context.Context, userID, noteID int) error
+	// PurgeExpired permanently removes every note deleted before cutoff.
+	PurgeExpired(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// IndexPending builds search terms for up to limit notes that are not
+	// yet indexed under the current blindindex.Version, and returns how
+	// many it indexed.
+	IndexPending(ctx context.Context, limit int) (int, error)
+}
+
+type sqlNoteRepository struct {
+	db   *sql.DB
+	keys *keystore.Store
+}
+
+// NewNoteRepository returns a NoteRepository backed by the given database.
+// Note content is encrypted with each owner's data key from keys.
+func NewNoteRepository(db *sql.DB, keys *keystore.Store) NoteRepository {
+	return &sqlNoteRepository{db: db, keys: keys}
+}
+
+const noteColumns = "id, user_id, title, content, tags, is_pinned, is_starred, created_at, updated_at, meta_encrypted"
+
+// scanNote reads noteColumns, followed by any extra destinations, and
+// decrypts the note.
+func scanNote(row interface{ Scan(...interface{}) error }, cipher encryption.Cipher, extra ...interface{}) (*models.Note, error) {
+	var n models.Note
+	var metaEncrypted bool
+	dest := append([]interface{}{&n.ID, &n.UserID, &n.Title, &n.Content, &n.Tags, &n.IsPinned, &n.IsStarred, &n.CreatedAt, &n.UpdatedAt, &metaEncrypted}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if n.Content, err = cipher.Decrypt(n.Content); err != nil {
+		return nil, fmt.Errorf("decrypt note %d: %w", n.ID, err)
+	}
+	if metaEncrypted {
+		if n.Title, err = cipher.Decrypt(n.Title); err != nil {
+			return nil, fmt.Errorf("decrypt note %d title: %w", n.ID, err)
+		}
+		if n.Tags, err = cipher.Decrypt(n.Tags); err != nil {
+			return nil, fmt.Errorf("decrypt note %d tags: %w", n.ID, err)
+		}
+	}
+	return &n, nil
+}
+
+// sealedNote holds the encrypted forms of a note's private fields.
+type sealedNote struct {
+	title, content, tags string
+}
+
+func sealNote(cipher encryption.Cipher, note *models.Note) (sealedNote, error) {
+	var sealed sealedNote
+	var err error
+	if sealed.title, err = cipher.Encrypt(note.Title); err != nil {
+		return sealed, fmt.Errorf("encrypt note: %w", err)
+	}
+	if sealed.content, err = cipher.Encrypt(note.Content); err != nil {
+		return sealed, fmt.Errorf("encrypt note: %w", err)
+	}
+	if sealed.tags, err = cipher.Encrypt(note.Tags); err != nil {
+		return sealed, fmt.Errorf("encrypt note: %w", err)
+	}
+	return sealed, nil
+}
+
+// splitTags turns a stored comma-separated tag list into trimmed tags.
+func splitTags(tags string) []string {
+	var out []string
+	for _, t := range strings.Split(tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// List finds matching notes through the blind index. Date orders are paged
+// in SQL; titles are only stored encrypted, so the title orders decrypt just
+// the titles of the matching notes to sort them and then load the page. Only
+// the notes on the page are decrypted in full. Notes the background indexer
+// has not reached yet do not match searches or tags.
+func (r *sqlNoteRepository) List(ctx context.Context, userID int, filter NoteFilter) ([]models.Note, int, error) {
+	cipher, err := r.keys.ForUser(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	index := noteIndex(cipher)
+
+	where := []string{"user_id = ?", "deleted_at IS NULL"}
+	args := []interface{}{userID}
+
+	if terms := index.Query(filter.Search); len(terms) > 0 {
+		where = append(where, `id IN (
+			SELECT note_id FROM note_terms
+			WHERE user_id = ? AND term_hash IN (`+placeholders(len(terms))+`)
+			GROUP BY note_id HAVING COUNT(*) = ?)`)
+		args = append(args, userID)
+		for _, t := range terms {
+			args = append(args, t)
+		}
+		args = append(args, len(terms))
+	}
+
+	if len(filter.Tags) > 0 {
+		where = append(where, `id IN (
+			SELECT note_id FROM note_terms
+			WHERE user_id = ? AND term_hash IN (`+placeholders(len(filter.Tags))+`))`)
+		args = append(args, userID)
+		for _, tag := range filter.Tags {
+			args = append(args, index.Tag(tag))
+		}
+	}
+
+	if filter.Pinned {
+		where = append(where, "is_pinned = 1")
+	}
+	if filter.Starred {
+		where = append(where, "is_starred = 1")
+	}
+	whereClause := strings.Join(where, " AND ")
+
+	page, pageSize := filter.Page, filter.PageSize
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 9
+	}
+	offset := (page - 1) * pageSize
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM notes WHERE "+whereClause, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count notes: %w", err)
+	}
+	if offset >= total {
+		return nil, total, nil
+	}
+
+	switch filter.SortBy {
+	case "title_asc", "title_desc":
+		ids, err := r.titlePage(ctx, cipher, whereClause, args, filter.SortBy == "title_desc", offset, pageSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		pageArgs := []interface{}{userID}
+		for _, id := range ids {
+			pageArgs = append(pageArgs, id)
+		}
+		notes, err := r.queryNotes(ctx, cipher, `
+			SELECT `+noteColumns+`
+			FROM notes
+			WHERE user_id = ? AND id IN (`+placeholders(len(ids))+`)`, pageArgs...)
+		if err != nil {
+			return nil, 0, err
+		}
+		position := make(map[int]int, len(ids))
+		for i, id := range ids {
+			position[id] = i
+		}
+		sort.Slice(notes, func(i, j int) bool { return position[notes[i].ID] < position[notes[j].ID] })
+		return notes, total, nil
+	default:
+		order := "created_at DESC, id DESC"
+		if filter.SortBy == "created_at_asc" {
+			order = "created_at ASC, id ASC"
+		}
+		notes, err := r.queryNotes(ctx, cipher, `
+			SELECT `+noteColumns+`
+			FROM notes
+			WHERE `+whereClause+`
+			ORDER BY `+order+`
+			LIMIT ? OFFSET ?`, append(args, pageSize, offset)...)
+		if err != nil {
+			return nil, 0, err
+		}
+		return notes, total, nil
+	}
+}
+
+// titlePage returns the IDs of one page of the notes matching where, sorted
+// by title. Notes with the same title stay newest first.
+func (r *sqlNoteRepository) titlePage(ctx context.Context, cipher encryption.Cipher, where string, args []interface{}, desc bool, offset, limit int) ([]int, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, title, meta_encrypted
+		FROM notes
+		WHERE `+where+`
+		ORDER BY created_at DESC, id DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query note titles: %w", err)
+	}
+	defer rows.Close()
+
+	type titled struct {
+		id    int
+		title string
+	}
+	var notes []titled
+	for rows.Next() {
+		var n titled
+		var metaEncrypted bool
+		if err := rows.Scan(&n.id, &n.title, &metaEncrypted); err != nil {
+			return nil, fmt.Errorf("scan note title: %w", err)
+		}
+		if metaEncrypted {
+			if n.title, err = cipher.Decrypt(n.title); err != nil {
+				return nil, fmt.Errorf("decrypt note %d title: %w", n.id, err)
+			}
+		}
+		n.title = strings.ToLower(n.title)
+		notes = append(notes, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate note titles: %w", err)
+	}
+
+	sort.SliceStable(notes, func(i, j int) bool {
+		if desc {
+			return notes[i].title > notes[j].title
+		}
+		return notes[i].title < notes[j].title
+	})
+
+	if offset > len(notes) {
+		offset = len(notes)
+	}
+	end := offset + limit
+	if end > len(notes) {
+		end = len(notes)
+	}
+	ids := make([]int, 0, end-offset)
+	for _, n := range notes[offset:end] {
+		ids = append(ids, n.id)
+	}
+	return ids, nil
+}
+
+// queryNotes runs a query selecting noteColumns and decrypts every row.
+func (r *sqlNoteRepository) queryNotes(ctx context.Context, cipher encryption.Cipher, query string, args ...interface{}) ([]models.Note, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query notes: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		n, err := scanNote(rows, cipher)
+		if err != nil {
+			return nil, fmt.Errorf("scan note: %w", err)
+		}
+		notes = append(notes, *n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate notes: %w", err)
+	}
+	return notes, nil
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// noteIndex returns the blind index for a user, keyed from their data key.
+func noteIndex(cipher *encryption.DataKeyCipher) *blindindex.Index {
+	return blindindex.New(cipher.DeriveKey("note-terms"))
+}
+
+// replaceTerms rewrites the blind index entries of one note.
+func replaceTerms(ctx context.Context, tx *sql.Tx, cipher *encryption.DataKeyCipher, note *models.Note) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM note_terms WHERE note_id = ?", note.ID); err != nil {
+		return fmt.Errorf("clear note terms: %w", err)
+	}
+
+	terms := noteIndex(cipher).Terms(note.Title, textutil.PlainText(note.Content), splitTags(note.Tags))
+	const batch = 200
+	for len(terms) > 0 {
+		n := len(terms)
+		if n > batch {
+			n = batch
+		}
+		values := strings.TrimSuffix(strings.Repeat("(?, ?, ?), ", n), ", ")
+		args := make([]interface{}, 0, 3*n)
+		for _, t := range terms[:n] {
+			args = append(args, note.ID, note.UserID, t)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO note_terms (note_id, user_id, term_hash) VALUES "+values, args...); err != nil {
+			return fmt.Errorf("insert note terms: %w", err)
+		}
+		terms = terms[n:]
+	}
+	return nil
+}
+
+func (r *sqlNoteRepository) Counts(ctx context.Context, userID int) (NoteCounts, error) {
+	var c NoteCounts
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+		       COALESCE(SUM(CASE WHEN is_pinned = 1 THEN 1 ELSE 0 END), 0),
+		       COALESCE(SUM(CASE WHEN is_starred = 1 THEN 1 ELSE 0 END), 0)
+		FROM notes WHERE user_id = ? AND deleted_at IS NULL`, userID).Scan(&c.Total, &c.Pinned, &c.Starred)
+	if err != nil {
+		return c, fmt.Errorf("count notes: %w", err)
+	}
+	return c, nil
+}
+
+func (r *sqlNoteRepository) Get(ctx context.Context, userID, noteID int) (*models.Note, error) {
+	cipher, err := r.keys.ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := scanNote(r.db.QueryRowContext(ctx, `
+		SELECT `+noteColumns+`
+		FROM notes
+		WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, noteID, userID), cipher)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoteNotFound
+		}
+		return nil, fmt.Errorf("get note: %w", err)
+	}
+	return n, nil
+}
+
+func (r *sqlNoteRepository) Create(ctx context.Context, note *models.Note) error {
+	cipher, err := r.keys.ForUser(ctx, note.UserID)
+	if err != nil {
+		return err
+	}
+	sealed, err := sealNote(cipher, note)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO notes (user_id, title, content, tags, is_pinned, is_starred, meta_encrypted, index_version, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+		note.UserID, sealed.title, sealed.content, sealed.tags, note.IsPinned, note.IsStarred, true, blindindex.Version)
+	if err != nil {
+		return fmt.Errorf("insert note: %w", err)
+	}
+
+	noteID, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("read note id: %w", err)
+	}
+	note.ID = int(noteID)
+
+	if err := replaceTerms(ctx, tx, cipher, note); err != nil {
+		return err
+	}
+
+	if err := insertRevision(ctx, tx, note, sealed, models.RevisionCreate, 0); err != nil {
+		return err
+	}
+
+	if err := db.EnsureUserLimits(ctx, tx, note.UserID); err != nil {
+		return fmt.Errorf("create user limits: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE user_limits SET note_count = note_count + 1 WHERE user_id = ?", note.UserID)
+	if err != nil {
+		return fmt.Errorf("update note count: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlNoteRepository) Update(ctx context.Context, note *models.Note) error {
+	return r.update(ctx, note, models.RevisionEdit, 0)
+}
+
+// update rewrites the note and appends a revision in one transaction so the
+// history can never fall behind the note itself.
+func (r *sqlNoteRepository) update(ctx context.Context, note *models.Note, source string, restoredFrom int) error {
+	cipher, err := r.keys.ForUser(ctx, note.UserID)
+	if err != nil {
+		return err
+	}
+	sealed, err := sealNote(cipher, note)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM notes WHERE id = ? AND user_id = ? AND deleted_at IS NULL", note.ID, note.UserID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("check note: %w", err)
+	}
+	if exists == 0 {
+		return ErrNoteNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE notes
+		SET
+			title = ?,
+			content = ?,
+			tags = ?,
+			is_pinned = ?,
+			is_starred = ?,
+			meta_encrypted = ?,
+			index_version = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ?`,
+		sealed.title, sealed.content, sealed.tags, note.IsPinned, note.IsStarred, true, blindindex.Version, note.ID, note.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("update note: %w", err)
+	}
+
+	if err := replaceTerms(ctx, tx, cipher, note); err != nil {
+		return err
+	}
+
+	if err := insertRevision(ctx, tx, note, sealed, source, restoredFrom); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+func insertRevision(ctx context.Context, tx *sql.Tx, note *models.Note, sealed sealedNote, source string, restoredFrom int) error {
+	var restored sql.NullInt64
+	if restoredFrom != 0 {
+		restored = sql.NullInt64{Int64: int64(restoredFrom), Valid: true}
+	}
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO note_revisions (note_id, user_id, title, content, tags, source, restored_from, meta_encrypted, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		note.ID, note.UserID, sealed.title, sealed.content, sealed.tags, source, restored, true)
+	if err != nil {
+		return fmt.Errorf("insert revision: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlNoteRepository) Delete(ctx context.Context, userID, noteID int) error {
+	return r.setDeleted(ctx, userID, noteID, true)
+}
+
+func (r *sqlNoteRepository) RestoreFromTrash(ctx context.Context, userID, noteID int) error {
+	return r.setDeleted(ctx, userID, noteID, false)
+}
+
+// setDeleted moves a note in or out of the trash and keeps note_count equal
+// to the number of live notes.
+func (r *sqlNoteRepository) setDeleted(ctx context.Context, userID, noteID int, deleted bool) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE notes SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND deleted_at IS NULL"
+	delta := -1
+	if !deleted {
+		query = "UPDATE notes SET deleted_at = NULL WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL"
+		delta = 1
+	}
+
+	res, err := tx.ExecContext(ctx, query, noteID, userID)
+	if err != nil {
+		return fmt.Errorf("update note: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("update note: %w", err)
+	} else if n == 0 {
+		return ErrNoteNotFound
+	}
+
+	if err := db.EnsureUserLimits(ctx, tx, userID); err != nil {
+		return fmt.Errorf("create user limits: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE user_limits
+		SET note_count = CASE WHEN note_count + ? < 0 THEN 0 ELSE note_count + ? END
+		WHERE user_id = ?`, delta, delta, userID)
+	if err != nil {
+		return fmt.Errorf("update note count: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlNoteRepository) Trash(ctx context.Context, userID int) ([]models.Note, error) {
+	cipher, err := r.keys.ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+noteColumns+`, deleted_at
+		FROM notes
+		WHERE user_id = ? AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query trash: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		var deletedAt time.Time
+		n, err := scanNote(rows, cipher, &deletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scan note: %w", err)
+		}
+		n.DeletedAt = deletedAt
+		notes = append(notes, *n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate trash: %w", err)
+	}
+	return notes, nil
+}
+
+func (r *sqlNoteRepository) Purge(ctx context.Context, userID, noteID int) error {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM notes WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL", noteID, userID)
+	if err != nil {
+		return fmt.Errorf("purge note: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNoteNotFound
+	}
+	return nil
+}
+
+func (r *sqlNoteRepository) PurgeExpired(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM notes WHERE deleted_at IS NOT NULL AND deleted_at < ?", cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("purge expired notes: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (r *sqlNoteRepository) TagCounts(ctx context.Context, userID int) (map[string]int, error) {
+	cipher, err := r.keys.ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, tags, meta_encrypted FROM notes WHERE user_id = ? AND deleted_at IS NULL", userID)
+	if err != nil {
+		return nil, fmt.Errorf("query tags: %w", err)
+	}
+	defer rows.Close()
+
+	tagMap := map[string]int{}
+	for rows.Next() {
+		var id int
+		var tagStr sql.NullString
+		var metaEncrypted bool
+		if err := rows.Scan(&id, &tagStr, &metaEncrypted); err != nil {
+			return nil, fmt.Errorf("scan tags: %w", err)
+		}
+		tags := tagStr.String
+		if metaEncrypted {
+			if tags, err = cipher.Decrypt(tags); err != nil {
+				return nil, fmt.Errorf("decrypt note %d tags: %w", id, err)
+			}
+		}
+		for _, t := range splitTags(tags) {
+			tagMap[t]++
+		}
+	}
+	return tagMap, rows.Err()
+}
+
+func (r *sqlNoteRepository) Revisions(ctx context.Context, userID, noteID int) ([]models.NoteRevision, error) {
+	cipher, err := r.keys.ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, note_id, user_id, title, tags, source, restored_from, meta_encrypted, created_at
+		FROM note_revisions
+		WHERE note_id = ? AND user_id = ?
+		  AND note_id IN (SELECT id FROM notes WHERE deleted_at IS NULL)
+		ORDER BY id DESC`, noteID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []models.NoteRevision
+	for rows.Next() {
+		var rev models.NoteRevision
+		var title, tags sql.NullString
+		var restoredFrom sql.NullInt64
+		var metaEncrypted bool
+		if err := rows.Scan(&rev.ID, &rev.NoteID, &rev.UserID, &title, &tags, &rev.Source, &restoredFrom, &metaEncrypted, &rev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan revision: %w", err)
+		}
+		rev.Title, rev.Tags, rev.RestoredFrom = title.String, tags.String, int(restoredFrom.Int64)
+		if err := openRevisionMeta(cipher, &rev, metaEncrypted); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate revisions: %w", err)
+	}
+	if len(revisions) == 0 {
+		// Every note has at least its creation revision, so an empty list
+		// means the note is missing or not the user's.
+		return nil, ErrNoteNotFound
+	}
+	return revisions, nil
+}
+
+func (r *sqlNoteRepository) Revision(ctx context.Context, userID, noteID, revisionID int) (*models.NoteRevision, error) {
+	cipher, err := r.keys.ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rev models.NoteRevision
+	var title, content, tags sql.NullString
+	var restoredFrom sql.NullInt64
+	var metaEncrypted bool
+	err = r.db.QueryRowContext(ctx, `
+		SELECT id, note_id, user_id, title, content, tags, source, restored_from, meta_encrypted, created_at
+		FROM note_revisions
+		WHERE id = ? AND note_id = ? AND user_id = ?
+		  AND note_id IN (SELECT id FROM notes WHERE deleted_at IS NULL)`, revisionID, noteID, userID,
+	).Scan(&rev.ID, &rev.NoteID, &rev.UserID, &title, &content, &tags, &rev.Source, &restoredFrom, &metaEncrypted, &rev.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRevisionNotFound
+		}
+		return nil, fmt.Errorf("get revision: %w", err)
+	}
+	rev.Title, rev.Tags, rev.RestoredFrom = title.String, tags.String, int(restoredFrom.Int64)
+
+	if err := openRevisionMeta(cipher, &rev, metaEncrypted); err != nil {
+		return nil, err
+	}
+	if rev.Content, err = cipher.Decrypt(content.String); err != nil {
+		return nil, fmt.Errorf("decrypt revision %d: %w", rev.ID, err)
+	}
+	return &rev, nil
+}
+
+// openRevisionMeta decrypts a revision's title and tags if they were stored
+// encrypted.
+func openRevisionMeta(cipher encryption.Cipher, rev *models.NoteRevision, metaEncrypted bool) error {
+	if !metaEncrypted {
+		return nil
+	}
+	var err error
+	if rev.Title, err = cipher.Decrypt(rev.Title); err != nil {
+		return fmt.Errorf("decrypt revision %d title: %w", rev.ID, err)
+	}
+	if rev.Tags, err = cipher.Decrypt(rev.Tags); err != nil {
+		return fmt.Errorf("decrypt revision %d tags: %w", rev.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlNoteRepository) Restore(ctx context.Context, userID, noteID, revisionID int) error {
+	rev, err := r.Revision(ctx, userID, noteID, revisionID)
+	if err != nil {
+		return err
+	}
+	current, err := r.Get(ctx, userID, noteID)
+	if err != nil {
+		return err
+	}
+
+	// Pinned and starred are not versioned; keep whatever the note has now.
+	current.Title = rev.Title
+	current.Content = rev.Content
+	current.Tags = rev.Tags
+	return r.update(ctx, current, models.RevisionRestore, rev.ID)
+}
+
+func (r *sqlNoteRepository) IndexPending(ctx context.Context, limit int) (int, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, user_id FROM notes WHERE index_version < ? ORDER BY id LIMIT ?", blindindex.Version, limit)
+	if err != nil {
+		return 0, fmt.Errorf("query unindexed notes: %w", err)
+	}
+	type pending struct{ id, userID int }
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.userID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan unindexed note: %w", err)
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate unindexed notes: %w", err)
+	}
+
+	indexed := 0
+	for _, p := range batch {
+		ok, err := r.indexNote(ctx, p.userID, p.id)
+		if err != nil {
+			return indexed, err
+		}
+		if ok {
+			indexed++
+		}
+	}
+	return indexed, nil
+}
+
+// indexNote claims an unindexed note by bumping its index_version before
+// reading it, so a concurrent save either finishes first (and the note is
+// skipped) or waits for this transaction and rewrites the terms itself.
+func (r *sqlNoteRepository) indexNote(ctx context.Context, userID, noteID int) (bool, error) {
+	cipher, err := r.keys.ForUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE notes SET index_version = ? WHERE id = ? AND index_version < ?", blindindex.Version, noteID, blindindex.Version)
+	if err != nil {
+		return false, fmt.Errorf("claim note %d: %w", noteID, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	n, err := scanNote(tx.QueryRowContext(ctx, "SELECT "+noteColumns+" FROM notes WHERE id = ?", noteID), cipher)
+	if err != nil {
+		return false, fmt.Errorf("read note %d: %w", noteID, err)
+	}
+	if err := replaceTerms(ctx, tx, cipher, n); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit transaction: %w", err)
+	}
+	return true, nil
+}
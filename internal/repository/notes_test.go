@@ -0,0 +1,286 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+)
+
+type noteTest struct {
+	t      *testing.T
+	db     *sql.DB
+	repo   NoteRepository
+	userID int
+}
+
+func newNoteTest(t *testing.T) *noteTest {
+	conn := dbtest.New(t)
+	return &noteTest{
+		t:      t,
+		db:     conn,
+		repo:   NewNoteRepository(conn, dbtest.Keys(t, conn)),
+		userID: dbtest.CreateUser(t, conn, "a@example.com", true),
+	}
+}
+
+func (nt *noteTest) create(title, content, tags string) *models.Note {
+	nt.t.Helper()
+	note := &models.Note{UserID: nt.userID, Title: title, Content: content, Tags: tags}
+	if err := nt.repo.Create(context.Background(), note); err != nil {
+		nt.t.Fatalf("Create: %v", err)
+	}
+	return note
+}
+
+func (nt *noteTest) list(t *testing.T, filter NoteFilter) ([]string, int) {
+	t.Helper()
+	notes, total, err := nt.repo.List(context.Background(), nt.userID, filter)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var titles []string
+	for _, n := range notes {
+		titles = append(titles, n.Title)
+	}
+	return titles, total
+}
+
+func (nt *noteTest) noteCount() int {
+	nt.t.Helper()
+	var n int
+	if err := nt.db.QueryRow("SELECT note_count FROM user_limits WHERE user_id = ?", nt.userID).Scan(&n); err != nil {
+		nt.t.Fatal(err)
+	}
+	return n
+}
+
+func TestNoteCreateEncrypts(t *testing.T) {
+	nt := newNoteTest(t)
+	ctx := context.Background()
+	note := nt.create("Secret plans", "<p>Meet at noon</p>", "work, ideas")
+
+	var title, content, tags string
+	err := nt.db.QueryRow("SELECT title, content, tags FROM notes WHERE id = ?", note.ID).Scan(&title, &content, &tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stored := range []string{title, content, tags} {
+		if strings.Contains(stored, "Secret") || strings.Contains(stored, "noon") || strings.Contains(stored, "work") {
+			t.Errorf("stored in the clear: %q", stored)
+		}
+	}
+
+	got, err := nt.repo.Get(ctx, nt.userID, note.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Title != note.Title || got.Content != note.Content || got.Tags != note.Tags {
+		t.Errorf("Get = %q, %q, %q", got.Title, got.Content, got.Tags)
+	}
+	nt.create("Second", "", "")
+	if n := nt.noteCount(); n != 2 {
+		t.Errorf("note_count = %d, want 2", n)
+	}
+
+	other := dbtest.CreateUser(t, nt.db, "b@example.com", true)
+	if _, err := nt.repo.Get(ctx, other, note.ID); !errors.Is(err, ErrNoteNotFound) {
+		t.Errorf("another user's Get: got %v, want ErrNoteNotFound", err)
+	}
+}
+
+func TestNoteUpdateAndRevisions(t *testing.T) {
+	nt := newNoteTest(t)
+	ctx := context.Background()
+	note := nt.create("Draft", "first", "")
+
+	note.Title, note.Content, note.IsPinned = "Final", "second", true
+	if err := nt.repo.Update(ctx, note); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	got, err := nt.repo.Get(ctx, nt.userID, note.ID)
+	if err != nil || got.Title != "Final" || got.Content != "second" || !got.IsPinned {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+	if c, err := nt.repo.Counts(ctx, nt.userID); err != nil || c != (NoteCounts{Total: 1, Pinned: 1}) {
+		t.Errorf("Counts = %+v, %v", c, err)
+	}
+
+	revisions, err := nt.repo.Revisions(ctx, nt.userID, note.ID)
+	if err != nil {
+		t.Fatalf("Revisions: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Source != models.RevisionEdit || revisions[1].Source != models.RevisionCreate {
+		t.Fatalf("Revisions = %+v", revisions)
+	}
+	first, err := nt.repo.Revision(ctx, nt.userID, note.ID, revisions[1].ID)
+	if err != nil || first.Title != "Draft" || first.Content != "first" {
+		t.Fatalf("Revision = %+v, %v", first, err)
+	}
+
+	if err := nt.repo.Restore(ctx, nt.userID, note.ID, first.ID); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	got, err = nt.repo.Get(ctx, nt.userID, note.ID)
+	if err != nil || got.Title != "Draft" || got.Content != "first" || !got.IsPinned {
+		t.Fatalf("after Restore, Get = %+v, %v", got, err)
+	}
+	revisions, err = nt.repo.Revisions(ctx, nt.userID, note.ID)
+	if err != nil || len(revisions) != 3 || revisions[0].Source != models.RevisionRestore || revisions[0].RestoredFrom != first.ID {
+		t.Fatalf("after Restore, Revisions = %+v, %v", revisions, err)
+	}
+
+	other := &models.Note{ID: note.ID, UserID: dbtest.CreateUser(t, nt.db, "b@example.com", true), Title: "Mine"}
+	if err := nt.repo.Update(ctx, other); !errors.Is(err, ErrNoteNotFound) {
+		t.Errorf("another user's Update: got %v, want ErrNoteNotFound", err)
+	}
+	if _, err := nt.repo.Revisions(ctx, other.UserID, note.ID); !errors.Is(err, ErrNoteNotFound) {
+		t.Errorf("another user's Revisions: got %v, want ErrNoteNotFound", err)
+	}
+}
+
+func TestNoteDeleteAndTrash(t *testing.T) {
+	nt := newNoteTest(t)
+	ctx := context.Background()
+	note := nt.create("Old", "text", "")
+	revisions, err := nt.repo.Revisions(ctx, nt.userID, note.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := nt.repo.Delete(ctx, nt.userID, note.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := nt.repo.Get(ctx, nt.userID, note.ID); !errors.Is(err, ErrNoteNotFound) {
+		t.Errorf("Get after Delete: got %v, want ErrNoteNotFound", err)
+	}
+	if _, err := nt.repo.Revisions(ctx, nt.userID, note.ID); !errors.Is(err, ErrNoteNotFound) {
+		t.Errorf("Revisions after Delete: got %v, want ErrNoteNotFound", err)
+	}
+	if _, err := nt.repo.Revision(ctx, nt.userID, note.ID, revisions[0].ID); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("Revision after Delete: got %v, want ErrRevisionNotFound", err)
+	}
+	if err := nt.repo.Delete(ctx, nt.userID, note.ID); !errors.Is(err, ErrNoteNotFound) {
+		t.Errorf("second Delete: got %v, want ErrNoteNotFound", err)
+	}
+	if n := nt.noteCount(); n != 0 {
+		t.Errorf("note_count = %d, want 0", n)
+	}
+
+	trash, err := nt.repo.Trash(ctx, nt.userID)
+	if err != nil || len(trash) != 1 || trash[0].Title != "Old" || trash[0].DeletedAt.IsZero() {
+		t.Fatalf("Trash = %+v, %v", trash, err)
+	}
+
+	if err := nt.repo.RestoreFromTrash(ctx, nt.userID, note.ID); err != nil {
+		t.Fatalf("RestoreFromTrash: %v", err)
+	}
+	if n := nt.noteCount(); n != 1 {
+		t.Errorf("note_count after restore = %d, want 1", n)
+	}
+	if err := nt.repo.Purge(ctx, nt.userID, note.ID); !errors.Is(err, ErrNoteNotFound) {
+		t.Errorf("Purge of a live note: got %v, want ErrNoteNotFound", err)
+	}
+
+	if err := nt.repo.Delete(ctx, nt.userID, note.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := nt.repo.Purge(ctx, nt.userID, note.ID); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if trash, err := nt.repo.Trash(ctx, nt.userID); err != nil || len(trash) != 0 {
+		t.Errorf("Trash after Purge = %+v, %v", trash, err)
+	}
+}
+
+func TestNoteListSearch(t *testing.T) {
+	nt := newNoteTest(t)
+	nt.create("Budget review", "Numbers for the quarterly meeting", "work")
+	nt.create("Shopping", "Milk and eggs", "home")
+	nt.create("Team meeting", "Agenda and <b>budget</b> notes", "work, ideas")
+
+	tests := []struct {
+		name   string
+		filter NoteFilter
+		want   []string
+	}{
+		{"one word", NoteFilter{Search: "meeting", SortBy: "title_asc"}, []string{"Budget review", "Team meeting"}},
+		{"every word", NoteFilter{Search: "budget agenda"}, []string{"Team meeting"}},
+		{"case and markup", NoteFilter{Search: "BUDGET", SortBy: "title_asc"}, []string{"Budget review", "Team meeting"}},
+		{"whole words", NoteFilter{Search: "shop"}, nil},
+		{"no match", NoteFilter{Search: "holiday"}, nil},
+		{"tag", NoteFilter{Tags: []string{"home"}}, []string{"Shopping"}},
+		{"any tag", NoteFilter{Tags: []string{"home", "ideas"}, SortBy: "title_asc"}, []string{"Shopping", "Team meeting"}},
+		{"search and tag", NoteFilter{Search: "budget", Tags: []string{"ideas"}}, []string{"Team meeting"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total := nt.list(t, tt.filter)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || total != len(tt.want) {
+				t.Errorf("got %q (total %d), want %q", got, total, tt.want)
+			}
+		})
+	}
+
+	tags, err := nt.repo.TagCounts(context.Background(), nt.userID)
+	if err != nil || tags["work"] != 2 || tags["home"] != 1 || tags["ideas"] != 1 {
+		t.Errorf("TagCounts = %v, %v", tags, err)
+	}
+}
+
+func TestNoteListSortAndPage(t *testing.T) {
+	nt := newNoteTest(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, title := range []string{"banana", "Apple", "cherry"} {
+		note := nt.create(title, "", "")
+		at := base.Add(time.Duration(i) * time.Hour)
+		if _, err := nt.db.Exec("UPDATE notes SET created_at = ?, updated_at = ? WHERE id = ?", at, at, note.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		filter NoteFilter
+		want   []string
+	}{
+		{NoteFilter{}, []string{"cherry", "Apple", "banana"}},
+		{NoteFilter{SortBy: "created_at_asc"}, []string{"banana", "Apple", "cherry"}},
+		{NoteFilter{SortBy: "title_asc"}, []string{"Apple", "banana", "cherry"}},
+		{NoteFilter{SortBy: "title_desc"}, []string{"cherry", "banana", "Apple"}},
+		{NoteFilter{SortBy: "title_asc", Page: 2, PageSize: 2}, []string{"cherry"}},
+		{NoteFilter{Page: 3, PageSize: 2}, nil},
+	}
+	for _, tt := range tests {
+		got, total := nt.list(t, tt.filter)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || total != 3 {
+			t.Errorf("%+v: got %q (total %d), want %q", tt.filter, got, total, tt.want)
+		}
+	}
+}
+
+func TestNoteListDecryptsOnlyThePage(t *testing.T) {
+	nt := newNoteTest(t)
+	broken := nt.create("banana", "", "")
+	nt.create("Apple", "", "")
+	nt.create("cherry", "", "")
+	if _, err := nt.db.Exec("UPDATE notes SET content = 'not ciphertext!' WHERE id = ?", broken.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filter := range []NoteFilter{
+		{Page: 1, PageSize: 2},
+		{SortBy: "title_asc", Page: 1, PageSize: 1},
+	} {
+		if _, _, err := nt.repo.List(context.Background(), nt.userID, filter); err != nil {
+			t.Errorf("%+v: List: %v", filter, err)
+		}
+	}
+	if _, _, err := nt.repo.List(context.Background(), nt.userID, NoteFilter{SortBy: "created_at_asc", Page: 1, PageSize: 1}); err == nil {
+		t.Error("List of the page with the unreadable note succeeded")
+	}
+}
@@ -0,0 +1,391 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/keystore"
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+)
+
+var (
+	// ErrRecordingNotFound is returned when a recording does not exist or belongs to another user.
+	ErrRecordingNotFound = errors.New("recording not found")
+	// ErrRecordingClosed is returned when adding audio to a recording that
+	// has already been finished.
+	ErrRecordingClosed = errors.New("recording already finished")
+	// ErrRecordingTooLarge is returned when a chunk would take a recording
+	// over the size limit.
+	ErrRecordingTooLarge = errors.New("recording too large")
+	// ErrChunkOutOfOrder is returned when a chunk skips ahead of the ones
+	// uploaded so far.
+	ErrChunkOutOfOrder = errors.New("recording chunk out of order")
+	// ErrRecordingEmpty is returned when finishing a recording with no audio.
+	ErrRecordingEmpty = errors.New("recording has no audio")
+)
+
+// RecordingRepository owns meeting audio and its transcripts, encrypted
+// under the owner's data key.
+type RecordingRepository interface {
+	// Create starts a recording with no audio yet.
+	Create(ctx context.Context, recording *models.Recording) error
+	// AppendChunk adds the next piece of audio, numbered from 0. Sending a
+	// chunk that was already stored again does nothing, so uploads can be
+	// retried. The recording may not grow beyond maxSize bytes.
+	AppendChunk(ctx context.Context, userID, recordingID, seq int, data []byte, maxSize int64) error
+	// Finish marks an uploaded recording as ready to be transcribed.
+	Finish(ctx context.Context, userID, recordingID int) error
+	Get(ctx context.Context, userID, recordingID int) (*models.Recording, error)
+	// ForNote lists the recordings attached to a note, oldest first.
+	ForNote(ctx context.Context, userID, noteID int) ([]models.Recording, error)
+	// Attach links recordings to the note their transcripts went into.
+	// Recordings that belong to another user or are already attached are
+	// left alone.
+	Attach(ctx context.Context, userID, noteID int, recordingIDs []int) error
+	// Audio returns a recording's audio, its chunks joined in order.
+	Audio(ctx context.Context, userID, recordingID int) ([]byte, error)
+	// ClaimPending takes the oldest recording waiting to be transcribed and
+	// marks it as being transcribed, or returns nil if there is none.
+	ClaimPending(ctx context.Context) (*models.Recording, error)
+	// SaveTranscript stores the transcript of a claimed recording.
+	SaveTranscript(ctx context.Context, recording *models.Recording) error
+	// Fail records why a claimed recording could not be transcribed.
+	Fail(ctx context.Context, recordingID int, reason string) error
+	// RequeueInterrupted puts recordings claimed before cutoff and still not
+	// transcribed, such as by a server that was restarted, back in the queue.
+	RequeueInterrupted(ctx context.Context, cutoff time.Time) (int64, error)
+	// PurgeUnattached removes recordings created before cutoff that were
+	// never attached to a note, except those waiting for or in the middle
+	// of transcription.
+	PurgeUnattached(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+type sqlRecordingRepository struct {
+	db   *sql.DB
+	keys *keystore.Store
+}
+
+// NewRecordingRepository returns a RecordingRepository backed by the given
+// database, encrypting with each owner's data key from keys.
+func NewRecordingRepository(db *sql.DB, keys *keystore.Store) RecordingRepository {
+	return &sqlRecordingRepository{db: db, keys: keys}
+}
+
+const recordingColumns = "id, user_id, note_id, mime_type, quota_day, status, chunks, size, transcript, duration_ms, error, created_at, transcribed_at"
+
+func (r *sqlRecordingRepository) Create(ctx context.Context, recording *models.Recording) error {
+	now := time.Now().UTC()
+	res, err := r.db.ExecContext(ctx, "INSERT INTO recordings (user_id, mime_type, quota_day, status, created_at) VALUES (?, ?, ?, ?, ?)",
+		recording.UserID, recording.MimeType, recording.QuotaDay, models.RecordingUploading, now)
+	if err != nil {
+		return fmt.Errorf("insert recording: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("read recording id: %w", err)
+	}
+	recording.ID = int(id)
+	recording.Status = models.RecordingUploading
+	recording.CreatedAt = now
+	return nil
+}
+
+func (r *sqlRecordingRepository) AppendChunk(ctx context.Context, userID, recordingID, seq int, data []byte, maxSize int64) error {
+	cipher, err := r.keys.ForUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	sealed, err := cipher.Encrypt(string(data))
+	if err != nil {
+		return fmt.Errorf("encrypt recording chunk: %w", err)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Claiming the sequence number first keeps concurrent uploads in order
+	size := int64(len(data))
+	res, err := tx.ExecContext(ctx, `
+		UPDATE recordings SET chunks = chunks + 1, size = size + ?
+		WHERE id = ? AND user_id = ? AND status = ? AND chunks = ? AND size + ? <= ?`,
+		size, recordingID, userID, models.RecordingUploading, seq, size, maxSize)
+	if err != nil {
+		return fmt.Errorf("update recording: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("update recording: %w", err)
+	} else if n == 0 {
+		return r.appendError(ctx, tx, userID, recordingID, seq)
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO recording_chunks (recording_id, seq, data) VALUES (?, ?, ?)",
+		recordingID, seq, sealed); err != nil {
+		return fmt.Errorf("insert recording chunk: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// appendError works out why a chunk could not be added, returning nil for
+// a chunk that is already stored.
+func (r *sqlRecordingRepository) appendError(ctx context.Context, tx *sql.Tx, userID, recordingID, seq int) error {
+	var status string
+	var chunks int
+	err := tx.QueryRowContext(ctx, "SELECT status, chunks FROM recordings WHERE id = ? AND user_id = ?",
+		recordingID, userID).Scan(&status, &chunks)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrRecordingNotFound
+	case err != nil:
+		return fmt.Errorf("read recording: %w", err)
+	case seq < chunks:
+		return nil
+	case status != models.RecordingUploading:
+		return ErrRecordingClosed
+	case seq > chunks:
+		return ErrChunkOutOfOrder
+	default:
+		return ErrRecordingTooLarge
+	}
+}
+
+func (r *sqlRecordingRepository) Finish(ctx context.Context, userID, recordingID int) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE recordings SET status = ? WHERE id = ? AND user_id = ? AND status = ? AND chunks > 0",
+		models.RecordingPending, recordingID, userID, models.RecordingUploading)
+	if err != nil {
+		return fmt.Errorf("finish recording: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+
+	recording, err := r.Get(ctx, userID, recordingID)
+	if err != nil {
+		return err
+	}
+	if recording.Status != models.RecordingUploading {
+		// Finishing twice is fine
+		return nil
+	}
+	return ErrRecordingEmpty
+}
+
+func (r *sqlRecordingRepository) Get(ctx context.Context, userID, recordingID int) (*models.Recording, error) {
+	recordings, err := r.load(ctx, "id = ? AND user_id = ?", recordingID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(recordings) == 0 {
+		return nil, ErrRecordingNotFound
+	}
+	return &recordings[0], nil
+}
+
+func (r *sqlRecordingRepository) ForNote(ctx context.Context, userID, noteID int) ([]models.Recording, error) {
+	return r.load(ctx, "note_id = ? AND user_id = ?", noteID, userID)
+}
+
+// load reads the recordings matching where, oldest first, decrypting their
+// transcripts.
+func (r *sqlRecordingRepository) load(ctx context.Context, where string, args ...interface{}) ([]models.Recording, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+recordingColumns+" FROM recordings WHERE "+where+" ORDER BY created_at, id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("query recordings: %w", err)
+	}
+	defer rows.Close()
+
+	var recordings []models.Recording
+	var transcripts []sql.NullString
+	for rows.Next() {
+		var rec models.Recording
+		var noteID sql.NullInt64
+		var quotaDay, transcript, failure sql.NullString
+		var durationMS int64
+		var transcribedAt sql.NullTime
+		if err := rows.Scan(&rec.ID, &rec.UserID, &noteID, &rec.MimeType, &quotaDay, &rec.Status, &rec.Chunks, &rec.Size,
+			&transcript, &durationMS, &failure, &rec.CreatedAt, &transcribedAt); err != nil {
+			return nil, fmt.Errorf("scan recording: %w", err)
+		}
+		rec.NoteID = int(noteID.Int64)
+		rec.QuotaDay = quotaDay.String
+		if !quotaDay.Valid {
+			rec.QuotaDay = rec.CreatedAt.UTC().Format(time.DateOnly)
+		}
+		rec.Duration = time.Duration(durationMS) * time.Millisecond
+		rec.Error = failure.String
+		if transcribedAt.Valid {
+			rec.TranscribedAt = &transcribedAt.Time
+		}
+		recordings = append(recordings, rec)
+		transcripts = append(transcripts, transcript)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate recordings: %w", err)
+	}
+
+	for i := range recordings {
+		if !transcripts[i].Valid {
+			continue
+		}
+		cipher, err := r.keys.ForUser(ctx, recordings[i].UserID)
+		if err != nil {
+			return nil, err
+		}
+		if err := openJSON(cipher, transcripts[i].String, &recordings[i].Segments); err != nil {
+			return nil, fmt.Errorf("decrypt transcript: %w", err)
+		}
+	}
+	return recordings, nil
+}
+
+func (r *sqlRecordingRepository) Attach(ctx context.Context, userID, noteID int, recordingIDs []int) error {
+	if len(recordingIDs) == 0 {
+		return nil
+	}
+	args := []interface{}{noteID, userID}
+	for _, id := range recordingIDs {
+		args = append(args, id)
+	}
+	args = append(args, noteID, userID)
+
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE recordings SET note_id = ?
+		WHERE user_id = ? AND note_id IS NULL AND id IN (`+placeholders(len(recordingIDs))+`)
+		AND EXISTS (SELECT 1 FROM notes WHERE id = ? AND user_id = ?)`, args...)
+	if err != nil {
+		return fmt.Errorf("attach recordings: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlRecordingRepository) Audio(ctx context.Context, userID, recordingID int) ([]byte, error) {
+	cipher, err := r.keys.ForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT c.data FROM recording_chunks c
+		JOIN recordings r ON r.id = c.recording_id
+		WHERE c.recording_id = ? AND r.user_id = ?
+		ORDER BY c.seq`, recordingID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query recording chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var audio bytes.Buffer
+	for rows.Next() {
+		var sealed string
+		if err := rows.Scan(&sealed); err != nil {
+			return nil, fmt.Errorf("scan recording chunk: %w", err)
+		}
+		chunk, err := cipher.Decrypt(sealed)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt recording chunk: %w", err)
+		}
+		audio.WriteString(chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate recording chunks: %w", err)
+	}
+	if audio.Len() == 0 {
+		return nil, ErrRecordingNotFound
+	}
+	return audio.Bytes(), nil
+}
+
+func (r *sqlRecordingRepository) ClaimPending(ctx context.Context) (*models.Recording, error) {
+	for {
+		var id int
+		err := r.db.QueryRowContext(ctx, "SELECT id FROM recordings WHERE status = ? ORDER BY created_at, id LIMIT 1",
+			models.RecordingPending).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("find pending recording: %w", err)
+		}
+
+		// Another server may claim it first, in which case try the next one
+		res, err := r.db.ExecContext(ctx, "UPDATE recordings SET status = ?, claimed_at = ? WHERE id = ? AND status = ?",
+			models.RecordingTranscribing, time.Now().UTC(), id, models.RecordingPending)
+		if err != nil {
+			return nil, fmt.Errorf("claim recording %d: %w", id, err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("claim recording %d: %w", id, err)
+		} else if n == 0 {
+			continue
+		}
+
+		recordings, err := r.load(ctx, "id = ?", id)
+		if err != nil {
+			return nil, err
+		}
+		if len(recordings) == 0 {
+			// Deleted with its note in the meantime
+			continue
+		}
+		return &recordings[0], nil
+	}
+}
+
+func (r *sqlRecordingRepository) SaveTranscript(ctx context.Context, recording *models.Recording) error {
+	cipher, err := r.keys.ForUser(ctx, recording.UserID)
+	if err != nil {
+		return err
+	}
+	transcript, err := sealJSON(cipher, recording.Segments)
+	if err != nil {
+		return fmt.Errorf("encrypt transcript: %w", err)
+	}
+
+	now := time.Now().UTC()
+	_, err = r.db.ExecContext(ctx, `
+		UPDATE recordings SET status = ?, transcript = ?, duration_ms = ?, error = NULL, transcribed_at = ?
+		WHERE id = ?`,
+		models.RecordingDone, transcript, recording.Duration.Milliseconds(), now, recording.ID)
+	if err != nil {
+		return fmt.Errorf("save transcript: %w", err)
+	}
+	recording.Status = models.RecordingDone
+	recording.TranscribedAt = &now
+	return nil
+}
+
+func (r *sqlRecordingRepository) Fail(ctx context.Context, recordingID int, reason string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE recordings SET status = ?, error = ? WHERE id = ?",
+		models.RecordingFailed, reason, recordingID)
+	if err != nil {
+		return fmt.Errorf("fail recording: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlRecordingRepository) RequeueInterrupted(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, "UPDATE recordings SET status = ?, claimed_at = NULL WHERE status = ? AND (claimed_at IS NULL OR claimed_at < ?)",
+		models.RecordingPending, models.RecordingTranscribing, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("requeue recordings: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (r *sqlRecordingRepository) PurgeUnattached(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM recordings WHERE note_id IS NULL AND created_at < ? AND status NOT IN (?, ?)",
+		cutoff.UTC(), models.RecordingPending, models.RecordingTranscribing)
+	if err != nil {
+		return 0, fmt.Errorf("purge unattached recordings: %w", err)
+	}
+	return res.RowsAffected()
+}
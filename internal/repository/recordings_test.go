@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ahsanfayaz52/diaryservice/internal/db/dbtest"
+	"github.com/ahsanfayaz52/diaryservice/internal/models"
+)
+
+// newRecording starts a recording and takes it as far as status.
+func newRecording(t *testing.T, repo RecordingRepository, userID int, status string) *models.Recording {
+	t.Helper()
+	ctx := context.Background()
+
+	recording := &models.Recording{UserID: userID, MimeType: "audio/webm"}
+	if err := repo.Create(ctx, recording); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if status == models.RecordingUploading {
+		return recording
+	}
+	if err := repo.AppendChunk(ctx, userID, recording.ID, 0, []byte("audio"), 1<<20); err != nil {
+		t.Fatalf("AppendChunk: %v", err)
+	}
+	if err := repo.Finish(ctx, userID, recording.ID); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if status == models.RecordingTranscribing {
+		claimed, err := repo.ClaimPending(ctx)
+		if err != nil || claimed == nil || claimed.ID != recording.ID {
+			t.Fatalf("ClaimPending = %v, %v", claimed, err)
+		}
+	}
+	return recording
+}
+
+func recordingStatus(t *testing.T, repo RecordingRepository, userID, recordingID int) string {
+	t.Helper()
+	recording, err := repo.Get(context.Background(), userID, recordingID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	return recording.Status
+}
+
+func TestRecordingTranscription(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	repo := NewRecordingRepository(conn, dbtest.Keys(t, conn))
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+
+	recording := newRecording(t, repo, userID, models.RecordingTranscribing)
+	audio, err := repo.Audio(ctx, userID, recording.ID)
+	if err != nil || string(audio) != "audio" {
+		t.Fatalf("Audio = %q, %v", audio, err)
+	}
+
+	recording.Segments = []models.TranscriptSegment{{Start: time.Second, End: 2 * time.Second, Text: "Hello"}}
+	if err := repo.SaveTranscript(ctx, recording); err != nil {
+		t.Fatalf("SaveTranscript: %v", err)
+	}
+	got, err := repo.Get(ctx, userID, recording.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != models.RecordingDone || got.Transcript() != "[0:01] Hello" {
+		t.Errorf("got status %q, transcript %q", got.Status, got.Transcript())
+	}
+}
+
+func TestRequeueInterrupted(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	repo := NewRecordingRepository(conn, dbtest.Keys(t, conn))
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+
+	stale := newRecording(t, repo, userID, models.RecordingTranscribing)
+	if _, err := conn.Exec("UPDATE recordings SET claimed_at = ? WHERE id = ?", time.Now().UTC().Add(-time.Hour), stale.ID); err != nil {
+		t.Fatal(err)
+	}
+	running := newRecording(t, repo, userID, models.RecordingTranscribing)
+
+	n, err := repo.RequeueInterrupted(ctx, time.Now().Add(-10*time.Minute))
+	if err != nil {
+		t.Fatalf("RequeueInterrupted: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("requeued %d recordings, want 1", n)
+	}
+	if status := recordingStatus(t, repo, userID, stale.ID); status != models.RecordingPending {
+		t.Errorf("stale claim is %q, want pending", status)
+	}
+	if status := recordingStatus(t, repo, userID, running.ID); status != models.RecordingTranscribing {
+		t.Errorf("running claim is %q, want transcribing", status)
+	}
+}
+
+func TestPurgeUnattached(t *testing.T) {
+	ctx := context.Background()
+	conn := dbtest.New(t)
+	repo := NewRecordingRepository(conn, dbtest.Keys(t, conn))
+	userID := dbtest.CreateUser(t, conn, "a@example.com", true)
+
+	// Claimed before the pending one is queued, which would be claimed first
+	failed := newRecording(t, repo, userID, models.RecordingTranscribing)
+	if err := repo.Fail(ctx, failed.ID, "broken"); err != nil {
+		t.Fatal(err)
+	}
+	keep := map[int]bool{
+		failed.ID: false,
+		newRecording(t, repo, userID, models.RecordingTranscribing).ID: true,
+		newRecording(t, repo, userID, models.RecordingPending).ID:      true,
+		newRecording(t, repo, userID, models.RecordingUploading).ID:    false,
+	}
+
+	n, err := repo.PurgeUnattached(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("PurgeUnattached: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("purged %d recordings, want 2", n)
+	}
+	for id, want := range keep {
+		_, err := repo.Get(ctx, userID, id)
+		if got := err == nil; got != want {
+			t.Errorf("recording %d kept = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -90,12 +90,20 @@ func (s *Service) CheckUserLimits(db *sql.DB, userID int) (noteLimitExceeded boo
 	// Check if subscription is active
 	subscriptionActive := isSubscribed && subEnd.Valid && subEnd.Time.After(time.Now())
 
-	// Get user limits
+	// Get user limits. Notes in the trash don't count against the limit.
 	var noteCount, meetingSecondsUsed int
 	err = db.QueryRow(`
-        SELECT COALESCE(note_count, 0), COALESCE(meeting_seconds_used, 0) 
+        SELECT COUNT(*)
+        FROM notes 
+        WHERE user_id = ? AND deleted_at IS NULL`, userID).Scan(&noteCount)
+	if err != nil {
+		return false, 0, false, fmt.Errorf("error counting notes: %w", err)
+	}
+
+	err = db.QueryRow(`
+        SELECT COALESCE(meeting_seconds_used, 0) 
         FROM user_limits 
-        WHERE user_id = ?`, userID).Scan(&noteCount, &meetingSecondsUsed)
+        WHERE user_id = ?`, userID).Scan(&meetingSecondsUsed)
 
 	if err != nil && err != sql.ErrNoRows {
 		return false, 0, false, fmt.Errorf("error getting user limits: %w", err)
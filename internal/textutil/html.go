@@ -0,0 +1,24 @@
+package textutil
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	blockTagRe = regexp.MustCompile(`(?i)<\s*(br\s*/?|/p|/div|/li|/h[1-6]|/blockquote|/pre|/tr)\s*>`)
+	tagRe      = regexp.MustCompile(`<[^>]*>`)
+	blankRe    = regexp.MustCompile(`\n{3,}`)
+)
+
+// PlainText turns the editor's HTML into readable text, keeping a line break
+// wherever a block element ended.
+func PlainText(s string) string {
+	s = blockTagRe.ReplaceAllString(s, "\n")
+	s = tagRe.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	s = strings.ReplaceAll(s, " ", " ")
+	s = blankRe.ReplaceAllString(s, "\n\n")
+	return strings.TrimSpace(s)
+}
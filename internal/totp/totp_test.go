@@ -0,0 +1,63 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// The ASCII secret "12345678901234567890" from RFC 6238 appendix B.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists eight-digit SHA-1 codes; six-digit codes are their
+	// last six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil || got != tt.want {
+			t.Errorf("Code at %d = %q, %v; want %q", tt.unix, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	for offset := int64(-2); offset <= 2; offset++ {
+		code, err := Code(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, now)
+		if want := offset >= -Skew && offset <= Skew; ok != want {
+			t.Errorf("offset %d: ok = %t, want %t", offset, ok, want)
+		} else if ok && step != current+offset {
+			t.Errorf("offset %d: step = %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+func TestValidateFormatting(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"287082", " 287 082 ", "287082\n"} {
+		if _, ok := Validate(rfcSecret, code, now); !ok {
+			t.Errorf("Validate(%q) = false", code)
+		}
+	}
+	for _, code := range []string{"", "28708", "2870820", "287083", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) = true", code)
+		}
+	}
+}
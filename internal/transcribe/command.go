@@ -0,0 +1,112 @@
+package transcribe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// segmentLine matches whisper.cpp's output, such as
+// "[00:00:01.240 --> 00:00:04.800]   Let's get started."
+var segmentLine = regexp.MustCompile(`^\[(\d+):(\d{2}):(\d{2})[.,](\d{3}) --> (\d+):(\d{2}):(\d{2})[.,](\d{3})\]\s*(.*)$`)
+
+// Command transcribes by running a local program, such as whisper.cpp's
+// whisper-cli. The recording is written to a temporary file whose path is
+// added as the last argument, and the program prints the transcript in
+// whisper.cpp's format: one "[hh:mm:ss.mmm --> hh:mm:ss.mmm] text" line per
+// segment. whisper.cpp only reads WAV, so for recordings from browsers
+// Args is usually a script that converts with ffmpeg first.
+type Command struct {
+	Args    []string
+	Timeout time.Duration
+}
+
+func (c *Command) Transcribe(ctx context.Context, audio io.Reader, name string) (*Transcript, error) {
+	ctx, cancel := withTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	file, err := os.CreateTemp("", "recording-*"+filepath.Ext(name))
+	if err != nil {
+		return nil, fmt.Errorf("create audio file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = io.Copy(file, audio)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write audio file: %w", err)
+	}
+
+	args := append(append([]string(nil), c.Args[1:]...), file.Name())
+	cmd := exec.CommandContext(ctx, c.Args[0], args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := lastLine(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("run %s: %w: %s", c.Args[0], err, msg)
+		}
+		return nil, fmt.Errorf("run %s: %w", c.Args[0], err)
+	}
+	return parseSegments(out), nil
+}
+
+// parseSegments reads whisper.cpp's output, ignoring lines that aren't
+// segments. Output with no segment lines at all is taken as the plain text.
+func parseSegments(out []byte) *Transcript {
+	t := &Transcript{}
+	var plain []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		match := segmentLine.FindStringSubmatch(line)
+		if match == nil {
+			if line != "" {
+				plain = append(plain, line)
+			}
+			continue
+		}
+		text := strings.TrimSpace(match[9])
+		if text == "" {
+			continue
+		}
+		s := Segment{Start: timestamp(match[1:5]), End: timestamp(match[5:9]), Text: text}
+		t.Segments = append(t.Segments, s)
+		if s.End > t.Duration {
+			t.Duration = s.End
+		}
+	}
+	if len(t.Segments) == 0 && len(plain) > 0 {
+		t.Segments = []Segment{{Text: strings.Join(plain, " ")}}
+	}
+	return t
+}
+
+// timestamp converts hours, minutes, seconds and milliseconds.
+func timestamp(parts []string) time.Duration {
+	var n [4]int
+	for i, p := range parts {
+		n[i], _ = strconv.Atoi(p)
+	}
+	return time.Duration(n[0])*time.Hour + time.Duration(n[1])*time.Minute +
+		time.Duration(n[2])*time.Second + time.Duration(n[3])*time.Millisecond
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Fake is a Transcriber that answers from a script instead of listening to
+// the audio, for tests and for running the app locally. Each call takes the
+// next scripted transcript or error; once the script runs out, it returns a
+// single segment saying how much audio it was given.
+type Fake struct {
+	mu     sync.Mutex
+	script []fakeResult
+	names  []string
+}
+
+type fakeResult struct {
+	transcript *Transcript
+	err        error
+}
+
+// NewFake returns a Fake that gives transcripts in order.
+func NewFake(transcripts ...*Transcript) *Fake {
+	f := &Fake{}
+	for _, t := range transcripts {
+		f.Reply(t)
+	}
+	return f
+}
+
+// Reply adds a transcript to the end of the script.
+func (f *Fake) Reply(t *Transcript) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = append(f.script, fakeResult{transcript: t})
+}
+
+// Fail adds a call that returns err to the end of the script.
+func (f *Fake) Fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = append(f.script, fakeResult{err: err})
+}
+
+// Calls returns the file names of every recording transcribed so far,
+// oldest first.
+func (f *Fake) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.names...)
+}
+
+func (f *Fake) Transcribe(ctx context.Context, audio io.Reader, name string) (*Transcript, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(io.Discard, audio)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.names = append(f.names, name)
+	var result fakeResult
+	if len(f.script) > 0 {
+		result, f.script = f.script[0], f.script[1:]
+	} else {
+		result.transcript = &Transcript{
+			Segments: []Segment{{End: 5 * time.Second, Text: fmt.Sprintf("Recording of %d bytes.", n)}},
+			Duration: 5 * time.Second,
+		}
+	}
+	f.mu.Unlock()
+
+	return result.transcript, result.err
+}
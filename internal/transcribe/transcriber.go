@@ -0,0 +1,119 @@
+// Package transcribe turns recorded meeting audio into timestamped text.
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Segment is a stretch of speech and when it was said, counted from the
+// start of the recording.
+type Segment struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Transcript is the text of a recording split into segments.
+type Transcript struct {
+	Segments []Segment
+	Language string // as detected, if the transcriber reports it
+	Duration time.Duration
+}
+
+// Transcriber is a speech-to-text engine.
+type Transcriber interface {
+	// Transcribe reads a whole recording from audio. name is a file name
+	// whose extension gives the format, such as "meeting.webm".
+	Transcribe(ctx context.Context, audio io.Reader, name string) (*Transcript, error)
+}
+
+const (
+	// ProviderOpenAI uses the OpenAI Whisper API, or another server with
+	// an OpenAI-compatible transcriptions endpoint.
+	ProviderOpenAI = "openai"
+	// ProviderCommand runs a local program such as whisper.cpp; see Command.
+	ProviderCommand = "command"
+	// ProviderFake answers without transcribing anything; see Fake.
+	ProviderFake = "fake"
+
+	defaultOpenAIModel = "whisper-1"
+)
+
+// Config selects and configures a Transcriber.
+type Config struct {
+	Provider string // "openai", "command" or "fake"
+	APIKey   string
+	// BaseURL overrides the OpenAI API address; empty uses the default.
+	BaseURL string
+	// Model defaults to whisper-1 for OpenAI.
+	Model string
+	// Command is the program and arguments run by the command provider.
+	Command []string
+	// Timeout bounds each recording's transcription.
+	Timeout time.Duration
+}
+
+// New returns the Transcriber for cfg.Provider.
+func New(cfg Config) (Transcriber, error) {
+	switch cfg.Provider {
+	case ProviderOpenAI, "":
+		if cfg.Model == "" {
+			cfg.Model = defaultOpenAIModel
+		}
+		return newWhisper(cfg), nil
+	case ProviderCommand:
+		if len(cfg.Command) == 0 {
+			return nil, fmt.Errorf("transcription provider %q needs a command", cfg.Provider)
+		}
+		return &Command{Args: cfg.Command, Timeout: cfg.Timeout}, nil
+	case ProviderFake:
+		return NewFake(), nil
+	default:
+		return nil, fmt.Errorf("unknown transcription provider %q", cfg.Provider)
+	}
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// seconds converts a time in seconds as reported by a transcriber.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// extensions maps the audio types browsers record to the file extensions
+// transcribers go by.
+var extensions = map[string]string{
+	"audio/webm":  ".webm",
+	"audio/ogg":   ".ogg",
+	"audio/mp4":   ".m4a",
+	"audio/mpeg":  ".mp3",
+	"audio/wav":   ".wav",
+	"audio/x-wav": ".wav",
+}
+
+// FileName returns a name for a recording of the given MIME type, such as
+// "recording.webm" for "audio/webm;codecs=opus".
+func FileName(mimeType string) string {
+	base, _, _ := strings.Cut(mimeType, ";")
+	if ext, ok := extensions[strings.TrimSpace(strings.ToLower(base))]; ok {
+		return "recording" + ext
+	}
+	return "recording.webm"
+}
+
+// Supported reports whether recordings of the given MIME type can be
+// transcribed.
+func Supported(mimeType string) bool {
+	base, _, _ := strings.Cut(mimeType, ";")
+	_, ok := extensions[strings.TrimSpace(strings.ToLower(base))]
+	return ok
+}
@@ -0,0 +1,60 @@
+package transcribe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// whisper calls the OpenAI audio transcriptions API.
+type whisper struct {
+	client  *openai.Client
+	model   string
+	timeout time.Duration
+}
+
+func newWhisper(cfg Config) *whisper {
+	clientCfg := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		clientCfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	}
+	return &whisper{
+		client:  openai.NewClientWithConfig(clientCfg),
+		model:   cfg.Model,
+		timeout: cfg.Timeout,
+	}
+}
+
+func (w *whisper) Transcribe(ctx context.Context, audio io.Reader, name string) (*Transcript, error) {
+	ctx, cancel := withTimeout(ctx, w.timeout)
+	defer cancel()
+
+	// verbose_json is the format that includes segment timestamps
+	resp, err := w.client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    w.model,
+		FilePath: name,
+		Reader:   audio,
+		Format:   openai.AudioResponseFormatVerboseJSON,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create transcription: %w", err)
+	}
+
+	t := &Transcript{Language: resp.Language, Duration: seconds(resp.Duration)}
+	for _, s := range resp.Segments {
+		text := strings.TrimSpace(s.Text)
+		if text == "" {
+			continue
+		}
+		t.Segments = append(t.Segments, Segment{Start: seconds(s.Start), End: seconds(s.End), Text: text})
+	}
+	// Compatible servers may only return the text
+	if len(t.Segments) == 0 && strings.TrimSpace(resp.Text) != "" {
+		t.Segments = []Segment{{End: t.Duration, Text: strings.TrimSpace(resp.Text)}}
+	}
+	return t, nil
+}
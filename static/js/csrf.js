@@ -0,0 +1,24 @@
+// Adds the page's CSRF token to fetch calls that change something on this
+// site. Forms include it as a hidden field instead.
+(function () {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    if (!meta || !window.fetch) {
+        return;
+    }
+    const token = meta.content;
+    const safeMethods = ['GET', 'HEAD', 'OPTIONS', 'TRACE'];
+    const originalFetch = window.fetch;
+
+    window.fetch = function (input, init) {
+        const isRequest = input instanceof Request;
+        const url = new URL(isRequest ? input.url : String(input), window.location.href);
+        const method = (init && init.method) || (isRequest ? input.method : 'GET');
+        if (url.origin !== window.location.origin || safeMethods.includes(method.toUpperCase())) {
+            return originalFetch.call(this, input, init);
+        }
+
+        const headers = new Headers(init && init.headers ? init.headers : (isRequest ? input.headers : undefined));
+        headers.set('X-CSRF-Token', token);
+        return originalFetch.call(this, input, Object.assign({}, init, { headers: headers }));
+    };
+})();
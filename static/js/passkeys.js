@@ -0,0 +1,74 @@
+// WebAuthn helpers for passkey registration and login. The server sends and
+// expects binary fields as base64url strings; the browser API uses
+// ArrayBuffers.
+(function () {
+    function toBuffer(value) {
+        const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+        const padded = base64 + '='.repeat((4 - base64.length % 4) % 4);
+        return Uint8Array.from(atob(padded), c => c.charCodeAt(0)).buffer;
+    }
+
+    function toBase64url(buffer) {
+        const bytes = new Uint8Array(buffer);
+        let binary = '';
+        bytes.forEach(b => binary += String.fromCharCode(b));
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    async function postJSON(url, body) {
+        const response = await fetch(url, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: body === undefined ? undefined : JSON.stringify(body),
+        });
+        const data = await response.json().catch(() => ({}));
+        if (!response.ok) {
+            throw new Error(data.message || 'Request failed');
+        }
+        return data;
+    }
+
+    window.passkeysSupported = function () {
+        return !!(window.PublicKeyCredential && navigator.credentials);
+    };
+
+    window.registerPasskey = async function (name) {
+        const { publicKey } = await postJSON('/settings/passkeys/register/begin');
+        publicKey.challenge = toBuffer(publicKey.challenge);
+        publicKey.user.id = toBuffer(publicKey.user.id);
+        (publicKey.excludeCredentials || []).forEach(c => c.id = toBuffer(c.id));
+
+        const credential = await navigator.credentials.create({ publicKey });
+        return postJSON('/settings/passkeys/register/finish?name=' + encodeURIComponent(name || ''), {
+            id: credential.id,
+            rawId: toBase64url(credential.rawId),
+            type: credential.type,
+            authenticatorAttachment: credential.authenticatorAttachment,
+            response: {
+                clientDataJSON: toBase64url(credential.response.clientDataJSON),
+                attestationObject: toBase64url(credential.response.attestationObject),
+                transports: credential.response.getTransports ? credential.response.getTransports() : [],
+            },
+        });
+    };
+
+    window.loginWithPasskey = async function () {
+        const { publicKey } = await postJSON('/login/passkey/begin');
+        publicKey.challenge = toBuffer(publicKey.challenge);
+        (publicKey.allowCredentials || []).forEach(c => c.id = toBuffer(c.id));
+
+        const credential = await navigator.credentials.get({ publicKey });
+        return postJSON('/login/passkey/finish', {
+            id: credential.id,
+            rawId: toBase64url(credential.rawId),
+            type: credential.type,
+            authenticatorAttachment: credential.authenticatorAttachment,
+            response: {
+                clientDataJSON: toBase64url(credential.response.clientDataJSON),
+                authenticatorData: toBase64url(credential.response.authenticatorData),
+                signature: toBase64url(credential.response.signature),
+                userHandle: credential.response.userHandle ? toBase64url(credential.response.userHandle) : null,
+            },
+        });
+    };
+})();